@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/logging"
+	"github.com/svetlyopet/logcat/pkg/reconcile"
+	"github.com/svetlyopet/logcat/pkg/report"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+// runDiff compares the billing log files of logcat with reference billing log files and returns the exit code
+// The exit code is 1 when there are differences, so the command can be used in scripts
+func runDiff(args []string) int {
+	var (
+		dir, prefix, referenceDir, referencePrefix, from, to, zone, format, logFormat string
+		limit                                                                         int
+		filter                                                                        report.Filter
+	)
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.StringVar(&dir, "dir", "", "Directory of the billing log files written by logcat, plain or gzip compressed")
+	flags.StringVar(&prefix, "prefix", writer.DefaultPrefix, "File name prefix of the billing log files written by logcat")
+	flags.StringVar(&referenceDir, "reference", "", "Directory of the reference billing log files, e.g. downloaded from Artifactory Cloud")
+	flags.StringVar(&referencePrefix, "reference-prefix", "", "File name prefix of the reference billing log files, all *.log and *.log.gz files when empty")
+	flags.StringVar(&from, "from", "", "Only compare billing logs from this time on, e.g. 2024-03-04 or 2024-03-04 10:00")
+	flags.StringVar(&to, "to", "", "Only compare billing logs before this time")
+	flags.StringVar(&zone, "timezone", "UTC", "Time zone of the billing log timestamps and the time range")
+	flags.StringVar(&filter.Server, "server", "", "Only compare billing logs of this server")
+	flags.StringVar(&filter.Repository, "repository", "", "Only compare billing logs of this repository")
+	flags.StringVar(&filter.User, "user", "", "Only compare billing logs of this user")
+	flags.StringVar(&filter.Project, "project", "", "Only compare billing logs of this project")
+	flags.IntVar(&limit, "limit", 20, "Number of missing, extra and mismatched entries which are printed, all of them when 0")
+	flags.StringVar(&format, "format", "table", "Output format: table or json")
+	flags.StringVar(&logFormat, "log-format", string(logging.Text), "Format of the logged messages: text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logger, err := commandLogger("diff", logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if dir == "" || referenceDir == "" {
+		logger.Error("the -dir and -reference flags are required")
+		flags.Usage()
+		return 2
+	}
+
+	if filter.Location, err = time.LoadLocation(zone); err != nil {
+		logger.Error("invalid time zone", "timezone", zone, "error", err)
+		return 2
+	}
+	if filter.From, err = parseReportTime(from, filter.Location); err != nil {
+		logger.Error("invalid -from", "error", err)
+		return 2
+	}
+	if filter.To, err = parseReportTime(to, filter.Location); err != nil {
+		logger.Error("invalid -to", "error", err)
+		return 2
+	}
+
+	reconciler := reconcile.NewReconciler(filter)
+	stats, err := report.ReadDir(dir, prefix, reconciler.AddOurs)
+	if err != nil {
+		logger.Error("failed to read billing logs", "error", err)
+		return 2
+	}
+	referenceStats, err := report.ReadDir(referenceDir, referencePrefix, reconciler.AddReference)
+	if err != nil {
+		logger.Error("failed to read reference billing logs", "error", err)
+		return 2
+	}
+	if stats.Invalid > 0 || referenceStats.Invalid > 0 {
+		logger.Warn("skipped lines which are not billing log entries", "lines", stats.Invalid, "reference_lines", referenceStats.Invalid)
+	}
+	if stats.Files == 0 || referenceStats.Files == 0 {
+		logger.Warn("found no billing log files in one of the directories", "files", stats.Files, "reference_files", referenceStats.Files)
+	}
+
+	result := reconciler.Result()
+	if err = result.Write(os.Stdout, report.Format(format), limit); err != nil {
+		logger.Error("failed to print differences", "error", err)
+		return 2
+	}
+	if result.Differences() > 0 {
+		return 1
+	}
+	return 0
+}
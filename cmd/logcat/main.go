@@ -2,49 +2,180 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/hpcloud/tail"
-
+	"github.com/svetlyopet/logcat/pkg/checkpoint"
+	"github.com/svetlyopet/logcat/pkg/config"
+	"github.com/svetlyopet/logcat/pkg/dedup"
+	"github.com/svetlyopet/logcat/pkg/hook"
+	"github.com/svetlyopet/logcat/pkg/input"
+	"github.com/svetlyopet/logcat/pkg/logging"
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/pricing"
+	"github.com/svetlyopet/logcat/pkg/quota"
+	"github.com/svetlyopet/logcat/pkg/spill"
+	"github.com/svetlyopet/logcat/pkg/wal"
 	"github.com/svetlyopet/logcat/pkg/worker"
 	"github.com/svetlyopet/logcat/pkg/writer"
 )
 
 var (
 	// variables to store cmd args
-	file   string
-	outdir string
+	file         string
+	outdir       string
+	deadLetters  string
+	configPath   string
+	serverName   string
+	workers      int
+	minWorkers   int
+	maxWorkers   int
+	delimiter    string
+	columns      string
+	timezone     string
+	projects     string
+	users        string
+	sites        string
+	siteDB       string
+	excludeSites string
+	ipPolicy     string
+	userPolicy   string
+	secret       string
+	prices       string
+	quotas       string
+	metricsAddr  string
+	stateDir     string
+	dedupWindow  time.Duration
+	dedupMax     int
+	drainTimeout time.Duration
+	overload     string
+	spillFile    string
+	spillMax     int64
+	lagBytes     int64
+	lagDelay     time.Duration
+	walDir       string
+	onTruncate   string
+	onRotate     string
+	hookURL      string
+	hookExec     string
+	hookTimeout  time.Duration
+	hookRetries  int
+	outputFormat writer.Format
+	schemaPath   string
+	logLevel     string
+	logFormat    string
+	logRepeat    time.Duration
+	writeBudget  time.Duration
+	writeBuffer  int
+	bufferSize   int
+	flushEvery   time.Duration
+	durability   writer.Durability
+
+	// create work queue for the workers, result queue for the reorder buffer, write queue for the writer
+	// and dead-letter queue for lines rejected by the workers
+	workQueue       = make(chan worker.WorkRequest, 100)
+	resultQueue     = make(chan worker.Result, 100)
+	writeQueue      = make(chan writer.WriteRequest, 100)
+	deadLetterQueue = make(chan writer.WriteRequest, 100)
 
-	// create work queue for the workers and write queue for the writer
-	workQueue  = make(chan worker.WorkRequest, 100)
-	writeQueue = make(chan string, 100)
+	// create done channels for the writers
+	doneChan           = make(chan bool)
+	deadLetterDoneChan = make(chan bool)
 
-	// create a done channel for the writer
-	doneChan = make(chan bool)
+	// create a reload channel for reloading the config on SIGHUP
+	reloadChan = make(chan bool, 1)
 
 	// create a wait group to track the workers
 	wg sync.WaitGroup
 )
 
+const (
+	// checkpointInterval is how often the input offset of the written lines is persisted
+	checkpointInterval = 10 * time.Second
+	// spillFileName is the name of the spill file in the state directory when no other is set
+	spillFileName = "spill.log"
+)
+
 // PrintHelp prints out to stdout help information about this program and exits
 func PrintHelp() {
 	fmt.Println("Usage: logcat -file [FILEPATH] -outdir [DIRECTORY]")
+	fmt.Println("       logcat report -dir [DIRECTORY] [-group-by KEYS] [-top N] [-format table|csv|json]")
+	fmt.Println("       logcat diff -dir [DIRECTORY] -reference [DIRECTORY] [-limit N] [-format table|json]")
 	fmt.Println("Example: logcat -file /opt/artifactory/var/log/artifactory-requests.log -outdir /tmp")
 	os.Exit(1)
 }
 
 func main() {
+	// the report subcommand prints the billing usage of existing billing log files
+	// and the diff subcommand compares them with reference billing log files
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(runReport(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+
 	// parse the cli flags
 	flag.StringVar(&file, "file", "", "Path to file we are parsing")
 	flag.StringVar(&outdir, "outdir", "", "Directory for writing billing logs to")
+	flag.StringVar(&deadLetters, "dead-letter-dir", "", "Directory only accessible by the owner for writing the rejected input lines to (default <outdir>/dead-letter)")
+	flag.StringVar(&configPath, "config", "", "Path to a JSON config file overriding the flags, reloaded on SIGHUP")
+	flag.StringVar(&serverName, "server-name", "artifactory.domain", "Server name written to the billing logs")
+	flag.IntVar(&workers, "workers", 5, "Number of workers parsing the log lines")
+	flag.IntVar(&minWorkers, "min-workers", 0, "Minimum number of workers when autoscaling the pool")
+	flag.IntVar(&maxWorkers, "max-workers", 0, "Maximum number of workers when autoscaling the pool, autoscaling is disabled when 0")
+	flag.StringVar(&delimiter, "delimiter", "|", "Delimiter separating the fields of the input log")
+	flag.StringVar(&columns, "columns", strings.Join(parser.DefaultColumns, ","), "Comma separated field names of the input log columns in order, use \"-\" for columns which should be ignored")
+	flag.StringVar(&timezone, "timezone", "UTC", "Time zone of the billing log timestamps, e.g. UTC, Local or Europe/Berlin")
+	flag.StringVar(&projects, "projects", "", "Path to a JSON file mapping repositories to projects")
+	flag.StringVar(&users, "users", "", "Path to a CSV or JSON user directory export for enriching billing logs, reloaded when modified")
+	flag.StringVar(&sites, "sites", "", "Path to a CIDR table mapping remote IPs to sites, reloaded when modified")
+	flag.StringVar(&siteDB, "site-db", "", "Path to an offline MaxMind DB format database used for remote IPs not found in the CIDR table")
+	flag.StringVar(&excludeSites, "exclude-sites", "", "Comma separated sites of which the requests are not billed, e.g. internal replication traffic")
+	flag.StringVar(&ipPolicy, "ip-policy", "keep", "Privacy policy for the ip field: keep, drop, truncate or hmac")
+	flag.StringVar(&userPolicy, "user-policy", "keep", "Privacy policy for the user_name field: keep, drop or hmac")
+	flag.StringVar(&secret, "hmac-secret", "", "Path to the secret file used by the hmac privacy policy, reloaded when rotated")
+	flag.StringVar(&prices, "prices", "", "Path to a JSON price table for calculating the cost of each request, reloaded on SIGHUP")
+	flag.StringVar(&quotas, "quotas", "", "Path to a JSON file with monthly download quotas of users, projects and repositories, reloaded on SIGHUP")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address for exposing metrics on /debug/vars, e.g. localhost:9090")
+	flag.StringVar(&stateDir, "state-dir", "", "Directory for persisting state across restarts")
+	flag.DurationVar(&dedupWindow, "dedup-window", 0, "Time window for dropping duplicate requests by trace ID, e.g. 1h, disabled when 0")
+	flag.IntVar(&dedupMax, "dedup-max-entries", 100000, "Maximum number of requests remembered for dropping duplicates")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "Maximum time for writing the queued lines on shutdown, the remaining lines are abandoned")
+	flag.StringVar(&overload, "overload-policy", "block", "What happens to input lines when the pipeline can not keep up: block, spill or drop")
+	flag.StringVar(&spillFile, "spill-file", "", "Path to the file buffering input lines with the spill overload policy, defaults to spill.log in the state directory")
+	flag.Int64Var(&spillMax, "spill-max-bytes", spill.DefaultMaxBytes, "Maximum size of the spill file, lines which do not fit are dropped")
+	flag.Int64Var(&lagBytes, "lag-alert-bytes", 0, "Log an alert when the output is more than this many bytes of input behind, disabled when 0")
+	flag.DurationVar(&lagDelay, "lag-alert-delay", 5*time.Minute, "Log an alert when the oldest input line which is not written is older than this, disabled when 0")
+	flag.StringVar(&walDir, "wal-dir", "", "Directory of the write-ahead log which keeps billing log entries until they are written, disabled when empty")
+	flag.StringVar(&onTruncate, "truncate-policy", "start", "Where reading continues when the input file is truncated, e.g. by copytruncate: start or skip")
+	flag.StringVar(&onRotate, "rotate-policy", "start", "Where reading continues when the input file is replaced by a new one: start or skip")
+	flag.StringVar(&hookURL, "hook-url", "", "URL a JSON event is posted to when a billing log file is finalized")
+	flag.StringVar(&hookExec, "hook-exec", "", "Command which is run with a JSON event on its standard input when a billing log file is finalized")
+	flag.DurationVar(&hookTimeout, "hook-timeout", hook.DefaultTimeout, "Maximum time a file hook can run")
+	flag.IntVar(&hookRetries, "hook-retries", 3, "Number of times a failed file hook is retried")
+	flag.StringVar((*string)(&outputFormat), "output-format", string(writer.JSON), "Format of the billing log files: json, ndjson, csv or parquet")
+	flag.StringVar(&schemaPath, "schema", "", "Path to a JSON output schema enabling optional fields, renaming fields and overriding constant fields")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum level of the logged messages: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", string(logging.Text), "Format of the logged messages: text or json")
+	flag.DurationVar(&logRepeat, "log-repeat-interval", logging.DefaultRepeatInterval, "Time during which a repeated warning or error is logged only once, disabled when 0")
+	flag.DurationVar(&writeBudget, "writer-failure-budget", writer.DefaultFailureBudget, "How long the writers can fail to open an output file before logcat stops")
+	flag.IntVar(&writeBuffer, "writer-max-buffered", writer.DefaultMaxBuffered, "Number of lines a writer buffers while it can not open an output file")
+	flag.IntVar(&bufferSize, "writer-buffer-size", writer.DefaultBufferSize, "Size in bytes of the buffer the lines are collected in before they are written to the output file")
+	flag.DurationVar(&flushEvery, "writer-flush-interval", writer.DefaultFlushInterval, "How often the buffered lines are written to the output file")
+	flag.StringVar((*string)(&durability), "writer-durability", string(writer.DurabilityNone), "When the output files are synced to disk: none, flush or rotation")
 	flag.Parse()
 
 	// ensure file and outdir are absolute paths
@@ -54,52 +185,244 @@ func main() {
 	}
 
 	// create a logger
-	logger := log.New(os.Stdout, "logcat: ", log.Ldate|log.Ltime)
+	logger, err := logging.New(logging.Config{
+		Output:         os.Stdout,
+		Format:         logging.Format(logFormat),
+		Level:          logLevel,
+		RepeatInterval: logRepeat,
+	})
+	if err != nil {
+		fmt.Println(err)
+		PrintHelp()
+	}
+
+	// the rejected input lines are written as they are, so they are kept apart from the billing logs
+	if deadLetters == "" {
+		deadLetters = filepath.Join(outdir, "dead-letter")
+	}
+	if err := os.MkdirAll(deadLetters, 0700); err != nil {
+		fatal(logger, "failed to create dead-letter directory", "error", err)
+	}
+	if info, err := os.Stat(deadLetters); err == nil && info.Mode().Perm()&0077 != 0 {
+		logger.Warn("dead-letter directory is accessible by other users, the rejected input lines contain personal data", "dir", deadLetters, "mode", info.Mode().Perm())
+	}
+
+	if err := outputFormat.Validate(); err != nil {
+		fatal(logger, "invalid output format", "error", err)
+	}
+	if err := durability.Validate(); err != nil {
+		fatal(logger, "invalid writer durability", "error", err)
+	}
+
+	// the output schema is only read on start, so the fields do not change within a billing log file
+	outputSchema, err := parser.NewSchema(parser.Schema{})
+	if schemaPath != "" {
+		outputSchema, err = parser.LoadSchema(schemaPath)
+	}
+	if err != nil {
+		fatal(logger, "invalid output schema", "error", err)
+	}
+	var outputFields []writer.Field
+	for _, column := range outputSchema.Columns() {
+		outputFields = append(outputFields, writer.Field{Name: column.Name, Type: writer.FieldType(column.Type)})
+	}
+
+	// the flags are the base config which is overridden by the config file
+	baseConfig := config.Config{
+		ServerName:      serverName,
+		Workers:         workers,
+		MinWorkers:      minWorkers,
+		MaxWorkers:      maxWorkers,
+		Delimiter:       delimiter,
+		Columns:         strings.Split(columns, ","),
+		Timezone:        timezone,
+		Projects:        projects,
+		Users:           users,
+		Sites:           sites,
+		SiteDB:          siteDB,
+		IPPolicy:        ipPolicy,
+		UserPolicy:      userPolicy,
+		HMACSecret:      secret,
+		Prices:          prices,
+		Quotas:          quotas,
+		DedupWindow:     config.Duration(dedupWindow),
+		DedupMaxEntries: dedupMax,
+	}
+	if excludeSites != "" {
+		baseConfig.ExcludeSites = strings.Split(excludeSites, ",")
+	}
+
+	cfg, err := config.Load(configPath, baseConfig)
+	if err != nil {
+		fatal(logger, "invalid config", "error", err)
+	}
 
-	// use context to handle sys signals
+	// use context to handle sys signals, SIGHUP reloads the config
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-		<-sigCh
-		cancel()
+		for sig := range sigCh {
+			if sig == syscall.SIGHUP {
+				select {
+				case reloadChan <- true:
+				default:
+				}
+				continue
+			}
+			cancel()
+			return
+		}
 	}()
 
-	// start reading lines from the file we are monitoring
-	t, err := tail.TailFile(file, tail.Config{
-		Follow: true,
-		ReOpen: true,
-		Logger: logger,
-		Location: &tail.SeekInfo{
-			Offset: io.SeekCurrent,
-			Whence: io.SeekEnd,
-		},
-	})
+	// the writers stop logcat when they can not open an output file for longer than the failure budget
+	var writerFailed atomic.Bool
+	failed := func(err error) {
+		logger.Error("stopping logcat because a writer can not open an output file", "error", err)
+		writerFailed.Store(true)
+		cancel()
+	}
+
+	// expose the metrics if requested
+	if metricsAddr != "" {
+		metrics.Serve(metricsAddr, logging.Component(logger, "metrics"))
+	}
+
+	// the input position of the last line which was read
+	var read int64
+	position := func() int64 { return atomic.LoadInt64(&read) }
+
+	// build the log format and the stages which process each billing log entry
+	current, err := buildPipeline(ctx, cfg, nil, stateDir, position, logger)
 	if err != nil {
-		logger.Fatalf("failed to tail log file: %v", err)
+		fatal(logger, "failed to build pipeline", "error", err)
+	}
+	logFormat := current.logFormat
+
+	// the writer records the privacy policies and the price table version of the current pipeline in the file manifests
+	var currentPipeline atomic.Pointer[pipeline]
+	currentPipeline.Store(current)
+
+	// continue reading after the last written line if there is a checkpoint, otherwise from the end of the file
+	inputConfig := input.Config{
+		Path:       file,
+		OnTruncate: input.Policy(onTruncate),
+		OnRotate:   input.Policy(onRotate),
+		Logger:     logging.Component(logger, "input"),
+	}
+	if stateDir != "" && !input.Resumable() {
+		logger.Warn("inode numbers are not available on this platform, reading starts at the end of the input file instead of the checkpoint")
+	}
+	if stateDir != "" {
+		saved, err := checkpoint.Load(filepath.Join(stateDir, checkpoint.StateFile))
+		if err != nil {
+			logger.Error("failed to restore checkpoint, reading from the end of the file", "error", err)
+		}
+		if saved.File == file {
+			inputConfig.Inode, inputConfig.Offset = saved.Inode, saved.Offset
+		}
 	}
 
-	// define the log format and number of fields that should be present in the log file we are reading from
-	// this is used by the collector which does the sanity check for input log lines
-	logFormat := worker.LogFormat{
-		Delimiter: "|",
-		NumFields: 11,
+	// start reading lines from the file we are monitoring
+	follower, err := input.Follow(inputConfig)
+	if err != nil {
+		fatal(logger, "failed to follow log file", "error", err)
 	}
 
 	// create a config for the work dispatcher
 	dispatcherConfig := worker.Dispatcher{
-		ServerName:  "artifactory.domain",
-		Workers:     5,
-		WorkQueue:   workQueue,
-		OutputQueue: writeQueue,
-		WaitGroup:   &wg,
-		Logger:      logger,
+		ServerName:      current.config.ServerName,
+		Location:        current.location,
+		Stages:          current.stages,
+		Schema:          outputSchema,
+		Workers:         current.config.Workers,
+		MinWorkers:      current.config.MinWorkers,
+		MaxWorkers:      current.config.MaxWorkers,
+		WorkQueue:       workQueue,
+		OutputQueue:     resultQueue,
+		DeadLetterQueue: deadLetterQueue,
+		WaitGroup:       &wg,
+		Logger:          logging.Component(logger, "dispatcher"),
+		WorkerLogger:    logging.Component(logger, "worker"),
 	}
 
 	// create a work Dispatcher implementation
 	dispatcherImpl := worker.NewDispatcher(dispatcherConfig)
-	dispatcherImpl.Start()
+	dispatcherImpl.Start(context.Background())
+
+	// create a write-ahead log which keeps the billing log entries on disk until the writer wrote them
+	reordererQueue := writeQueue
+	var walLog *wal.Log
+	var acks chan error
+	if walDir != "" {
+		reordererQueue = make(chan writer.WriteRequest, 100)
+		acks = make(chan error)
+		walLog, err = wal.Open(wal.Config{
+			Dir:         walDir,
+			InputQueue:  reordererQueue,
+			OutputQueue: writeQueue,
+			Acks:        acks,
+			Logger:      logging.Component(logger, "wal"),
+		})
+		if err != nil {
+			fatal(logger, "failed to open write-ahead log", "error", err)
+		}
+		walLog.Start()
+	}
+
+	// create a reorder buffer so the billing log entries are written in the order of the input lines
+	reordererImpl := worker.NewReorderer(worker.Reorderer{
+		InputQueue:  resultQueue,
+		OutputQueue: reordererQueue,
+		MaxPending:  worker.DefaultMaxPending,
+		Logger:      logging.Component(logger, "reorder"),
+	})
+	reordererImpl.Start()
+
+	// create the intake which applies the overload policy when the pipeline can not keep up with the input
+	intakeConfig := worker.IntakeConfig{
+		Policy:    worker.OverloadPolicy(overload),
+		WorkQueue: workQueue,
+		Reorderer: reordererImpl,
+		Logger:    logging.Component(logger, "intake"),
+	}
+	if intakeConfig.Policy == worker.Spill {
+		if spillFile == "" && stateDir != "" {
+			spillFile = filepath.Join(stateDir, spillFileName)
+		}
+		if spillFile == "" {
+			fatal(logger, "the spill overload policy requires -spill-file or -state-dir")
+		}
+		if intakeConfig.Spill, err = spill.Open(spillFile, spillMax); err != nil {
+			fatal(logger, "failed to open spill file", "error", err)
+		}
+	}
+	intake, err := worker.NewIntake(intakeConfig)
+	if err != nil {
+		fatal(logger, "invalid overload policy", "error", err)
+	}
+	intake.Start(context.Background())
+
+	// run the file hooks in the background when an output file is finalized
+	var hooks []hook.Hook
+	if hookURL != "" {
+		hooks = append(hooks, hook.NewWebhook(hook.Webhook{URL: hookURL}))
+	}
+	if hookExec != "" {
+		execHook, err := hook.NewExec(hook.Exec{Command: strings.Fields(hookExec)})
+		if err != nil {
+			fatal(logger, "invalid file hook", "error", err)
+		}
+		hooks = append(hooks, execHook)
+	}
+	hookRunner := hook.NewRunner(hook.Runner{
+		Hooks:   hooks,
+		Timeout: hookTimeout,
+		Retries: hookRetries,
+		Logger:  logging.Component(logger, "hook"),
+	})
+	hookRunner.Start()
 
 	writerConfig := writer.Writer{
 		Directory:   outdir,
@@ -107,33 +430,221 @@ func main() {
 		Permissions: 0644,
 		WriteQueue:  writeQueue,
 		DoneChan:    doneChan,
-		Logger:      logger,
+		Acks:        acks,
+		Logger:      logging.Component(logger, "writer"),
+		Finalized: func(event writer.FileEvent) {
+			if len(hooks) == 0 {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Error("failed to encode file event", "error", err)
+				return
+			}
+			hookRunner.Notify(data)
+		},
+		Metadata: func(offset int64) map[string]string {
+			metadata := currentPipeline.Load().metadata(offset)
+			metadata["format"] = string(outputFormat)
+			metadata["schema_version"] = strconv.Itoa(outputSchema.Version)
+			for key, value := range outputSchema.Metadata() {
+				metadata[key] = value
+			}
+			return metadata
+		},
+		Changed: func(from, to int64) bool {
+			return currentPipeline.Load().privacy.Changed(from, to)
+		},
+		Format:        outputFormat,
+		Fields:        outputFields,
+		SchemaVersion: outputSchema.Version,
+		MaxBuffered:   writeBuffer,
+		FailureBudget: writeBudget,
+		Failed:        failed,
+		BufferSize:    bufferSize,
+		FlushInterval: flushEvery,
+		Durability:    durability,
 	}
 
 	// create a Writer implementation
 	writerImpl := writer.NewWriter(writerConfig)
 	err = writerImpl.Start()
 	if err != nil {
-		logger.Fatalf("failed to initialize writer: %v", err)
+		fatal(logger, "failed to initialize writer", "error", err)
+	}
+
+	deadLetterWriterConfig := writer.Writer{
+		Directory:     deadLetters,
+		Prefix:        "logcat-dead-letter",
+		Flag:          os.O_CREATE | os.O_APPEND | os.O_WRONLY,
+		Permissions:   0600,
+		WriteQueue:    deadLetterQueue,
+		DoneChan:      deadLetterDoneChan,
+		Logger:        logging.Component(logger, "dead-letter-writer"),
+		MaxBuffered:   writeBuffer,
+		FailureBudget: writeBudget,
+		Failed:        failed,
+		BufferSize:    bufferSize,
+		FlushInterval: flushEvery,
+		Durability:    durability,
+	}
+
+	// create a Writer implementation for the lines rejected by the workers
+	deadLetterWriterImpl := writer.NewWriter(deadLetterWriterConfig)
+	err = deadLetterWriterImpl.Start()
+	if err != nil {
+		fatal(logger, "failed to initialize dead-letter writer", "error", err)
+	}
+
+	// the input offset up to which the lines are safe from a crash, which are the lines written to the
+	// write-ahead log when there is one and otherwise the lines written by the writer
+	written, durable := writerImpl.Checkpoint, writerImpl.Checkpoint
+	if walLog != nil {
+		written, durable = walLog.Written, walLog.Checkpoint
 	}
 
+	// alert when the written output falls behind the input
+	lagMonitor := worker.NewLagMonitor(worker.LagMonitor{
+		MaxBytes: lagBytes,
+		MaxDelay: lagDelay,
+		Logger:   logging.Component(logger, "lag"),
+		Read:     position,
+		Written:  written,
+	})
+	lagMonitor.Start(ctx)
+
+	// persist the checkpoint periodically so a crash does not lose more than the last interval
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
+	var saved int64
+
 	for {
 		select {
-		case line := <-t.Lines:
-			// send log lines from the tail channel to the collector
-			worker.Collector(line.Text, logFormat, workQueue)
+		case line := <-follower.Lines:
+			// send log lines from the input file to the collector
+			atomic.StoreInt64(&read, line.Position)
+			if err = intake.Add(ctx, line.Text, line.Position, logFormat); err != nil {
+				logger.Error("stopped waiting for the pipeline to accept line", "error", err)
+			}
+		case <-checkpointTicker.C:
+			if offset := durable(); stateDir != "" && offset != saved {
+				if err = saveState(follower, current, offset); err != nil {
+					logger.Error("failed to persist checkpoint", "error", err)
+					continue
+				}
+				saved = offset
+			}
+		case <-reloadChan:
+			// build the new pipeline and keep the current one running if the new config is invalid
+			newCfg, err := config.Load(configPath, baseConfig)
+			if err != nil {
+				logger.Error("rejected config reload, keeping the current config", "error", err)
+				continue
+			}
+			next, err := buildPipeline(ctx, newCfg, current, stateDir, position, logger)
+			if err != nil {
+				logger.Error("rejected config reload, keeping the current config", "error", err)
+				continue
+			}
+
+			changes := config.Diff(current.config, newCfg)
+			if len(changes) == 0 {
+				logger.Info("reloaded config without changes, mapping files were read again")
+			}
+			for _, change := range changes {
+				logger.Info("reloaded config", "change", change)
+			}
+
+			// the queued lines are processed by the new workers and the current output file stays open
+			logFormat = next.logFormat
+			dispatcherImpl.Reconfigure(next.settings())
+			currentPipeline.Store(next)
+			current.close()
+			current = next
 		case <-ctx.Done():
 			// gracefully stop everything
-			if err = t.Stop(); err != nil {
-				logger.Printf("failed to gracefully stop tailing input file: %v", err)
+			if err = follower.Stop(); err != nil {
+				logger.Error("failed to gracefully stop tailing input file", "error", err)
+			}
+			// drain the queued lines until the deadline, the lines which are left are abandoned
+			drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+			abandoned, _ := intake.Stop(drainCtx)
+			n, _ := dispatcherImpl.Stop(drainCtx)
+			abandoned += n
+			n, _ = reordererImpl.Stop(drainCtx)
+			abandoned += n
+			if walLog != nil {
+				n, _ = walLog.Stop(drainCtx)
+				abandoned += n
+			}
+			current.close()
+
+			// wait until the writers wrote the queued lines
+			n, _ = writerImpl.Stop(drainCtx)
+			abandoned += n
+			deadLetters, _ := deadLetterWriterImpl.Stop(drainCtx)
+			if err = hookRunner.Stop(drainCtx); err != nil {
+				logger.Error("stopped running file hooks before they finished", "error", err)
+			}
+			drainCancel()
+			if abandoned > 0 || deadLetters > 0 {
+				logger.Warn("lines were abandoned on shutdown, draining took longer than the drain timeout or a writer was degraded", "timeout", drainTimeout, "abandoned_lines", abandoned, "abandoned_dead_letters", deadLetters)
+				metrics.AbandonedLines.Add(int64(abandoned + deadLetters))
 			}
-			dispatcherImpl.Stop()
-			writerImpl.Stop()
 
-			// wait until a done signal is sent from the writer
-			<-writerImpl.DoneChan
-			logger.Printf("logcat stopped successfully")
+			// only the lines which were written are covered by the checkpoint
+			if stateDir != "" {
+				if err = saveState(follower, current, durable()); err != nil {
+					logger.Error("failed to persist checkpoint", "error", err)
+				}
+			}
+			if writerFailed.Load() {
+				fatal(logger, "logcat stopped because a writer failed")
+			}
+			logger.Info("logcat stopped successfully")
 			return
 		}
 	}
 }
+
+// saveState persists the checkpoint and the state of the pipeline in the state directory
+// The checkpoint is saved first and the stages only save the state of the lines up to it,
+// because the lines after it are read again on the next start
+func saveState(follower *input.Follower, p *pipeline, position int64) error {
+	if err := saveCheckpoint(follower, position); err != nil {
+		return err
+	}
+	if p.deduplicator != nil {
+		if err := p.deduplicator.Save(filepath.Join(stateDir, dedup.StateFile), position); err != nil {
+			return err
+		}
+	}
+	if p.pricer != nil {
+		if err := p.pricer.Save(filepath.Join(stateDir, pricing.StateFile), position); err != nil {
+			return err
+		}
+	}
+	if p.quotas != nil {
+		if err := p.quotas.Save(filepath.Join(stateDir, quota.StateFile), position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveCheckpoint persists the inode and the offset of the input file up to which the lines were written in the state directory
+// The position is where the written lines end in the input stream of the follower
+func saveCheckpoint(follower *input.Follower, position int64) error {
+	if position == 0 {
+		return nil
+	}
+	inode, offset := follower.Locate(position)
+	c := checkpoint.Checkpoint{File: file, Inode: inode, Offset: offset}
+	return c.Save(filepath.Join(stateDir, checkpoint.StateFile))
+}
+
+// fatal logs an error and exits
+func fatal(logger *slog.Logger, msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
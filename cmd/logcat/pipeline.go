@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/config"
+	"github.com/svetlyopet/logcat/pkg/dedup"
+	"github.com/svetlyopet/logcat/pkg/enrich"
+	"github.com/svetlyopet/logcat/pkg/logging"
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/pricing"
+	"github.com/svetlyopet/logcat/pkg/privacy"
+	"github.com/svetlyopet/logcat/pkg/project"
+	"github.com/svetlyopet/logcat/pkg/quota"
+	"github.com/svetlyopet/logcat/pkg/worker"
+)
+
+// reloadInterval is how often the mapping files are checked for changes
+const reloadInterval = 30 * time.Second
+
+// pipeline contains everything built out of the config which is replaced on a config reload
+type pipeline struct {
+	config       config.Config
+	logFormat    worker.LogFormat
+	location     *time.Location
+	stages       []worker.Stage
+	deduplicator *dedup.Deduplicator
+	privacy      *privacy.Privacy
+	pricer       *pricing.Pricer
+	quotas       *quota.Tracker
+	sites        *enrich.Sites
+
+	// cancel stops the file watchers of the stages
+	cancel context.CancelFunc
+}
+
+// buildPipeline loads the mapping files and creates the stages of a config
+// The deduplicator of the previous pipeline is kept when its settings did not change,
+// so the remembered requests are not lost on a reload
+// Position returns the input position of the last line which was read
+func buildPipeline(ctx context.Context, c config.Config, previous *pipeline, stateDir string, position func() int64, logger *slog.Logger) (*pipeline, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &pipeline{
+		config: c,
+		cancel: cancel,
+	}
+	if err := p.build(ctx, previous, stateDir, position, logger); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// build creates the components of the pipeline out of its config
+func (p *pipeline) build(ctx context.Context, previous *pipeline, stateDir string, position func() int64, logger *slog.Logger) error {
+	c := p.config
+
+	// define the log format and number of fields that should be present in the log file we are reading from
+	// this is used by the collector which does the sanity check for input log lines
+	fields, err := parser.NewFieldMap(c.Columns)
+	if err != nil {
+		return err
+	}
+	p.logFormat = worker.LogFormat{
+		Delimiter: c.Delimiter,
+		NumFields: len(c.Columns),
+		Fields:    fields,
+	}
+	if err = p.logFormat.Validate(); err != nil {
+		return err
+	}
+
+	// load the time zone of the billing log timestamps
+	p.location, err = time.LoadLocation(c.Timezone)
+	if err != nil {
+		return err
+	}
+
+	// create the stages which process each billing log entry
+	if c.DedupWindow > 0 {
+		if previous != nil && previous.deduplicator != nil &&
+			previous.config.DedupWindow == c.DedupWindow && previous.config.DedupMaxEntries == c.DedupMaxEntries {
+			p.deduplicator = previous.deduplicator
+		} else {
+			p.deduplicator, err = dedup.NewDeduplicator(time.Duration(c.DedupWindow), c.DedupMaxEntries)
+			if err != nil {
+				return err
+			}
+			if stateDir != "" {
+				if err = p.deduplicator.Load(filepath.Join(stateDir, dedup.StateFile)); err != nil {
+					return err
+				}
+			}
+		}
+		p.stages = append(p.stages, p.deduplicator)
+	}
+	if c.Projects != "" {
+		resolver, err := project.LoadResolver(c.Projects)
+		if err != nil {
+			return err
+		}
+		p.stages = append(p.stages, resolver)
+	}
+	if c.Users != "" {
+		users, err := enrich.LoadUsers(c.Users, logging.Component(logger, "users"))
+		if err != nil {
+			return err
+		}
+		users.Watch(ctx, reloadInterval)
+		p.stages = append(p.stages, users)
+	}
+	if c.Sites != "" || c.SiteDB != "" {
+		p.sites, err = enrich.LoadSites(enrich.SitesConfig{
+			CIDRPath:     c.Sites,
+			DatabasePath: c.SiteDB,
+			Exclude:      c.ExcludeSites,
+		}, logging.Component(logger, "sites"))
+		if err != nil {
+			return err
+		}
+		p.sites.Watch(ctx, reloadInterval)
+		p.stages = append(p.stages, p.sites)
+	}
+
+	// the cost is calculated after the enrichment, so the rates can depend on the project and the site
+	if c.Prices != "" {
+		p.pricer, err = pricing.LoadPricer(c.Prices)
+		if err != nil {
+			return err
+		}
+		// the used free allowances are shared with the previous pipeline on a reload and restored on a start
+		if previous != nil && previous.pricer != nil {
+			p.pricer.Inherit(previous.pricer)
+		} else if stateDir != "" {
+			if err = p.pricer.Load(filepath.Join(stateDir, pricing.StateFile)); err != nil {
+				return err
+			}
+		}
+		p.stages = append(p.stages, p.pricer)
+	}
+	if c.Quotas != "" {
+		p.quotas, err = quota.LoadTracker(c.Quotas, logging.Component(logger, "quota"))
+		if err != nil {
+			return err
+		}
+		// the monthly totals are shared with the previous pipeline on a reload and restored on a start
+		if previous != nil && previous.quotas != nil {
+			p.quotas.Inherit(previous.quotas)
+		} else if stateDir != "" {
+			if err = p.quotas.Load(filepath.Join(stateDir, quota.StateFile)); err != nil {
+				return err
+			}
+		}
+		p.quotas.Start(ctx)
+		p.stages = append(p.stages, p.quotas)
+	}
+
+	// the privacy policies are applied last so the other stages can use the original values
+	p.privacy, err = privacy.NewPrivacy(privacy.Config{
+		IP:         privacy.Policy(c.IPPolicy),
+		User:       privacy.Policy(c.UserPolicy),
+		SecretPath: c.HMACSecret,
+		Position:   position,
+	}, logging.Component(logger, "privacy"))
+	if err != nil {
+		return err
+	}
+	// the lines read before a reload are pseudonymized with the secrets they were read with
+	if previous != nil {
+		p.privacy.Inherit(previous.privacy)
+	}
+	p.privacy.Watch(ctx, reloadInterval)
+	p.stages = append(p.stages, p.privacy)
+
+	return nil
+}
+
+// metadata returns the settings of the pipeline for the line at an input offset which are recorded in the manifest of each output file
+func (p *pipeline) metadata(offset int64) map[string]string {
+	metadata := p.privacy.Metadata(offset)
+	if p.pricer != nil {
+		for key, value := range p.pricer.Metadata() {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// settings returns the dispatcher settings of the pipeline
+func (p *pipeline) settings() worker.Settings {
+	return worker.Settings{
+		ServerName: p.config.ServerName,
+		Location:   p.location,
+		Stages:     p.stages,
+		Workers:    p.config.Workers,
+		MinWorkers: p.config.MinWorkers,
+		MaxWorkers: p.config.MaxWorkers,
+	}
+}
+
+// close stops the file watchers and releases the resources of the pipeline
+// It must only be called when no worker uses the pipeline anymore
+func (p *pipeline) close() {
+	p.cancel()
+	if p.sites != nil {
+		if err := p.sites.Close(); err != nil {
+			p.sites.Logger.Error("failed to close site database", "error", err)
+		}
+	}
+}
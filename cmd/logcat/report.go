@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/logging"
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/report"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+// reportTimeLayouts are the accepted layouts of the report time range flags
+var reportTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// runReport prints the billing usage of a directory of billing log files and returns the exit code
+func runReport(args []string) int {
+	var (
+		dir, prefix, from, to, groupBy, format, zone, logFormat string
+		top                                                     int
+		filter                                                  report.Filter
+	)
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	flags.StringVar(&dir, "dir", "", "Directory of the billing log files, plain or gzip compressed")
+	flags.StringVar(&prefix, "prefix", writer.DefaultPrefix, "File name prefix of the billing log files")
+	flags.StringVar(&from, "from", "", "Only include billing logs from this time on, e.g. 2024-03-04 or 2024-03-04 10:00")
+	flags.StringVar(&to, "to", "", "Only include billing logs before this time")
+	flags.StringVar(&zone, "timezone", "UTC", "Time zone of the billing log timestamps and the time range")
+	flags.StringVar(&filter.Server, "server", "", "Only include billing logs of this server")
+	flags.StringVar(&filter.Repository, "repository", "", "Only include billing logs of this repository")
+	flags.StringVar(&filter.User, "user", "", "Only include billing logs of this user")
+	flags.StringVar(&filter.Project, "project", "", "Only include billing logs of this project")
+	flags.StringVar(&groupBy, "group-by", "user", "Comma separated keys the totals are grouped by: "+strings.Join(report.GroupKeys(), ", "))
+	flags.IntVar(&top, "top", 10, "Number of groups with the largest quantity which are printed, all of them when 0")
+	flags.StringVar(&format, "format", "table", "Output format: table, csv or json")
+	flags.StringVar(&logFormat, "log-format", string(logging.Text), "Format of the logged messages: text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logger, err := commandLogger("report", logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if dir == "" {
+		logger.Error("the -dir flag is required")
+		flags.Usage()
+		return 2
+	}
+
+	if filter.Location, err = time.LoadLocation(zone); err != nil {
+		logger.Error("invalid time zone", "timezone", zone, "error", err)
+		return 2
+	}
+	if filter.From, err = parseReportTime(from, filter.Location); err != nil {
+		logger.Error("invalid -from", "error", err)
+		return 2
+	}
+	if filter.To, err = parseReportTime(to, filter.Location); err != nil {
+		logger.Error("invalid -to", "error", err)
+		return 2
+	}
+
+	r, err := report.NewReport(report.Report{
+		GroupBy: strings.Split(groupBy, ","),
+		Filter:  filter,
+	})
+	if err != nil {
+		logger.Error("invalid report", "error", err)
+		return 2
+	}
+
+	stats, err := report.ReadDir(dir, prefix, func(b *parser.BillingLogs) error {
+		return r.Add(b)
+	})
+	if err != nil {
+		logger.Error("failed to read billing logs", "error", err)
+		return 1
+	}
+	if stats.Invalid > 0 {
+		logger.Warn("skipped lines which are not billing log entries", "lines", stats.Invalid)
+	}
+
+	if err = r.Write(os.Stdout, report.Format(format), top); err != nil {
+		logger.Error("failed to print report", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// commandLogger creates the logger of a command, which writes to stderr so the output on stdout can be piped
+func commandLogger(command string, format string) (*slog.Logger, error) {
+	logger, err := logging.New(logging.Config{
+		Output: os.Stderr,
+		Format: logging.Format(format),
+		Level:  "info",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logging.Component(logger, command), nil
+}
+
+// parseReportTime parses a time range flag in a location, an empty value is the zero time
+func parseReportTime(value string, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range reportTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse time %q, expected one of %s", value, strings.Join(reportTimeLayouts, ", "))
+}
@@ -1,15 +1,10 @@
 module github.com/svetlyopet/logcat
 
-go 1.19
+go 1.21
 
 require (
-	github.com/hpcloud/tail v1.0.0
+	github.com/oschwald/maxminddb-golang v1.12.0
 	github.com/robfig/cron/v3 v3.0.1
 )
 
-require (
-	github.com/fsnotify/fsnotify v1.6.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
-	gopkg.in/fsnotify.v1 v1.4.7 // indirect
-	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
-)
+require golang.org/x/sys v0.10.0 // indirect
@@ -0,0 +1,87 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// StateFile is the name of the file in the state directory where the checkpoint is persisted
+const StateFile = "checkpoint.json"
+
+// Checkpoint is the position in the input file up to which the lines were written to the output
+// The inode tells whether the file at the path is still the same one after a restart
+type Checkpoint struct {
+	File   string `json:"file"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// Save writes the checkpoint to a file
+func (c Checkpoint) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+
+	if err = WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// Load reads a checkpoint from a file written by Save
+// A missing file is not an error and returns an empty checkpoint
+func Load(path string) (Checkpoint, error) {
+	var c Checkpoint
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+
+	if err = json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+	return c, nil
+}
+
+// WriteFile replaces a file in the state directory with the data
+// The data is written to a temporary file, which is synced to disk before it is renamed over the file and the directory
+// is synced after the rename, so a crash leaves either the previous or the new content behind and never a partial one
+func WriteFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// directories can not be synced on windows, where the rename is written through
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
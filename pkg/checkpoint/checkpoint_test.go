@@ -0,0 +1,73 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint_SaveLoad(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, StateFile)
+
+	// A missing file returns an empty checkpoint
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() - Unexpected error: %v", err)
+	}
+	if c != (Checkpoint{}) {
+		t.Errorf("Load() - Expected empty checkpoint, got: %v", c)
+	}
+
+	want := Checkpoint{File: "/var/log/artifactory-request.log", Inode: 42, Offset: 1234}
+	if err = want.Save(path); err != nil {
+		t.Fatalf("Save() - Unexpected error: %v", err)
+	}
+	c, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() - Unexpected error: %v", err)
+	}
+	if c != want {
+		t.Errorf("Load() - Expected checkpoint: %v, got: %v", want, c)
+	}
+
+	// A corrupt file is an error
+	if err = os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(path); err == nil {
+		t.Error("Load() - Expected error for corrupt checkpoint")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	// the file is replaced and the temporary file is not left behind
+	for _, content := range []string{"first", "second"} {
+		if err = WriteFile(path, []byte(content)); err != nil {
+			t.Fatalf("WriteFile() - Unexpected error: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Errorf("WriteFile() - Expected content: %q, got: %q, %v", content, data, err)
+		}
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("WriteFile() - Expected no temporary file, got: %v", err)
+	}
+
+	// a missing directory is an error
+	if err = WriteFile(filepath.Join(dir, "missing", "state.json"), []byte("data")); err == nil {
+		t.Error("WriteFile() - Expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// Duration is a time.Duration which is written as a string like "1h30m" in the config file
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"1h\": %v", err)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON writes a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// String returns the duration as a string
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Config contains the settings which can be changed while logcat is running
+type Config struct {
+	ServerName      string   `json:"server_name"`
+	Workers         int      `json:"workers"`
+	MinWorkers      int      `json:"min_workers"`
+	MaxWorkers      int      `json:"max_workers"`
+	Delimiter       string   `json:"delimiter"`
+	Columns         []string `json:"columns"`
+	Timezone        string   `json:"timezone"`
+	Projects        string   `json:"projects"`
+	Users           string   `json:"users"`
+	Sites           string   `json:"sites"`
+	SiteDB          string   `json:"site_db"`
+	ExcludeSites    []string `json:"exclude_sites"`
+	IPPolicy        string   `json:"ip_policy"`
+	UserPolicy      string   `json:"user_policy"`
+	HMACSecret      string   `json:"hmac_secret"`
+	Prices          string   `json:"prices"`
+	Quotas          string   `json:"quotas"`
+	DedupWindow     Duration `json:"dedup_window"`
+	DedupMaxEntries int      `json:"dedup_max_entries"`
+}
+
+// Load reads the config file at path on top of the base config
+// Settings which are missing in the file keep their base value
+// An empty path returns the base config
+func Load(path string, base Config) (Config, error) {
+	// copy the slices so decoding the file does not modify the ones of the base config
+	c := base
+	c.Columns = append([]string(nil), base.Columns...)
+	c.ExcludeSites = append([]string(nil), base.ExcludeSites...)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file: %v", err)
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&c); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file: %v", err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Validate checks the settings which do not require loading other files
+func (c Config) Validate() error {
+	if c.ServerName == "" {
+		return fmt.Errorf("server_name must not be empty")
+	}
+	if c.Workers <= 0 {
+		return fmt.Errorf("workers must be greater than 0, got %d", c.Workers)
+	}
+	if c.MaxWorkers > 0 {
+		if c.MinWorkers < 1 || c.MinWorkers > c.MaxWorkers {
+			return fmt.Errorf("min_workers must be between 1 and max_workers %d, got %d", c.MaxWorkers, c.MinWorkers)
+		}
+		if c.Workers < c.MinWorkers || c.Workers > c.MaxWorkers {
+			return fmt.Errorf("workers must be between min_workers %d and max_workers %d, got %d", c.MinWorkers, c.MaxWorkers, c.Workers)
+		}
+	}
+	if c.Delimiter == "" {
+		return fmt.Errorf("delimiter must not be empty")
+	}
+	fields, err := parser.NewFieldMap(c.Columns)
+	if err != nil {
+		return fmt.Errorf("invalid columns: %v", err)
+	}
+	if err = fields.Validate(len(c.Columns)); err != nil {
+		return fmt.Errorf("invalid columns: %v", err)
+	}
+	if _, err = time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %v", err)
+	}
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("dedup_window must not be negative, got %v", c.DedupWindow)
+	}
+	if c.DedupWindow > 0 && c.DedupMaxEntries <= 0 {
+		return fmt.Errorf("dedup_max_entries must be greater than 0, got %d", c.DedupMaxEntries)
+	}
+	return nil
+}
+
+// Diff returns a description of each setting which differs between the old and the new config
+func Diff(old, new Config) []string {
+	var changes []string
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(oldValue.Type().Field(i).Tag.Get("json"), ",")[0]
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, oldValue.Field(i).Interface(), newValue.Field(i).Interface()))
+	}
+	return changes
+}
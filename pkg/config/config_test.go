@@ -0,0 +1,155 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+func baseConfig() Config {
+	return Config{
+		ServerName:      "artifactory.domain",
+		Workers:         5,
+		Delimiter:       "|",
+		Columns:         parser.DefaultColumns,
+		Timezone:        "UTC",
+		IPPolicy:        "keep",
+		UserPolicy:      "keep",
+		DedupMaxEntries: 100000,
+	}
+}
+
+func TestLoad(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		content    string
+		wantConfig func(c Config) Config
+		wantError  bool
+	}{
+		{
+			name:    "OverrideSettings",
+			content: `{"server_name": "edge.domain", "workers": 8, "dedup_window": "1h", "exclude_sites": ["replication"]}`,
+			wantConfig: func(c Config) Config {
+				c.ServerName = "edge.domain"
+				c.Workers = 8
+				c.DedupWindow = Duration(time.Hour)
+				c.ExcludeSites = []string{"replication"}
+				return c
+			},
+		},
+		{
+			name:       "EmptyFile",
+			content:    `{}`,
+			wantConfig: func(c Config) Config { return c },
+		},
+		{
+			name:      "UnknownSetting",
+			content:   `{"worker": 8}`,
+			wantError: true,
+		},
+		{
+			name:      "InvalidDuration",
+			content:   `{"dedup_window": "1 hour"}`,
+			wantError: true,
+		},
+		{
+			name:      "InvalidWorkers",
+			content:   `{"workers": 0}`,
+			wantError: true,
+		},
+		{
+			name:    "Autoscaling",
+			content: `{"workers": 2, "min_workers": 1, "max_workers": 10}`,
+			wantConfig: func(c Config) Config {
+				c.Workers = 2
+				c.MinWorkers = 1
+				c.MaxWorkers = 10
+				return c
+			},
+		},
+		{
+			name:      "WorkersAboveMax",
+			content:   `{"workers": 12, "min_workers": 1, "max_workers": 10}`,
+			wantError: true,
+		},
+		{
+			name:      "MinAboveMax",
+			content:   `{"min_workers": 12, "max_workers": 10}`,
+			wantError: true,
+		},
+		{
+			name:      "InvalidColumns",
+			content:   `{"columns": ["timestamp", "ip"]}`,
+			wantError: true,
+		},
+		{
+			name:      "InvalidTimezone",
+			content:   `{"timezone": "Mars/Olympus"}`,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal("Failed to write config file:", err)
+			}
+
+			gotConfig, gotError := Load(path, baseConfig())
+			if tt.wantError {
+				if gotError == nil {
+					t.Errorf("Load() error = %v, wantErr %v", gotError, tt.wantError)
+				}
+				return
+			}
+			if gotError != nil {
+				t.Fatalf("Load() unexpected error = %v", gotError)
+			}
+
+			if wantConfig := tt.wantConfig(baseConfig()); !reflect.DeepEqual(gotConfig, wantConfig) {
+				t.Errorf("Load() = %+v, want %+v", gotConfig, wantConfig)
+			}
+		})
+	}
+
+	// Without a path the base config is returned
+	if gotConfig, err := Load("", baseConfig()); err != nil || !reflect.DeepEqual(gotConfig, baseConfig()) {
+		t.Errorf("Load() = %+v, %v, want base config", gotConfig, err)
+	}
+
+	// A missing file returns an error
+	if _, err = Load(filepath.Join(dir, "missing.json"), baseConfig()); err == nil {
+		t.Error("Load() - Expected an error for a missing file")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := baseConfig()
+	new := baseConfig()
+	new.Workers = 8
+	new.DedupWindow = Duration(time.Hour)
+
+	want := []string{
+		"workers: 5 -> 8",
+		"dedup_window: 0s -> 1h0m0s",
+	}
+	if got := Diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+
+	if got := Diff(old, old); len(got) != 0 {
+		t.Errorf("Diff() = %v, want no changes", got)
+	}
+}
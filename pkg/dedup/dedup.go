@@ -0,0 +1,160 @@
+package dedup
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/checkpoint"
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// StateFile is the name of the file in the state directory where the seen entries are persisted
+const StateFile = "dedup.json"
+
+// seenEntry is an entry of the cache of seen requests
+type seenEntry struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+	// Offset is the input position of the request, 0 for requests restored from a previous run
+	Offset int64 `json:"-"`
+}
+
+// Deduplicator drops billing log entries of requests which were already seen
+// Requests are identified by their trace ID and timestamp and are remembered
+// for a time window relative to the newest seen request
+type Deduplicator struct {
+	Window     time.Duration
+	MaxEntries int
+
+	mu     sync.Mutex
+	seen   map[string]*list.Element
+	order  *list.List
+	newest time.Time
+}
+
+// NewDeduplicator creates and returns a Deduplicator which remembers requests for the window
+// and keeps at most maxEntries of them
+func NewDeduplicator(window time.Duration, maxEntries int) (*Deduplicator, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("dedup window must be greater than 0, got %v", window)
+	}
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("dedup max entries must be greater than 0, got %d", maxEntries)
+	}
+
+	d := &Deduplicator{
+		Window:     window,
+		MaxEntries: maxEntries,
+		seen:       make(map[string]*list.Element),
+		order:      list.New(),
+	}
+	return d, nil
+}
+
+// Process drops billing log entries of requests which were already seen and counts them
+// Entries without a trace ID are always kept
+func (d *Deduplicator) Process(entry *parser.BillingLogs) bool {
+	if entry.TraceID == "" {
+		return true
+	}
+
+	if !d.add(entry.TraceID+"|"+entry.RequestTime.UTC().Format(time.RFC3339Nano), entry.RequestTime, entry.Offset) {
+		metrics.DroppedDuplicates.Add(1)
+		return false
+	}
+	return true
+}
+
+// Len returns the number of remembered requests
+func (d *Deduplicator) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// add remembers a request and returns false if it was already seen
+func (d *Deduplicator) add(key string, t time.Time, offset int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+
+	// requests older than the window are not remembered anymore,
+	// so they can not be detected as duplicates
+	if t.After(d.newest) {
+		d.newest = t
+	}
+	if t.Before(d.newest.Add(-d.Window)) {
+		return true
+	}
+
+	d.seen[key] = d.order.PushBack(seenEntry{Key: key, Time: t, Offset: offset})
+	d.evict()
+	return true
+}
+
+// evict removes the requests which are outside of the window or over the maximum number of entries
+// The requests are evicted in the order in which they were seen
+func (d *Deduplicator) evict() {
+	cutoff := d.newest.Add(-d.Window)
+	for front := d.order.Front(); front != nil; front = d.order.Front() {
+		seen := front.Value.(seenEntry)
+		if d.order.Len() <= d.MaxEntries && !seen.Time.Before(cutoff) {
+			return
+		}
+		d.order.Remove(front)
+		delete(d.seen, seen.Key)
+	}
+}
+
+// Save persists the remembered requests up to the input position which was written to a file
+// The requests after it are read again on the next start, so they must not be detected as duplicates then
+func (d *Deduplicator) Save(path string, written int64) error {
+	d.mu.Lock()
+	entries := make([]seenEntry, 0, d.order.Len())
+	for e := d.order.Front(); e != nil; e = e.Next() {
+		if seen := e.Value.(seenEntry); seen.Offset <= written {
+			entries = append(entries, seen)
+		}
+	}
+	d.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode dedup state: %v", err)
+	}
+
+	if err = checkpoint.WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to write dedup state: %v", err)
+	}
+	return nil
+}
+
+// Load restores the remembered requests from a file written by Save
+// A missing file is not an error
+func (d *Deduplicator) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dedup state: %v", err)
+	}
+
+	var entries []seenEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse dedup state: %v", err)
+	}
+
+	for _, seen := range entries {
+		d.add(seen.Key, seen.Time, 0)
+	}
+	return nil
+}
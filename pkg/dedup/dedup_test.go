@@ -0,0 +1,139 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+func TestNewDeduplicator(t *testing.T) {
+	if _, err := NewDeduplicator(0, 10); err == nil {
+		t.Error("NewDeduplicator() - Expected an error for a zero window")
+	}
+	if _, err := NewDeduplicator(time.Hour, 0); err == nil {
+		t.Error("NewDeduplicator() - Expected an error for zero max entries")
+	}
+}
+
+func TestDeduplicator_Process(t *testing.T) {
+	d, err := NewDeduplicator(time.Hour, 10)
+	if err != nil {
+		t.Fatal("NewDeduplicator() returned an error:", err)
+	}
+
+	requestTime := time.Date(2023, time.June, 15, 12, 34, 56, 789000000, time.UTC)
+	dropped := metrics.DroppedDuplicates.Value()
+
+	// The first occurrence of a request is kept
+	if !d.Process(&parser.BillingLogs{TraceID: "abcdefgh12345678", RequestTime: requestTime}) {
+		t.Error("Deduplicator.Process() - Expected first occurrence to be kept")
+	}
+
+	// The same trace ID and timestamp is a duplicate
+	if d.Process(&parser.BillingLogs{TraceID: "abcdefgh12345678", RequestTime: requestTime}) {
+		t.Error("Deduplicator.Process() - Expected duplicate to be dropped")
+	}
+	if got := metrics.DroppedDuplicates.Value() - dropped; got != 1 {
+		t.Errorf("Deduplicator.Process() - Expected 1 dropped duplicate, got: %d", got)
+	}
+
+	// The same trace ID with a different timestamp is not a duplicate
+	if !d.Process(&parser.BillingLogs{TraceID: "abcdefgh12345678", RequestTime: requestTime.Add(time.Second)}) {
+		t.Error("Deduplicator.Process() - Expected entry with a different timestamp to be kept")
+	}
+
+	// Entries without a trace ID are always kept
+	for i := 0; i < 2; i++ {
+		if !d.Process(&parser.BillingLogs{RequestTime: requestTime}) {
+			t.Error("Deduplicator.Process() - Expected entry without trace ID to be kept")
+		}
+	}
+}
+
+func TestDeduplicator_Evict(t *testing.T) {
+	d, err := NewDeduplicator(time.Hour, 3)
+	if err != nil {
+		t.Fatal("NewDeduplicator() returned an error:", err)
+	}
+
+	requestTime := time.Date(2023, time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	// Requests over the maximum number of entries evict the oldest ones
+	for i, traceID := range []string{"a", "b", "c", "d"} {
+		d.add(traceID, requestTime.Add(time.Duration(i)*time.Second), 0)
+	}
+	if d.Len() != 3 {
+		t.Errorf("Deduplicator.add() - Expected 3 entries, got: %d", d.Len())
+	}
+	if !d.add("a", requestTime, 0) {
+		t.Error("Deduplicator.add() - Expected evicted request to not be detected as duplicate")
+	}
+
+	// A newer request moves the window and evicts the requests outside of it
+	d.add("e", requestTime.Add(2*time.Hour), 0)
+	if d.Len() != 1 {
+		t.Errorf("Deduplicator.add() - Expected 1 entry in the window, got: %d", d.Len())
+	}
+
+	// Requests older than the window are not remembered
+	d.add("f", requestTime, 0)
+	if d.Len() != 1 {
+		t.Errorf("Deduplicator.add() - Expected requests older than the window to not be remembered, got: %d entries", d.Len())
+	}
+}
+
+func TestDeduplicator_SaveLoad(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, StateFile)
+	requestTime := time.Date(2023, time.June, 15, 12, 34, 56, 789000000, time.UTC)
+
+	d, err := NewDeduplicator(time.Hour, 10)
+	if err != nil {
+		t.Fatal("NewDeduplicator() returned an error:", err)
+	}
+
+	// Loading a missing state is not an error
+	if err = d.Load(path); err != nil {
+		t.Fatal("Deduplicator.Load() returned an error for a missing state:", err)
+	}
+
+	d.Process(&parser.BillingLogs{TraceID: "abcdefgh12345678", RequestTime: requestTime, Offset: 10})
+	// The request after the written position is read again on the next start
+	d.Process(&parser.BillingLogs{TraceID: "ijklmnop12345678", RequestTime: requestTime, Offset: 20})
+	if err = d.Save(path, 10); err != nil {
+		t.Fatal("Deduplicator.Save() returned an error:", err)
+	}
+
+	// A new deduplicator restored from the state detects the duplicate
+	restored, err := NewDeduplicator(time.Hour, 10)
+	if err != nil {
+		t.Fatal("NewDeduplicator() returned an error:", err)
+	}
+	if err = restored.Load(path); err != nil {
+		t.Fatal("Deduplicator.Load() returned an error:", err)
+	}
+	if restored.Process(&parser.BillingLogs{TraceID: "abcdefgh12345678", RequestTime: requestTime}) {
+		t.Error("Deduplicator.Load() - Expected restored request to be detected as duplicate")
+	}
+	if !restored.Process(&parser.BillingLogs{TraceID: "ijklmnop12345678", RequestTime: requestTime}) {
+		t.Error("Deduplicator.Load() - Expected request which was not written to be kept")
+	}
+
+	// An invalid state returns an error
+	if err = os.WriteFile(path, []byte("invalid"), 0644); err != nil {
+		t.Fatal("Failed to write state file:", err)
+	}
+	if err = restored.Load(path); err == nil {
+		t.Error("Deduplicator.Load() - Expected an error for an invalid state")
+	}
+}
@@ -0,0 +1,216 @@
+package enrich
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/watch"
+)
+
+// UnknownSite is the site of remote IPs which are not found in the CIDR table or the database
+const UnknownSite = "unknown"
+
+// SitesConfig contains the sources used for tagging requests with sites
+type SitesConfig struct {
+	// CIDRPath is the path to a table mapping CIDR ranges to site labels
+	CIDRPath string
+	// DatabasePath is the path to an offline MaxMind DB format database which is used
+	// for remote IPs that are not found in the CIDR table
+	DatabasePath string
+	// Exclude contains the sites of which the billing log entries are dropped,
+	// e.g. sites of internal replication traffic
+	Exclude []string
+}
+
+// siteNetwork maps a network to a site label
+type siteNetwork struct {
+	network *net.IPNet
+	site    string
+}
+
+// mmdbRecord contains the fields read from the MaxMind DB format database
+// A custom "site" field takes precedence over the country ISO code
+type mmdbRecord struct {
+	Site    string `maxminddb:"site"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Sites tags billing log entries with the site of their remote IP
+type Sites struct {
+	CIDRPath string
+	Logger   *slog.Logger
+
+	exclude  map[string]bool
+	database *maxminddb.Reader
+
+	mu       sync.RWMutex
+	networks []siteNetwork
+}
+
+// LoadSites creates a Sites stage and loads the CIDR table and the database
+func LoadSites(c SitesConfig, logger *slog.Logger) (*Sites, error) {
+	if c.CIDRPath == "" && c.DatabasePath == "" {
+		return nil, fmt.Errorf("a CIDR table or a database is required")
+	}
+
+	s := &Sites{
+		CIDRPath: c.CIDRPath,
+		Logger:   logger,
+		exclude:  make(map[string]bool, len(c.Exclude)),
+	}
+	for _, site := range c.Exclude {
+		if site = strings.TrimSpace(site); site != "" {
+			s.exclude[site] = true
+		}
+	}
+
+	if c.CIDRPath != "" {
+		if err := s.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.DatabasePath != "" {
+		database, err := maxminddb.Open(c.DatabasePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open site database: %v", err)
+		}
+		s.database = database
+	}
+	return s, nil
+}
+
+// Reload reads the CIDR table again and replaces the loaded networks
+func (s *Sites) Reload() error {
+	f, err := os.Open(s.CIDRPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CIDR table: %v", err)
+	}
+	defer f.Close()
+
+	networks, err := readCIDRTable(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse CIDR table: %v", err)
+	}
+
+	s.mu.Lock()
+	s.networks = networks
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch checks the CIDR table for changes every interval and reloads it when it was modified
+// It stops when the context is done
+func (s *Sites) Watch(ctx context.Context, interval time.Duration) {
+	if s.CIDRPath == "" {
+		return
+	}
+	watch.File(ctx, s.CIDRPath, interval, s.Reload, s.Logger)
+}
+
+// Close closes the site database
+func (s *Sites) Close() error {
+	if s.database == nil {
+		return nil
+	}
+	return s.database.Close()
+}
+
+// Lookup returns the site of a remote IP
+// The CIDR table is checked first and the database is used for IPs which are not in it
+func (s *Sites) Lookup(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return UnknownSite
+	}
+
+	s.mu.RLock()
+	networks := s.networks
+	s.mu.RUnlock()
+
+	// the networks are sorted from the most to the least specific
+	for _, n := range networks {
+		if n.network.Contains(ip) {
+			return n.site
+		}
+	}
+
+	if s.database != nil {
+		var record mmdbRecord
+		if err := s.database.Lookup(ip, &record); err != nil {
+			s.Logger.Warn("failed to look up site", "error", err)
+			return UnknownSite
+		}
+		if record.Site != "" {
+			return record.Site
+		}
+		if record.Country.ISOCode != "" {
+			return record.Country.ISOCode
+		}
+	}
+	return UnknownSite
+}
+
+// Process sets the site of a billing log entry
+// Entries of excluded sites are dropped and counted
+func (s *Sites) Process(entry *parser.BillingLogs) bool {
+	entry.Site = s.Lookup(entry.RemoteIP)
+	if s.exclude[entry.Site] {
+		metrics.ExcludedSites.Add(entry.Site, 1)
+		return false
+	}
+	return true
+}
+
+// readCIDRTable reads lines in the format "<cidr>,<site>"
+// Empty lines and lines starting with # are ignored
+func readCIDRTable(r io.Reader) ([]siteNetwork, error) {
+	var networks []siteNetwork
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		split := strings.SplitN(line, ",", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("line %d: expected format <cidr>,<site>, got %q", lineNum, line)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(split[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		site := strings.TrimSpace(split[1])
+		if site == "" {
+			return nil, fmt.Errorf("line %d: site must not be empty", lineNum)
+		}
+		networks = append(networks, siteNetwork{network: network, site: site})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// sort the networks by prefix length so the most specific network matches first
+	sort.SliceStable(networks, func(i, j int) bool {
+		iOnes, _ := networks[i].network.Mask.Size()
+		jOnes, _ := networks[j].network.Mask.Size()
+		return iOnes > jOnes
+	})
+	return networks, nil
+}
@@ -0,0 +1,162 @@
+package enrich
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+func TestLoadSites(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	tests := []struct {
+		name      string
+		content   string
+		wantError bool
+	}{
+		{
+			name:    "ValidTable",
+			content: "# offices\n10.0.0.0/8,vpn\n\n10.1.0.0/16, office-sofia\n2001:db8::/32,aws-eu-central-1\n",
+		},
+		{
+			name:      "InvalidCIDR",
+			content:   "10.0.0.0/33,vpn\n",
+			wantError: true,
+		},
+		{
+			name:      "MissingSite",
+			content:   "10.0.0.0/8\n",
+			wantError: true,
+		},
+		{
+			name:      "EmptySite",
+			content:   "10.0.0.0/8, \n",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".csv")
+			writeFile(t, path, tt.content)
+
+			_, gotError := LoadSites(SitesConfig{CIDRPath: path}, logger)
+			if (gotError != nil) != tt.wantError {
+				t.Errorf("LoadSites() error = %v, wantErr %v", gotError, tt.wantError)
+			}
+		})
+	}
+
+	// Check that a missing database returns an error
+	if _, err = LoadSites(SitesConfig{DatabasePath: filepath.Join(dir, "missing.mmdb")}, logger); err == nil {
+		t.Error("LoadSites() - Expected an error for a missing database")
+	}
+
+	// Check that at least one source is required
+	if _, err = LoadSites(SitesConfig{}, logger); err == nil {
+		t.Error("LoadSites() - Expected an error without a CIDR table or a database")
+	}
+}
+
+func TestSites_Lookup(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sites.csv")
+	writeFile(t, path, "10.0.0.0/8,vpn\n10.1.0.0/16,office-sofia\n2001:db8::/32,aws-eu-central-1\n")
+
+	sites, err := LoadSites(SitesConfig{CIDRPath: path}, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err != nil {
+		t.Fatal("LoadSites() returned an error:", err)
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		wantSite string
+	}{
+		{
+			name:     "LeastSpecificNetwork",
+			ip:       "10.2.3.4",
+			wantSite: "vpn",
+		},
+		{
+			name:     "MostSpecificNetwork",
+			ip:       "10.1.2.3",
+			wantSite: "office-sofia",
+		},
+		{
+			name:     "IPv6",
+			ip:       "2001:db8::1",
+			wantSite: "aws-eu-central-1",
+		},
+		{
+			name:     "UnknownNetwork",
+			ip:       "1.2.3.4",
+			wantSite: UnknownSite,
+		},
+		{
+			name:     "InvalidIP",
+			ip:       "unknown",
+			wantSite: UnknownSite,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotSite := sites.Lookup(tt.ip); gotSite != tt.wantSite {
+				t.Errorf("Sites.Lookup() = %v, want %v", gotSite, tt.wantSite)
+			}
+		})
+	}
+}
+
+func TestSites_Process(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sites.csv")
+	writeFile(t, path, "10.0.0.0/8,vpn\n192.168.0.0/16,replication\n")
+
+	sites, err := LoadSites(SitesConfig{CIDRPath: path, Exclude: []string{"replication"}}, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err != nil {
+		t.Fatal("LoadSites() returned an error:", err)
+	}
+
+	// Process an entry from a site which is not excluded
+	entry := &parser.BillingLogs{RemoteIP: "10.1.2.3"}
+	if !sites.Process(entry) {
+		t.Error("Sites.Process() - Expected entry to be kept")
+	}
+	if entry.Site != "vpn" {
+		t.Errorf("Sites.Process() - Expected site: %s, got: %s", "vpn", entry.Site)
+	}
+
+	// Process an entry from an excluded site and check that it is counted
+	entry = &parser.BillingLogs{RemoteIP: "192.168.1.2"}
+	if sites.Process(entry) {
+		t.Error("Sites.Process() - Expected entry of an excluded site to be dropped")
+	}
+	if count := metrics.ExcludedSites.Get("replication"); count == nil || count.String() != "1" {
+		t.Errorf("Sites.Process() - Expected excluded site count: 1, got: %v", count)
+	}
+}
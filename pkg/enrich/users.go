@@ -0,0 +1,182 @@
+package enrich
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/watch"
+)
+
+// User contains the metadata of a user from a directory export
+type User struct {
+	Name           string `json:"user"`
+	Team           string `json:"team"`
+	CostCenter     string `json:"cost_center"`
+	Email          string `json:"email"`
+	ServiceAccount bool   `json:"service_account"`
+}
+
+// Users enriches billing log entries with user metadata loaded from a CSV or JSON file
+type Users struct {
+	Path   string
+	Logger *slog.Logger
+
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+// LoadUsers creates a Users stage and loads the users from the file at path
+func LoadUsers(path string, logger *slog.Logger) (*Users, error) {
+	u := &Users{
+		Path:   path,
+		Logger: logger,
+	}
+	if err := u.Reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Reload reads the users file again and replaces the loaded users
+// The previously loaded users are kept if the file can not be read
+func (u *Users) Reload() error {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %v", err)
+	}
+	defer f.Close()
+
+	var users []User
+	if strings.EqualFold(filepath.Ext(u.Path), ".json") {
+		users, err = readUsersJSON(f)
+	} else {
+		users, err = readUsersCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse users file: %v", err)
+	}
+
+	byName := make(map[string]User, len(users))
+	for _, user := range users {
+		if user.Name == "" {
+			return fmt.Errorf("failed to parse users file: user name must not be empty")
+		}
+		byName[user.Name] = user
+	}
+
+	u.mu.Lock()
+	u.users = byName
+	u.mu.Unlock()
+	return nil
+}
+
+// Watch checks the users file for changes every interval and reloads it when it was modified
+// It stops when the context is done
+func (u *Users) Watch(ctx context.Context, interval time.Duration) {
+	watch.File(ctx, u.Path, interval, u.Reload, u.Logger)
+}
+
+// Lookup returns the metadata of a user and false if the user is unknown
+func (u *Users) Lookup(name string) (User, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	user, ok := u.users[name]
+	return user, ok
+}
+
+// Process adds the metadata of the user to a billing log entry
+// Entries of unknown users are kept without metadata
+func (u *Users) Process(entry *parser.BillingLogs) bool {
+	user, ok := u.Lookup(entry.User)
+	if !ok {
+		return true
+	}
+
+	entry.Team = user.Team
+	entry.CostCenter = user.CostCenter
+	entry.EmailDomain = emailDomain(user.Email)
+	entry.ServiceAccount = user.ServiceAccount
+	return true
+}
+
+// emailDomain returns the domain part of an email address
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// readUsersJSON reads a JSON array of users
+func readUsersJSON(r io.Reader) ([]User, error) {
+	var users []User
+	if err := json.NewDecoder(r).Decode(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// readUsersCSV reads users from CSV with a header row
+// The columns are matched by the same names as the JSON keys
+func readUsersCSV(r io.Reader) ([]User, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["user"]; !ok {
+		return nil, fmt.Errorf("header is missing the user column")
+	}
+
+	value := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var users []User
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		user := User{
+			Name:       value(record, "user"),
+			Team:       value(record, "team"),
+			CostCenter: value(record, "cost_center"),
+			Email:      value(record, "email"),
+		}
+		if serviceAccount := value(record, "service_account"); serviceAccount != "" {
+			user.ServiceAccount, err = strconv.ParseBool(serviceAccount)
+			if err != nil {
+				return nil, fmt.Errorf("invalid service_account value for user %q: %v", user.Name, err)
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
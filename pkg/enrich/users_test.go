@@ -0,0 +1,154 @@
+package enrich
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("Failed to write file:", err)
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		wantUser  User
+		wantError bool
+	}{
+		{
+			name:     "CSV",
+			file:     "users.csv",
+			content:  "user,team,cost_center,email,service_account\nuser1,platform,CC-100,User1@Example.com,false\nci-bot,platform,CC-100,,true\n",
+			wantUser: User{Name: "user1", Team: "platform", CostCenter: "CC-100", Email: "User1@Example.com"},
+		},
+		{
+			name:     "JSON",
+			file:     "users.json",
+			content:  `[{"user": "user1", "team": "platform", "cost_center": "CC-100", "email": "User1@Example.com"}]`,
+			wantUser: User{Name: "user1", Team: "platform", CostCenter: "CC-100", Email: "User1@Example.com"},
+		},
+		{
+			name:      "CSVMissingUserColumn",
+			file:      "nouser.csv",
+			content:   "team,email\nplatform,user1@example.com\n",
+			wantError: true,
+		},
+		{
+			name:      "CSVInvalidServiceAccount",
+			file:      "invalid.csv",
+			content:   "user,service_account\nuser1,maybe\n",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			writeFile(t, path, tt.content)
+
+			users, gotError := LoadUsers(path, logger)
+			if tt.wantError {
+				if gotError == nil {
+					t.Errorf("LoadUsers() error = %v, wantErr %v", gotError, tt.wantError)
+				}
+				return
+			}
+			if gotError != nil {
+				t.Fatalf("LoadUsers() unexpected error = %v", gotError)
+			}
+
+			gotUser, ok := users.Lookup(tt.wantUser.Name)
+			if !ok || gotUser != tt.wantUser {
+				t.Errorf("LoadUsers() user = %v, want %v", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestUsers_Process(t *testing.T) {
+	users := &Users{
+		users: map[string]User{
+			"ci-bot": {Name: "ci-bot", Team: "platform", CostCenter: "CC-100", Email: "ci-bot@Example.com", ServiceAccount: true},
+		},
+	}
+
+	// Process a known user
+	entry := &parser.BillingLogs{User: "ci-bot"}
+	if !users.Process(entry) {
+		t.Error("Users.Process() - Expected entry to be kept")
+	}
+	if entry.Team != "platform" || entry.CostCenter != "CC-100" || entry.EmailDomain != "example.com" || !entry.ServiceAccount {
+		t.Errorf("Users.Process() - Unexpected enriched entry: %+v", entry)
+	}
+
+	// Process an unknown user
+	entry = &parser.BillingLogs{User: "unknown"}
+	if !users.Process(entry) {
+		t.Error("Users.Process() - Expected entry to be kept")
+	}
+	if entry.Team != "" || entry.CostCenter != "" || entry.EmailDomain != "" || entry.ServiceAccount {
+		t.Errorf("Users.Process() - Expected entry without metadata, got: %+v", entry)
+	}
+}
+
+func TestUsers_Watch(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.csv")
+	writeFile(t, path, "user,team\nuser1,platform\n")
+
+	users, err := LoadUsers(path, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err != nil {
+		t.Fatal("LoadUsers() returned an error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	users.Watch(ctx, 10*time.Millisecond)
+
+	// Modify the file and make sure the modification time changes
+	writeFile(t, path, "user,team\nuser1,security\n")
+	modTime := time.Now().Add(time.Second)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal("Failed to change file times:", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if user, _ := users.Lookup("user1"); user.Team == "security" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Users.Watch() - Users file was not reloaded after modification")
+}
@@ -0,0 +1,94 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Hook delivers a JSON event to another program
+type Hook interface {
+	// Run delivers the event and returns an error if it was not accepted
+	Run(ctx context.Context, event []byte) error
+	// String describes the hook in log lines
+	String() string
+}
+
+// Webhook POSTs the event to a URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook creates and returns a Webhook out of a config
+func NewWebhook(w Webhook) *Webhook {
+	if w.Client == nil {
+		w.Client = http.DefaultClient
+	}
+	return &Webhook{
+		URL:    w.URL,
+		Client: w.Client,
+	}
+}
+
+// Run POSTs the event and expects a 2xx response
+func (w *Webhook) Run(ctx context.Context, event []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(event))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// String returns the URL of the webhook
+func (w *Webhook) String() string {
+	return "webhook " + w.URL
+}
+
+// Exec runs a command with the event on its standard input and in the LOGCAT_EVENT environment variable
+type Exec struct {
+	Command []string
+}
+
+// NewExec creates and returns an Exec out of a config
+func NewExec(e Exec) (*Exec, error) {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return nil, fmt.Errorf("exec hook command must not be empty")
+	}
+	return &Exec{
+		Command: append([]string(nil), e.Command...),
+	}, nil
+}
+
+// Run runs the command and expects it to exit with status 0
+func (e *Exec) Run(ctx context.Context, event []byte) error {
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(event)
+	cmd.Env = append(os.Environ(), "LOGCAT_EVENT="+string(event))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("exec hook failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// String returns the command of the hook
+func (e *Exec) String() string {
+	return "exec " + strings.Join(e.Command, " ")
+}
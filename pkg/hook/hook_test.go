@@ -0,0 +1,77 @@
+package hook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Run(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Run() - Expected JSON content type, got: %v", r.Header.Get("Content-Type"))
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(Webhook{URL: server.URL + "/event"})
+	if err := webhook.Run(context.Background(), []byte(`{"id":1}`)); err != nil {
+		t.Fatal("Run() returned an error:", err)
+	}
+	if received != `{"id":1}` {
+		t.Errorf("Run() - Expected event to be posted, got: %v", received)
+	}
+
+	webhook = NewWebhook(Webhook{URL: server.URL + "/fail"})
+	if err := webhook.Run(context.Background(), []byte(`{}`)); err == nil {
+		t.Error("Run() - Expected error for status 500")
+	}
+}
+
+func TestExec_Run(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "event.json")
+
+	if _, err = NewExec(Exec{}); err == nil {
+		t.Error("NewExec() - Expected error for empty command")
+	}
+
+	// The event is passed on the standard input and in the environment
+	e, err := NewExec(Exec{Command: []string{"sh", "-c", `cat > "$0" && test "$LOGCAT_EVENT" = '{"id":1}'`, out}})
+	if err != nil {
+		t.Fatal("NewExec() returned an error:", err)
+	}
+	if err = e.Run(context.Background(), []byte(`{"id":1}`)); err != nil {
+		t.Fatal("Run() returned an error:", err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != `{"id":1}` {
+		t.Errorf("Run() - Expected event on standard input, got: %s", data)
+	}
+
+	// A failing or hanging command is an error
+	e, _ = NewExec(Exec{Command: []string{"sh", "-c", "echo broken; exit 3"}})
+	if err = e.Run(context.Background(), nil); err == nil {
+		t.Error("Run() - Expected error for exit status 3")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	e, _ = NewExec(Exec{Command: []string{"sleep", "5"}})
+	if err = e.Run(ctx, nil); err == nil {
+		t.Error("Run() - Expected error when the timeout expires")
+	}
+}
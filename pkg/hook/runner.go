@@ -0,0 +1,118 @@
+package hook
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+const (
+	// DefaultTimeout is how long a hook can run when no other timeout is set
+	DefaultTimeout = 30 * time.Second
+	// defaultRetryInterval is the wait before the first retry, it doubles with each retry
+	defaultRetryInterval = time.Second
+	// eventQueueSize is the number of events which can wait for delivery
+	eventQueueSize = 100
+)
+
+// Runner delivers events to hooks in the background, so the sender never waits for them
+// Failed deliveries are retried and counted in the hook failures metric when they fail for good
+type Runner struct {
+	Hooks         []Hook
+	Timeout       time.Duration
+	Retries       int
+	RetryInterval time.Duration
+	Logger        *slog.Logger
+
+	events chan []byte
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRunner creates and returns a Runner out of a config
+func NewRunner(r Runner) *Runner {
+	if r.Timeout <= 0 {
+		r.Timeout = DefaultTimeout
+	}
+	if r.RetryInterval <= 0 {
+		r.RetryInterval = defaultRetryInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		Hooks:         r.Hooks,
+		Timeout:       r.Timeout,
+		Retries:       r.Retries,
+		RetryInterval: r.RetryInterval,
+		Logger:        r.Logger,
+		events:        make(chan []byte, eventQueueSize),
+		done:          make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start delivers the events in the background until the runner is stopped
+func (r *Runner) Start() {
+	go func() {
+		defer close(r.done)
+		for event := range r.events {
+			for _, h := range r.Hooks {
+				r.deliver(h, event)
+			}
+		}
+	}()
+}
+
+// Notify queues an event for delivery without waiting
+// It returns false when the event was dropped because too many are waiting
+func (r *Runner) Notify(event []byte) bool {
+	select {
+	case r.events <- event:
+		return true
+	default:
+		metrics.HookFailures.Add(int64(len(r.Hooks)))
+		r.Logger.Error("dropped hook event because too many are waiting for delivery", "event", string(event))
+		return false
+	}
+}
+
+// Stop delivers the queued events until the context is done and stops the runner
+func (r *Runner) Stop(ctx context.Context) error {
+	close(r.events)
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-r.done
+		return ctx.Err()
+	}
+}
+
+// deliver runs a hook and retries it with a growing wait until it succeeds or the retries are used up
+func (r *Runner) deliver(h Hook, event []byte) {
+	wait := r.RetryInterval
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(r.ctx, r.Timeout)
+		err := h.Run(ctx, event)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= r.Retries || r.ctx.Err() != nil {
+			metrics.HookFailures.Add(1)
+			r.Logger.Error("failed to run hook", "hook", h.String(), "attempts", attempt+1, "error", err)
+			return
+		}
+		r.Logger.Warn("failed to run hook, retrying", "hook", h.String(), "wait", wait, "error", err)
+
+		select {
+		case <-time.After(wait):
+		case <-r.ctx.Done():
+		}
+		wait *= 2
+	}
+}
@@ -0,0 +1,123 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+// mockHook fails the first runs and records the delivered events
+type mockHook struct {
+	mu       sync.Mutex
+	failures int
+	runs     int
+	events   []string
+	block    bool
+}
+
+func (h *mockHook) Run(ctx context.Context, event []byte) error {
+	if h.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs++
+	if h.runs <= h.failures {
+		return fmt.Errorf("failure %d", h.runs)
+	}
+	h.events = append(h.events, string(event))
+	return nil
+}
+
+func (h *mockHook) String() string {
+	return "mock"
+}
+
+func TestRunner_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantRuns     int
+		wantFailures int64
+	}{
+		{"Success", 0, 1, 0},
+		{"RetrySuccess", 2, 3, 0},
+		{"RetriesUsedUp", 5, 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &mockHook{failures: tt.failures}
+			runner := NewRunner(Runner{
+				Hooks:         []Hook{h},
+				Retries:       2,
+				RetryInterval: time.Millisecond,
+				Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+			})
+			runner.Start()
+
+			failures := metrics.HookFailures.Value()
+			if !runner.Notify([]byte(`{"file":"a.log"}`)) {
+				t.Fatal("Notify() - Expected event to be queued")
+			}
+			if err := runner.Stop(context.Background()); err != nil {
+				t.Fatal("Stop() returned an error:", err)
+			}
+			if h.runs != tt.wantRuns {
+				t.Errorf("Runner - Expected %d runs, got: %d", tt.wantRuns, h.runs)
+			}
+			if got := metrics.HookFailures.Value() - failures; got != tt.wantFailures {
+				t.Errorf("Runner - Expected %d counted failures, got: %d", tt.wantFailures, got)
+			}
+		})
+	}
+}
+
+func TestRunner_Timeout(t *testing.T) {
+	runner := NewRunner(Runner{
+		Hooks:   []Hook{&mockHook{block: true}},
+		Timeout: 10 * time.Millisecond,
+		Logger:  slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	runner.Start()
+
+	// A hook which does not finish is stopped after the timeout
+	failures := metrics.HookFailures.Value()
+	runner.Notify([]byte(`{}`))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := runner.Stop(ctx); err != nil {
+		t.Fatal("Stop() returned an error:", err)
+	}
+	if metrics.HookFailures.Value() != failures+1 {
+		t.Error("Runner - Expected timed out hook to be counted as failure")
+	}
+}
+
+func TestRunner_Notify(t *testing.T) {
+	// Nothing delivers the events, so the queue fills up without blocking the sender
+	runner := NewRunner(Runner{
+		Hooks:  []Hook{&mockHook{}},
+		Logger: slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	for i := 0; i < eventQueueSize; i++ {
+		if !runner.Notify([]byte(`{}`)) {
+			t.Fatalf("Notify() - Expected event %d to be queued", i)
+		}
+	}
+	if runner.Notify([]byte(`{}`)) {
+		t.Error("Notify() - Expected event to be dropped when the queue is full")
+	}
+}
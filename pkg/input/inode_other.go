@@ -0,0 +1,15 @@
+//go:build !unix
+
+package input
+
+import "os"
+
+// Resumable returns false because the input file can not be identified after a restart without inode numbers
+func Resumable() bool {
+	return false
+}
+
+// inode returns 0 because inode numbers are not available on this platform
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
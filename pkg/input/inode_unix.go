@@ -0,0 +1,21 @@
+//go:build unix
+
+package input
+
+import (
+	"os"
+	"syscall"
+)
+
+// Resumable returns whether reading can continue at a checkpoint, which needs the inode number to identify the input file
+func Resumable() bool {
+	return true
+}
+
+// inode returns the inode number of a file
+func inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,355 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+// Policy describes where reading continues when the input file was truncated or replaced
+type Policy string
+
+const (
+	// FromStart reads the truncated or new file from the start
+	FromStart Policy = "start"
+	// Skip continues reading at the end of the truncated or new file
+	Skip Policy = "skip"
+)
+
+// defaultPollInterval is how often the input file is checked for new lines, truncation and rotation
+const defaultPollInterval = 250 * time.Millisecond
+
+// Line is a line of the input
+// Position is the position after the line in the input stream, which keeps growing when the input file is truncated or rotated
+type Line struct {
+	Text     string
+	Position int64
+}
+
+// Config contains the settings of a Follower
+// Without an inode reading starts at the end of the file, or at the start of it when it is created later
+// With an inode reading continues at the offset if the file still has that inode and was not truncated
+type Config struct {
+	Path         string
+	Inode        uint64
+	Offset       int64
+	OnTruncate   Policy
+	OnRotate     Policy
+	PollInterval time.Duration
+	Logger       *slog.Logger
+}
+
+// Follower reads the lines appended to a file and follows it when it is truncated or rotated
+type Follower struct {
+	Path         string
+	OnTruncate   Policy
+	OnRotate     Policy
+	PollInterval time.Duration
+	Logger       *slog.Logger
+	Lines        chan Line
+
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial string
+	// position is the position in the input stream after the data which was read
+	position int64
+
+	mu sync.Mutex
+	// segments map positions in the input stream to offsets in the input files
+	segments []segment
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// segment is a part of the input stream which was read from one file starting at an offset
+type segment struct {
+	start int64
+	inode uint64
+	base  int64
+}
+
+// Follow validates the policies and starts following the file
+func Follow(c Config) (*Follower, error) {
+	if c.OnTruncate == "" {
+		c.OnTruncate = FromStart
+	}
+	if c.OnRotate == "" {
+		c.OnRotate = FromStart
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultPollInterval
+	}
+	for _, policy := range []Policy{c.OnTruncate, c.OnRotate} {
+		if policy != FromStart && policy != Skip {
+			return nil, fmt.Errorf("unknown input policy %q", policy)
+		}
+	}
+
+	f := &Follower{
+		Path:         c.Path,
+		OnTruncate:   c.OnTruncate,
+		OnRotate:     c.OnRotate,
+		PollInterval: c.PollInterval,
+		Logger:       c.Logger,
+		Lines:        make(chan Line),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go f.run(c.Inode, c.Offset)
+	return f, nil
+}
+
+// Stop stops following the file and closes the lines channel
+func (f *Follower) Stop() error {
+	close(f.quit)
+	<-f.done
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
+
+// Locate returns the inode and the offset in the input file of a position in the input stream
+// The positions before it are forgotten, so it must be called with positions which do not decrease
+func (f *Follower) Locate(position int64) (uint64, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.segments) > 1 && f.segments[1].start <= position {
+		f.segments = f.segments[1:]
+	}
+	if len(f.segments) == 0 {
+		return 0, 0
+	}
+	s := f.segments[0]
+	return s.inode, s.base + position - s.start
+}
+
+// run opens the file and sends its lines until the follower is stopped
+func (f *Follower) run(inode uint64, offset int64) {
+	defer close(f.done)
+	defer close(f.Lines)
+
+	created := false
+	for {
+		ok, err := f.open(inode, offset, created)
+		if ok {
+			break
+		}
+		if err != nil && !os.IsNotExist(err) {
+			f.Logger.Error("failed to open input file", "file", f.Path, "error", err)
+		}
+		created = true
+		if !f.wait() {
+			return
+		}
+	}
+
+	for {
+		if !f.read() {
+			return
+		}
+		if !f.check() {
+			return
+		}
+		if !f.wait() {
+			return
+		}
+	}
+}
+
+// open opens the file for the first time and seeks to where reading starts
+// It returns false when the file does not exist yet
+func (f *Follower) open(previous uint64, offset int64, created bool) (bool, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+
+	start := info.Size()
+	switch {
+	case created:
+		// the file was created after we started, so all of it is new
+		start = 0
+	case previous == 0:
+		// without a checkpoint only the lines appended from now on are read
+	case previous != inode(info):
+		f.event("rotated", "input file was replaced while logcat was stopped", "from", f.where(f.OnRotate))
+		start = f.start(f.OnRotate, info.Size())
+	case offset > info.Size():
+		f.event("truncated", "input file was truncated while logcat was stopped", "from", f.where(f.OnTruncate))
+		start = f.start(f.OnTruncate, info.Size())
+	default:
+		start = offset
+		f.Logger.Info("resuming input file", "file", f.Path, "offset", offset)
+	}
+
+	if _, err = file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		return false, err
+	}
+	f.file = file
+	f.reader = bufio.NewReader(file)
+	f.offset = start
+	f.position = start
+	f.mu.Lock()
+	f.segments = append(f.segments, segment{start: start, inode: inode(info), base: start})
+	f.mu.Unlock()
+	return true, nil
+}
+
+// read sends all complete lines which are available in the file
+// It returns false when the follower was stopped
+func (f *Follower) read() bool {
+	for {
+		data, err := f.reader.ReadString('\n')
+		f.offset += int64(len(data))
+		f.position += int64(len(data))
+		if err != nil {
+			// keep the start of a line which is still being written
+			f.partial += data
+			if err != io.EOF {
+				f.Logger.Error("failed to read input file", "file", f.Path, "error", err)
+			}
+			return true
+		}
+		if !f.send(f.partial + strings.TrimSuffix(data, "\n")) {
+			return false
+		}
+		f.partial = ""
+	}
+}
+
+// check detects whether the file was truncated or replaced by a new one and continues reading accordingly
+// It returns false when the follower was stopped
+func (f *Follower) check() bool {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		// the file was moved away and the new one was not created yet, keep reading the old one
+		return true
+	}
+
+	current, err := f.file.Stat()
+	if err != nil {
+		f.Logger.Error("failed to check input file", "file", f.Path, "error", err)
+		return true
+	}
+
+	if !os.SameFile(info, current) {
+		// the file was rotated, read the lines which were written to the old file before it was replaced
+		if !f.read() || !f.flush() {
+			return false
+		}
+		file, err := os.Open(f.Path)
+		if err != nil {
+			f.Logger.Error("failed to open rotated input file", "file", f.Path, "error", err)
+			return true
+		}
+		if info, err = file.Stat(); err != nil {
+			file.Close()
+			f.Logger.Error("failed to open rotated input file", "file", f.Path, "error", err)
+			return true
+		}
+		f.file.Close()
+		f.file = file
+		f.event("rotated", "input file was rotated, reading the new file", "from", f.where(f.OnRotate))
+		f.seek(f.start(f.OnRotate, info.Size()), inode(info))
+		return true
+	}
+
+	if info.Size() < f.offset {
+		// the file was truncated in place, e.g. by copytruncate
+		if !f.flush() {
+			return false
+		}
+		kind := "truncated"
+		if info.Size() > 0 {
+			// more lines were written after the truncation before we noticed it
+			kind = "shrunk"
+		}
+		f.event(kind, "input file was "+kind, "old_size", f.offset, "size", info.Size(), "from", f.where(f.OnTruncate))
+		f.seek(f.start(f.OnTruncate, info.Size()), inode(info))
+	}
+	return true
+}
+
+// seek continues reading the current file at the offset, which starts a new segment of the input stream
+func (f *Follower) seek(offset int64, ino uint64) {
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		f.Logger.Error("failed to seek input file", "file", f.Path, "error", err)
+	}
+	f.reader.Reset(f.file)
+	f.offset = offset
+	f.mu.Lock()
+	f.segments = append(f.segments, segment{start: f.position, inode: ino, base: offset})
+	f.mu.Unlock()
+}
+
+// flush sends the start of a line which was never completed
+// It returns false when the follower was stopped
+func (f *Follower) flush() bool {
+	if f.partial == "" {
+		return true
+	}
+	line := f.partial
+	f.partial = ""
+	return f.send(line)
+}
+
+// send sends a line to the lines channel
+// It returns false when the follower was stopped
+func (f *Follower) send(text string) bool {
+	select {
+	case f.Lines <- Line{Text: text, Position: f.position}:
+		return true
+	case <-f.quit:
+		return false
+	}
+}
+
+// wait waits for the poll interval
+// It returns false when the follower was stopped
+func (f *Follower) wait() bool {
+	select {
+	case <-time.After(f.PollInterval):
+		return true
+	case <-f.quit:
+		return false
+	}
+}
+
+// start returns the offset where reading continues in a file of the size according to the policy
+func (f *Follower) start(policy Policy, size int64) int64 {
+	if policy == Skip {
+		return size
+	}
+	return 0
+}
+
+// where describes where reading continues according to the policy
+func (f *Follower) where(policy Policy) string {
+	if policy == Skip {
+		return "end"
+	}
+	return "start"
+}
+
+// event logs and counts a truncation or rotation of the input file
+func (f *Follower) event(kind string, msg string, args ...interface{}) {
+	metrics.InputEvents.Add(kind, 1)
+	f.Logger.Warn(msg, append([]interface{}{"file", f.Path}, args...)...)
+}
@@ -0,0 +1,253 @@
+package input
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+const pollInterval = 10 * time.Millisecond
+
+func follow(t *testing.T, c Config) *Follower {
+	c.PollInterval = pollInterval
+	c.Logger = slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	f, err := Follow(c)
+	if err != nil {
+		t.Fatalf("Follow() - Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { f.Stop() })
+	return f
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive waits for n lines
+func receive(t *testing.T, f *Follower, n int) []Line {
+	var lines []Line
+	for len(lines) < n {
+		select {
+		case line := <-f.Lines:
+			lines = append(lines, line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %d lines, got: %v", n, lines)
+		}
+	}
+	return lines
+}
+
+// expectLines waits for the lines and checks that no other line follows
+func expectLines(t *testing.T, f *Follower, want ...string) []Line {
+	lines := receive(t, f, len(want))
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.Text)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Follower.Lines - Expected lines: %v, got: %v", want, got)
+	}
+	select {
+	case line := <-f.Lines:
+		t.Errorf("Follower.Lines - Unexpected line: %v", line)
+	case <-time.After(5 * pollInterval):
+	}
+	return lines
+}
+
+// settle waits until the follower noticed the changes of the file
+func settle() {
+	time.Sleep(5 * pollInterval)
+}
+
+func tempFile(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "artifactory-request.log")
+}
+
+func fileInode(t *testing.T, path string) uint64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inode(info)
+}
+
+func TestFollow_InvalidPolicy(t *testing.T) {
+	if _, err := Follow(Config{Path: "/tmp/input.log", OnTruncate: "rewind"}); err == nil {
+		t.Error("Follow() - Expected error for unknown policy")
+	}
+}
+
+func TestFollower_Append(t *testing.T) {
+	path := tempFile(t)
+	appendFile(t, path, "old\n")
+
+	// Only the lines appended after the start are read and partial lines wait for their end
+	f := follow(t, Config{Path: path})
+	settle()
+	appendFile(t, path, "first\nsec")
+	expectLines(t, f, "first")
+	appendFile(t, path, "ond\n")
+	lines := expectLines(t, f, "second")
+	if lines[0].Position != 17 {
+		t.Errorf("Follower.Lines - Expected position: 17, got: %d", lines[0].Position)
+	}
+}
+
+func TestFollower_Created(t *testing.T) {
+	path := tempFile(t)
+
+	// A file which is created after the start is read from the start
+	f := follow(t, Config{Path: path})
+	settle()
+	appendFile(t, path, "first\nsecond\n")
+	expectLines(t, f, "first", "second")
+}
+
+func TestFollower_RenameRotation(t *testing.T) {
+	path := tempFile(t)
+	appendFile(t, path, "")
+	rotated := metrics.InputEvents.Get("rotated")
+
+	f := follow(t, Config{Path: path})
+	settle()
+	appendFile(t, path, "a\nb\n")
+	expectLines(t, f, "a", "b")
+
+	// The lines written to the old file after it was moved are read before the new file
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "c\n")
+	settle()
+	appendFile(t, path, "dd\n")
+	lines := expectLines(t, f, "c", "dd")
+
+	if got := metrics.InputEvents.Get("rotated"); got == rotated || got.String() == "0" {
+		t.Error("Follower.check() - Expected rotation to be counted")
+	}
+
+	// The position keeps growing and maps to the offset in the new file
+	if lines[1].Position != 9 {
+		t.Errorf("Follower.Lines - Expected position: 9, got: %d", lines[1].Position)
+	}
+	ino, offset := f.Locate(lines[1].Position)
+	if ino != fileInode(t, path) || offset != 3 {
+		t.Errorf("Follower.Locate() - Expected inode %d and offset 3, got: %d, %d", fileInode(t, path), ino, offset)
+	}
+}
+
+func TestFollower_Recreate(t *testing.T) {
+	path := tempFile(t)
+	appendFile(t, path, "")
+
+	// With the skip policy the lines which are already in the new file are not read
+	f := follow(t, Config{Path: path, OnRotate: Skip})
+	settle()
+	appendFile(t, path, "a\n")
+	expectLines(t, f, "a")
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "b\n")
+	settle()
+	appendFile(t, path, "c\n")
+	expectLines(t, f, "c")
+}
+
+func TestFollower_CopyTruncate(t *testing.T) {
+	path := tempFile(t)
+	appendFile(t, path, "")
+
+	f := follow(t, Config{Path: path})
+	settle()
+	appendFile(t, path, "a\nb\n")
+	expectLines(t, f, "a", "b")
+
+	// copytruncate copies the file and truncates it in place
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	appendFile(t, path, "c\n")
+	expectLines(t, f, "c")
+}
+
+func TestFollower_Shrink(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []string
+	}{
+		{"read from start", FromStart, []string{"x", "y"}},
+		{"skip", Skip, []string{"y"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tempFile(t)
+			appendFile(t, path, "")
+
+			f := follow(t, Config{Path: path, OnTruncate: tt.policy})
+			settle()
+			appendFile(t, path, "a long line\nanother long line\n")
+			expectLines(t, f, "a long line", "another long line")
+
+			// The file is truncated and written again before the follower notices it
+			if err := os.WriteFile(path, []byte("x\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			settle()
+			appendFile(t, path, "y\n")
+			expectLines(t, f, tt.want...)
+		})
+	}
+}
+
+func TestFollower_Resume(t *testing.T) {
+	tests := []struct {
+		name    string
+		inode   func(path string) uint64
+		offset  int64
+		content string
+		want    []string
+	}{
+		{"same file", func(path string) uint64 { return fileInode(t, path) }, 2, "a\nb\nc\n", []string{"b", "c"}},
+		{"replaced file", func(path string) uint64 { return fileInode(t, path) + 1 }, 2, "a\nb\nc\n", []string{"a", "b", "c"}},
+		{"truncated file", func(path string) uint64 { return fileInode(t, path) }, 20, "a\nb\n", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tempFile(t)
+			appendFile(t, path, tt.content)
+
+			f := follow(t, Config{Path: path, Inode: tt.inode(path), Offset: tt.offset})
+			expectLines(t, f, tt.want...)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Format is the encoding of the log records
+type Format string
+
+const (
+	// Text writes the log records as key=value pairs
+	Text Format = "text"
+	// JSON writes the log records as JSON objects
+	JSON Format = "json"
+)
+
+// DefaultRepeatInterval is how long a repeated warning or error is suppressed after it was logged
+const DefaultRepeatInterval = time.Minute
+
+// Config contains the settings of the logger
+type Config struct {
+	Output io.Writer
+	Format Format
+	// Level is the minimum level of the logged records: debug, info, warn or error
+	Level string
+	// RepeatInterval is how long warnings and errors with the same message and component are suppressed after they were logged,
+	// they are never suppressed when it is 0
+	RepeatInterval time.Duration
+}
+
+// New creates a logger writing to the output of the config
+func New(c Config) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", c.Level)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch c.Format {
+	case Text, "":
+		handler = slog.NewTextHandler(c.Output, options)
+	case JSON:
+		handler = slog.NewJSONHandler(c.Output, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", c.Format)
+	}
+
+	if c.RepeatInterval > 0 {
+		handler = RateLimit(handler, c.RepeatInterval)
+	}
+	return slog.New(handler), nil
+}
+
+// Component returns a logger which adds the name of a component of logcat to each record
+func Component(logger *slog.Logger, name string) *slog.Logger {
+	return logger.With("component", name)
+}
+
+// rateLimiter is a handler which suppresses repeated warnings and errors
+// A record is repeated when a record with the same level, message and component was logged within the interval,
+// so e.g. the parse errors of all workers are limited together
+// The next record which is logged after the interval reports how many records were suppressed
+type rateLimiter struct {
+	handler   slog.Handler
+	interval  time.Duration
+	component string
+	shared    *repeats
+}
+
+// repeats tracks the repeated records of all handlers derived from the same rate limiter
+type repeats struct {
+	mu     sync.Mutex
+	logged map[string]*repeat
+}
+
+// repeat is a record which was logged and the number of times it was suppressed since then
+type repeat struct {
+	at         time.Time
+	suppressed int
+}
+
+// RateLimit returns a handler which suppresses warnings and errors which are repeated within the interval
+func RateLimit(handler slog.Handler, interval time.Duration) slog.Handler {
+	return &rateLimiter{
+		handler:  handler,
+		interval: interval,
+		shared:   &repeats{logged: make(map[string]*repeat)},
+	}
+}
+
+// Enabled reports whether the wrapped handler handles records of the level
+func (r *rateLimiter) Enabled(ctx context.Context, level slog.Level) bool {
+	return r.handler.Enabled(ctx, level)
+}
+
+// Handle passes the record to the wrapped handler unless it is a repeated warning or error
+func (r *rateLimiter) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < slog.LevelWarn {
+		return r.handler.Handle(ctx, record)
+	}
+
+	key := record.Level.String() + "|" + r.component + "|" + record.Message
+	r.shared.mu.Lock()
+	previous, ok := r.shared.logged[key]
+	if ok && record.Time.Sub(previous.at) < r.interval {
+		previous.suppressed++
+		r.shared.mu.Unlock()
+		return nil
+	}
+	suppressed := 0
+	if ok {
+		suppressed = previous.suppressed
+	}
+	r.shared.logged[key] = &repeat{at: record.Time}
+	r.forget(record.Time)
+	r.shared.mu.Unlock()
+
+	if suppressed > 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return r.handler.Handle(ctx, record)
+}
+
+// forget removes the records which were logged before the interval and were not repeated since then
+// The shared state must be locked by the caller
+func (r *rateLimiter) forget(now time.Time) {
+	for key, logged := range r.shared.logged {
+		if logged.suppressed == 0 && now.Sub(logged.at) >= r.interval {
+			delete(r.shared.logged, key)
+		}
+	}
+}
+
+// WithAttrs returns a rate limiter for the handler with the attributes
+func (r *rateLimiter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := r.component
+	for _, attr := range attrs {
+		if attr.Key == "component" {
+			component = attr.Value.String()
+		}
+	}
+	return &rateLimiter{handler: r.handler.WithAttrs(attrs), interval: r.interval, component: component, shared: r.shared}
+}
+
+// WithGroup returns a rate limiter for the handler with the group
+func (r *rateLimiter) WithGroup(name string) slog.Handler {
+	return &rateLimiter{handler: r.handler.WithGroup(name), interval: r.interval, component: r.component, shared: r.shared}
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    Format
+		level     string
+		wantError bool
+		wantDebug bool
+		wantJSON  bool
+	}{
+		{name: "text", format: Text, level: "info"},
+		{name: "default format", format: "", level: "info"},
+		{name: "json debug", format: JSON, level: "debug", wantDebug: true, wantJSON: true},
+		{name: "upper case level", format: Text, level: "WARN"},
+		{name: "unknown format", format: "xml", level: "info", wantError: true},
+		{name: "unknown level", format: Text, level: "verbose", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			logger, err := New(Config{Output: &output, Format: tt.format, Level: tt.level})
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("New() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			Component(logger, "writer").Error("failed to write", "error", "disk full")
+			Component(logger, "writer").Debug("details")
+			if !strings.Contains(output.String(), "writer") || !strings.Contains(output.String(), "disk full") {
+				t.Errorf("New() logged %q, want the component and the error", output.String())
+			}
+			if got := strings.Contains(output.String(), "details"); got != tt.wantDebug {
+				t.Errorf("New() logged debug record = %v, want %v", got, tt.wantDebug)
+			}
+			if got := strings.HasPrefix(output.String(), "{"); got != tt.wantJSON {
+				t.Errorf("New() logged JSON = %v, want %v", got, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var output bytes.Buffer
+	handler := RateLimit(slog.NewTextHandler(&output, nil), time.Minute)
+	worker := handler.WithAttrs([]slog.Attr{slog.String("component", "worker")})
+	// the records of all workers are limited together
+	otherWorker := handler.WithAttrs([]slog.Attr{slog.String("component", "worker")}).WithAttrs([]slog.Attr{slog.Int("worker", 2)})
+	writer := handler.WithAttrs([]slog.Attr{slog.String("component", "writer")})
+	start := time.Now()
+
+	tests := []struct {
+		name           string
+		handler        slog.Handler
+		elapsed        time.Duration
+		level          slog.Level
+		message        string
+		wantLogged     bool
+		wantSuppressed string
+	}{
+		{"first error", worker, 0, slog.LevelError, "failed to parse line", true, ""},
+		{"repeated error", worker, time.Second, slog.LevelError, "failed to parse line", false, ""},
+		{"repeated by another worker", otherWorker, 2 * time.Second, slog.LevelError, "failed to parse line", false, ""},
+		{"other component", writer, 3 * time.Second, slog.LevelError, "failed to parse line", true, ""},
+		{"other message", worker, 4 * time.Second, slog.LevelError, "failed to encode", true, ""},
+		{"other level", worker, 5 * time.Second, slog.LevelWarn, "failed to parse line", true, ""},
+		{"info is not limited", worker, 6 * time.Second, slog.LevelInfo, "reloaded file", true, ""},
+		{"info is not limited again", worker, 7 * time.Second, slog.LevelInfo, "reloaded file", true, ""},
+		{"after the interval", worker, 2 * time.Minute, slog.LevelError, "failed to parse line", true, "suppressed=2"},
+		{"counting starts again", worker, 2*time.Minute + time.Second, slog.LevelError, "failed to parse line", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output.Reset()
+			record := slog.NewRecord(start.Add(tt.elapsed), tt.level, tt.message, 0)
+			if err := tt.handler.Handle(context.Background(), record); err != nil {
+				t.Fatalf("Handle() error = %v", err)
+			}
+
+			if got := output.Len() > 0; got != tt.wantLogged {
+				t.Errorf("Handle() logged = %v, want %v: %q", got, tt.wantLogged, output.String())
+			}
+			if tt.wantSuppressed != "" && !strings.Contains(output.String(), tt.wantSuppressed) {
+				t.Errorf("Handle() logged %q, want %q", output.String(), tt.wantSuppressed)
+			}
+			if tt.wantSuppressed == "" && strings.Contains(output.String(), "suppressed") {
+				t.Errorf("Handle() logged %q, want no suppressed count", output.String())
+			}
+		})
+	}
+}
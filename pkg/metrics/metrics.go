@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+var (
+	// UnmappedRepositories counts the billing log entries per repository which is not mapped to a project
+	UnmappedRepositories = expvar.NewMap("logcat_unmapped_repositories")
+
+	// ExcludedSites counts the billing log entries per site which were dropped because the site is excluded
+	ExcludedSites = expvar.NewMap("logcat_excluded_sites")
+
+	// DroppedDuplicates counts the billing log entries which were dropped because their trace ID was already seen
+	DroppedDuplicates = expvar.NewInt("logcat_dropped_duplicates")
+
+	// Workers is the current number of workers in the pool
+	Workers = expvar.NewInt("logcat_workers")
+
+	// WorkQueueDepth is the number of log lines waiting in the work queue at the last check
+	WorkQueueDepth = expvar.NewInt("logcat_work_queue_depth")
+
+	// ReorderStalls counts how many times reading input lines had to wait because the reorder buffer was full
+	ReorderStalls = expvar.NewInt("logcat_reorder_stalls")
+
+	// ReorderPending is the number of results in the reorder buffer waiting for an earlier result
+	ReorderPending = expvar.NewInt("logcat_reorder_pending")
+
+	// AbandonedLines counts the input lines which were not written because draining the pipeline on shutdown took too long
+	AbandonedLines = expvar.NewInt("logcat_abandoned_lines")
+
+	// InputEvents counts how many times the input file was truncated, shrunk or rotated
+	InputEvents = expvar.NewMap("logcat_input_events")
+
+	// DroppedLines counts the input lines which were dropped because the pipeline was overloaded
+	DroppedLines = expvar.NewInt("logcat_dropped_lines")
+
+	// SpilledLines is the number of input lines waiting in the spill file
+	SpilledLines = expvar.NewInt("logcat_spilled_lines")
+
+	// LagBytes is the size of the input which was read but not written yet at the last check
+	LagBytes = expvar.NewInt("logcat_lag_bytes")
+
+	// LagSeconds is how long the output has not caught up with the input at the last check
+	LagSeconds = expvar.NewFloat("logcat_lag_seconds")
+
+	// WALPending is the number of billing log entries in the write-ahead log which were not written yet
+	WALPending = expvar.NewInt("logcat_wal_pending")
+
+	// WALRetries counts the billing log entries which the sink failed to write and were sent again
+	WALRetries = expvar.NewInt("logcat_wal_retries")
+
+	// QuotaAlerts counts the alerts fired when a quota crossed a threshold
+	QuotaAlerts = expvar.NewInt("logcat_quota_alerts")
+
+	// QuotaAlertFailures counts the quota alerts which a webhook or command failed to deliver
+	QuotaAlertFailures = expvar.NewInt("logcat_quota_alert_failures")
+
+	// HookFailures counts the events which a file hook failed to deliver after all retries
+	HookFailures = expvar.NewInt("logcat_hook_failures")
+
+	// WriterDegraded is 1 per writer while it can not open an output file and buffers the lines, and 0 otherwise
+	WriterDegraded = expvar.NewMap("logcat_writer_degraded")
+
+	// WriterOpenFailures counts the failed attempts of each writer to open an output file
+	WriterOpenFailures = expvar.NewMap("logcat_writer_open_failures")
+
+	// WriterBuffered is the number of lines each writer buffers until it can open an output file again
+	WriterBuffered = expvar.NewMap("logcat_writer_buffered")
+
+	// WriterDropped counts the buffered lines each writer dropped because it stopped while it could not open an output file
+	WriterDropped = expvar.NewMap("logcat_writer_dropped")
+
+	// WriterFlushes counts the writes of the buffered lines of each writer to its output file
+	WriterFlushes = expvar.NewMap("logcat_writer_flushes")
+
+	// WriterFlushFailures counts the failed writes of the buffered lines of each writer to its output file
+	WriterFlushFailures = expvar.NewMap("logcat_writer_flush_failures")
+
+	// WriterSyncs counts the syncs of the output files of each writer to disk
+	WriterSyncs = expvar.NewMap("logcat_writer_syncs")
+)
+
+// health contains the problems of the components which are unhealthy
+var health = struct {
+	sync.Mutex
+	problems map[string]string
+}{problems: make(map[string]string)}
+
+// SetHealth records the problem of a component, a nil error marks the component as healthy
+func SetHealth(component string, err error) {
+	health.Lock()
+	defer health.Unlock()
+	if err == nil {
+		delete(health.problems, component)
+		return
+	}
+	health.problems[component] = err.Error()
+}
+
+// Health returns the problems of the components which are unhealthy by component
+func Health() map[string]string {
+	health.Lock()
+	defer health.Unlock()
+	problems := make(map[string]string, len(health.problems))
+	for component, problem := range health.problems {
+		problems[component] = problem
+	}
+	return problems
+}
+
+// serveHealth responds with 200 when all components are healthy and with 503 and their problems otherwise
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	problems := Health()
+	w.Header().Set("Content-Type", "application/json")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"healthy": len(problems) == 0, "problems": problems})
+}
+
+// Serve exposes the metrics in JSON format on the /debug/vars path of the given address
+// and the health of the components on the /health path
+// The server runs in the background and errors are logged
+func Serve(addr string, logger *slog.Logger) {
+	http.HandleFunc("/health", serveHealth)
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			logger.Error("metrics server stopped", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// names of the fields which can be present in a request log line
+const (
+	FieldTimestamp      = "timestamp"
+	FieldTraceID        = "trace_id"
+	FieldIP             = "ip"
+	FieldUser           = "user"
+	FieldMethod         = "method"
+	FieldPath           = "path"
+	FieldStatus         = "status"
+	FieldRequestLength  = "request_length"
+	FieldResponseLength = "response_length"
+	FieldDuration       = "duration"
+	FieldUserAgent      = "user_agent"
+)
+
+// SkipField is used in a column list for columns that should be ignored
+const SkipField = "-"
+
+var (
+	// knownFields contains all the field names that can be mapped
+	knownFields = map[string]bool{
+		FieldTimestamp:      true,
+		FieldTraceID:        true,
+		FieldIP:             true,
+		FieldUser:           true,
+		FieldMethod:         true,
+		FieldPath:           true,
+		FieldStatus:         true,
+		FieldRequestLength:  true,
+		FieldResponseLength: true,
+		FieldDuration:       true,
+		FieldUserAgent:      true,
+	}
+
+	// requiredFields contains the fields without which a billing log entry can not be created
+	requiredFields = []string{
+		FieldTimestamp,
+		FieldIP,
+		FieldUser,
+		FieldMethod,
+		FieldPath,
+		FieldStatus,
+		FieldResponseLength,
+	}
+)
+
+// DefaultColumns is the column order of the Artifactory request log
+var DefaultColumns = []string{
+	FieldTimestamp,
+	FieldTraceID,
+	FieldIP,
+	FieldUser,
+	FieldMethod,
+	FieldPath,
+	FieldStatus,
+	FieldRequestLength,
+	FieldResponseLength,
+	FieldDuration,
+	FieldUserAgent,
+}
+
+// FieldMap maps a field name to its index in a split log line
+type FieldMap map[string]int
+
+// DefaultFieldMap returns the field map of the Artifactory request log
+func DefaultFieldMap() FieldMap {
+	fields, _ := NewFieldMap(DefaultColumns)
+	return fields
+}
+
+// NewFieldMap creates a FieldMap out of an ordered list of column names
+// Columns named SkipField are not mapped
+func NewFieldMap(columns []string) (FieldMap, error) {
+	fields := make(FieldMap, len(columns))
+	for i, column := range columns {
+		name := strings.TrimSpace(column)
+		if name == SkipField {
+			continue
+		}
+		if !knownFields[name] {
+			return nil, fmt.Errorf("unknown field name %q at column %d", name, i)
+		}
+		if _, ok := fields[name]; ok {
+			return nil, fmt.Errorf("field %q is mapped more than once", name)
+		}
+		fields[name] = i
+	}
+	return fields, nil
+}
+
+// Validate checks that all required fields are mapped to a column
+// which is in the range of the number of fields in a log line
+func (f FieldMap) Validate(numFields int) error {
+	for _, name := range requiredFields {
+		if _, ok := f[name]; !ok {
+			return fmt.Errorf("required field %q is not mapped", name)
+		}
+	}
+	for name, index := range f {
+		if !knownFields[name] {
+			return fmt.Errorf("unknown field name %q", name)
+		}
+		if index < 0 || index >= numFields {
+			return fmt.Errorf("field %q is mapped to column %d, expected a column between 0 and %d", name, index, numFields-1)
+		}
+	}
+	return nil
+}
+
+// value returns the value of a field from a split log line
+// or an empty string if the field is not mapped
+func (f FieldMap) value(split []string, name string) string {
+	index, ok := f[name]
+	if !ok || index < 0 || index >= len(split) {
+		return ""
+	}
+	return split[index]
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestNewFieldMap(t *testing.T) {
+	tests := []struct {
+		name       string
+		columns    []string
+		wantFields FieldMap
+		wantError  bool
+	}{
+		{
+			name:       "DefaultColumns",
+			columns:    DefaultColumns,
+			wantFields: FieldMap{FieldTimestamp: 0, FieldTraceID: 1, FieldIP: 2, FieldUser: 3, FieldMethod: 4, FieldPath: 5, FieldStatus: 6, FieldRequestLength: 7, FieldResponseLength: 8, FieldDuration: 9, FieldUserAgent: 10},
+			wantError:  false,
+		},
+		{
+			name:       "SkippedColumns",
+			columns:    []string{"timestamp", "-", " ip "},
+			wantFields: FieldMap{FieldTimestamp: 0, FieldIP: 2},
+			wantError:  false,
+		},
+		{
+			name:      "UnknownField",
+			columns:   []string{"timestamp", "referer"},
+			wantError: true,
+		},
+		{
+			name:      "DuplicateField",
+			columns:   []string{"timestamp", "timestamp"},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotFields, gotError := NewFieldMap(tt.columns)
+
+			if tt.wantError {
+				if gotError == nil {
+					t.Errorf("NewFieldMap() error = %v, wantErr %v", gotError, tt.wantError)
+				}
+				return
+			}
+			if gotError != nil {
+				t.Fatalf("NewFieldMap() unexpected error = %v", gotError)
+			}
+
+			if len(gotFields) != len(tt.wantFields) {
+				t.Fatalf("NewFieldMap() result = %v, want %v", gotFields, tt.wantFields)
+			}
+			for name, index := range tt.wantFields {
+				if gotFields[name] != index {
+					t.Errorf("NewFieldMap() field %q = %d, want %d", name, gotFields[name], index)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldMap_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    FieldMap
+		numFields int
+		wantError bool
+	}{
+		{
+			name:      "DefaultFieldMap",
+			fields:    DefaultFieldMap(),
+			numFields: 11,
+			wantError: false,
+		},
+		{
+			name:      "MissingRequiredField",
+			fields:    FieldMap{FieldTimestamp: 0, FieldIP: 1, FieldUser: 2, FieldMethod: 3, FieldPath: 4, FieldStatus: 5},
+			numFields: 6,
+			wantError: true,
+		},
+		{
+			name:      "IndexOutOfRange",
+			fields:    DefaultFieldMap(),
+			numFields: 9,
+			wantError: true,
+		},
+		{
+			name:      "UnknownField",
+			fields:    FieldMap{FieldTimestamp: 0, FieldIP: 1, FieldUser: 2, FieldMethod: 3, FieldPath: 4, FieldStatus: 5, FieldResponseLength: 6, "referer": 7},
+			numFields: 8,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotError := tt.fields.Validate(tt.numFields)
+			if (gotError != nil) != tt.wantError {
+				t.Errorf("FieldMap.Validate() error = %v, wantErr %v", gotError, tt.wantError)
+			}
+		})
+	}
+}
@@ -1,14 +1,30 @@
 package parser
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultProject is the project of billing log entries which are not assigned to another project
+const DefaultProject = "default"
+
+// SchemaVersion is the version of the billing log fields, which is written by the output formats which record it
+const SchemaVersion = 1
+
 // RequestLogs stores the request log entries which are read from file
 type RequestLogs struct {
-	timestamp string
-	ip        string
-	user      string
-	method    string
-	path      string
-	status    string
-	size      string
+	timestamp     string
+	traceID       string
+	ip            string
+	user          string
+	method        string
+	path          string
+	status        string
+	requestLength string
+	size          string
+	duration      string
+	userAgent     string
 }
 
 // BillingLogs stores the billing log entry we create
@@ -24,4 +40,39 @@ type BillingLogs struct {
 	User            string `json:"user_name"`
 	ConsumptionUnit string `json:"consumption_unit"`
 	Quantity        int64  `json:"quantity"`
+
+	// user metadata added by the enrichment stage
+	Team           string `json:"team,omitempty"`
+	CostCenter     string `json:"cost_center,omitempty"`
+	EmailDomain    string `json:"email_domain,omitempty"`
+	ServiceAccount bool   `json:"service_account,omitempty"`
+
+	// site of the remote IP added by the enrichment stage
+	Site string `json:"site,omitempty"`
+
+	// cost of the request added by the pricing stage
+	Cost     float64 `json:"cost,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+
+	// request details which are used by the processing stages and not written to the output
+	// unless they are enabled as optional fields of the output schema
+	TraceID     string    `json:"-"`
+	RequestTime time.Time `json:"-"`
+	UserAgent   string    `json:"-"`
+	Status      int       `json:"-"`
+	// Duration is the duration of the request in milliseconds as written by Artifactory
+	Duration    int64  `json:"-"`
+	PackageType string `json:"-"`
+
+	// Offset is the position of the input line in the input stream, so the stages can tell whether the line was written
+	Offset int64 `json:"-"`
+}
+
+// Marshal returns the billing log entry as a JSON string
+func (b *BillingLogs) Marshal() (string, error) {
+	logEntry, err := json.Marshal(b)
+	if err != nil {
+		return "", fmt.Errorf("could not log billing entry: %v", err)
+	}
+	return string(logEntry), nil
 }
@@ -1,48 +1,66 @@
 package parser
 
 import (
-	"encoding/json"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	reRepository     = regexp.MustCompile(`/([^/]+)/[^/]+/([^/]+)`)
 	rePathTechnology = regexp.MustCompile(`/[^/]+/[^/]+/[^/]+/[^/]+/([^/].*)`)
 	rePathGeneric    = regexp.MustCompile(`/[^/]+/([^/].*)`)
+	rePackageType    = regexp.MustCompile(`^/api/([^/]+)/`)
 )
 
 // Parse takes a log line containing data separated by a delimiter
 // and returns a string with the parsed and modified data
-func Parse(line string, delimiter string, numFields int, serverName string) (string, error) {
+// The fields map describes in which column each of the request log fields is found
+// and the location is the time zone of the billing timestamp, UTC is used when it is nil
+func Parse(line string, delimiter string, numFields int, fields FieldMap, serverName string, location *time.Location) (string, error) {
+	billingLog, err := ParseEntry(line, delimiter, numFields, fields, serverName, location)
+	if err != nil || billingLog == nil {
+		return "", err
+	}
+	return billingLog.Marshal()
+}
+
+// ParseEntry takes a log line containing data separated by a delimiter
+// and returns the billing log entry for it
+// A nil entry is returned when the line does not contain information suitable for a billing log
+func ParseEntry(line string, delimiter string, numFields int, fields FieldMap, serverName string, location *time.Location) (*BillingLogs, error) {
 	// check if the input string should be processed
 	split := strings.Split(line, delimiter)
 	if len(split) != numFields {
-		return "", fmt.Errorf("missmatch number of fields for line: %v : expected number of fields: %d, found %d\n", line, numFields, len(split))
+		return nil, fmt.Errorf("mismatched number of fields: expected %d, found %d", numFields, len(split))
 	}
 
 	// save the request log entry in the RequestLogs struct
 	r := RequestLogs{
-		timestamp: split[0],
-		ip:        split[2],
-		user:      split[3],
-		method:    split[4],
-		path:      split[5],
-		status:    split[6],
-		size:      split[8],
+		timestamp:     fields.value(split, FieldTimestamp),
+		traceID:       fields.value(split, FieldTraceID),
+		ip:            fields.value(split, FieldIP),
+		user:          fields.value(split, FieldUser),
+		method:        fields.value(split, FieldMethod),
+		path:          fields.value(split, FieldPath),
+		status:        fields.value(split, FieldStatus),
+		requestLength: fields.value(split, FieldRequestLength),
+		size:          fields.value(split, FieldResponseLength),
+		duration:      fields.value(split, FieldDuration),
+		userAgent:     fields.value(split, FieldUserAgent),
 	}
 
 	// add checks if the request contains information suitable for billing log
 	if r.status != "200" || (r.method != "GET" && r.method != "HEAD") || r.size == "0" || r.user == "non_authenticated_user" || r.user == "anonymous" {
-		return "", nil
+		return nil, nil
 	}
 
 	// naming pattern suggested by Artifactory is to have all remote repositories have a suffix "-remote"
 	repo := reRepository.FindStringSubmatch(r.path)
 	if repo == nil || (!strings.Contains(repo[1], "-remote") && !strings.Contains(repo[2], "-remote")) {
-		return "", nil
+		return nil, nil
 	}
 
 	// start parsing the valuable information
@@ -54,7 +72,7 @@ func Parse(line string, delimiter string, numFields int, serverName string) (str
 		repository = repo[1]
 		path := rePathGeneric.FindStringSubmatch(r.path)
 		if path == nil {
-			return "", nil
+			return nil, nil
 		}
 		artifactoryPath = path[1]
 	}
@@ -65,46 +83,49 @@ func Parse(line string, delimiter string, numFields int, serverName string) (str
 		path := rePathTechnology.FindStringSubmatch(r.path)
 		// check if the requests is getting a token
 		if path == nil || strings.Compare(path[1], "token") == 0 {
-			return "", nil
+			return nil, nil
 		}
 		artifactoryPath = strings.Replace(strings.Replace(path[1], ":", "__", 1), "/blobs/", "/", 1)
 	}
 
-	time := strings.Split(r.timestamp, "T")
-	if len(time) != 2 {
-		return "", fmt.Errorf("could not parse timestamp from request log")
-	}
-
-	splitTimestampTime := strings.Split(time[1], ":")
-	if len(time) != 2 {
-		return "", fmt.Errorf("could not parse timestamp from request log")
+	requestTime, err := ParseTimestamp(r.timestamp)
+	if err != nil {
+		return nil, err
 	}
-
-	timestamp := time[0] + " " + splitTimestampTime[0] + ":00:00.000"
+	timestamp := BillingTimestamp(requestTime, location)
 
 	quantity, err := strconv.ParseInt(r.size, 10, 64)
 	if err != nil {
-		return "", fmt.Errorf("cound not parse response size from request log: %v", err)
+		return nil, fmt.Errorf("cound not parse response size from request log: %v", err)
+	}
+
+	// the optional fields are only written when they are enabled in the output schema, so bad values are not an error
+	status, _ := strconv.Atoi(r.status)
+	duration, _ := strconv.ParseInt(r.duration, 10, 64)
+	var packageType string
+	if match := rePackageType.FindStringSubmatch(r.path); match != nil {
+		packageType = match[1]
 	}
 
-	billingLog := BillingLogs{
+	billingLog := &BillingLogs{
 		Timestamp:       timestamp,
 		ServerName:      serverName,
 		Service:         "artifactory",
 		Action:          "download",
 		RemoteIP:        r.ip,
 		Repository:      repository,
-		Project:         "default",
+		Project:         DefaultProject,
 		ArtifactoryPath: artifactoryPath,
 		User:            r.user,
 		ConsumptionUnit: "bytes",
 		Quantity:        quantity,
+		TraceID:         r.traceID,
+		RequestTime:     requestTime,
+		UserAgent:       r.userAgent,
+		Status:          status,
+		Duration:        duration,
+		PackageType:     packageType,
 	}
 
-	logEntry, err := json.Marshal(billingLog)
-	if err != nil {
-		return "", fmt.Errorf("could not log billing entry: %v", err)
-	}
-
-	return string(logEntry), nil
+	return billingLog, nil
 }
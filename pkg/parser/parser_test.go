@@ -2,6 +2,7 @@ package parser
 
 import (
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -10,7 +11,9 @@ func TestParse(t *testing.T) {
 		line       string
 		delimiter  string
 		numFields  int
+		fields     FieldMap
 		serverName string
+		location   *time.Location
 		wantResult string
 		wantError  bool
 	}{
@@ -19,6 +22,7 @@ func TestParse(t *testing.T) {
 			line:       "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
 			delimiter:  "|",
 			numFields:  11,
+			fields:     DefaultFieldMap(),
 			serverName: "artifactory.domain",
 			wantResult: `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234}`,
 			wantError:  false,
@@ -28,6 +32,7 @@ func TestParse(t *testing.T) {
 			line:       "2023-06-15T12:34:56.789Z|GET|127.0.0.1|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|response|12345",
 			delimiter:  "|",
 			numFields:  11,
+			fields:     DefaultFieldMap(),
 			serverName: "artifactory.domain",
 			wantResult: "",
 			wantError:  true,
@@ -37,6 +42,38 @@ func TestParse(t *testing.T) {
 			line:       "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
 			delimiter:  ";",
 			numFields:  11,
+			fields:     DefaultFieldMap(),
+			serverName: "artifactory.domain",
+			wantResult: "",
+			wantError:  true,
+		},
+		{
+			name:       "ValidLogEntryCustomFieldMap",
+			line:       "1.2.3.4;user;2023-06-15T12:34:56.789Z;GET;/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest;200;1234",
+			delimiter:  ";",
+			numFields:  7,
+			fields:     FieldMap{FieldIP: 0, FieldUser: 1, FieldTimestamp: 2, FieldMethod: 3, FieldPath: 4, FieldStatus: 5, FieldResponseLength: 6},
+			serverName: "artifactory.domain",
+			wantResult: `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234}`,
+			wantError:  false,
+		},
+		{
+			name:       "ValidLogEntryOutputLocation",
+			line:       "2023-06-15T23:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+			delimiter:  "|",
+			numFields:  11,
+			fields:     DefaultFieldMap(),
+			serverName: "artifactory.domain",
+			location:   time.FixedZone("UTC+2", 2*60*60),
+			wantResult: `{"billing_timestamp":"2023-06-16 01:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234}`,
+			wantError:  false,
+		},
+		{
+			name:       "InvalidLogEntryTimestamp",
+			line:       "2023-06-15 12:34:56|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+			delimiter:  "|",
+			numFields:  11,
+			fields:     DefaultFieldMap(),
 			serverName: "artifactory.domain",
 			wantResult: "",
 			wantError:  true,
@@ -45,7 +82,7 @@ func TestParse(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotResult, gotError := Parse(tt.line, tt.delimiter, tt.numFields, tt.serverName)
+			gotResult, gotError := Parse(tt.line, tt.delimiter, tt.numFields, tt.fields, tt.serverName, tt.location)
 
 			if tt.wantError {
 				if gotError == nil {
@@ -0,0 +1,269 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// OptionalFields are the fields which are only written when they are enabled in the output schema
+var OptionalFields = []string{"trace_id", "user_agent", "status", "duration", "package_type", "site", "request_count"}
+
+// ConstantFields are the fields with the same value in every billing log entry, which can be overridden in the output schema
+var ConstantFields = []string{"service", "action", "consumption_unit"}
+
+// renamePrefix is the prefix of the manifest metadata keys which record the names the renamed fields are written with
+const renamePrefix = "schema_rename_"
+
+// Schema describes the fields of the billing log entries which are written to the output
+// The default schema writes the entries as they are encoded by Marshal
+type Schema struct {
+	// Version is written to the output files and should be increased whenever the fields change
+	Version int `json:"version"`
+	// Fields are the optional fields which are added after the default fields in the given order
+	Fields []string `json:"fields"`
+	// Rename maps field names to the names they are written with
+	Rename map[string]string `json:"rename"`
+	// Constants override the values of the constant fields
+	Constants map[string]string `json:"constants"`
+
+	columns []column
+}
+
+// Column is a field of the billing log entries written by a schema
+// Type is the kind of its values: string, int, float or bool
+type Column struct {
+	Name string
+	Type string
+}
+
+// column is a field written by a schema
+// index is the index of the BillingLogs field and -1 for the optional fields
+type column struct {
+	field     string
+	name      string
+	kind      string
+	index     int
+	omitEmpty bool
+}
+
+// NewSchema validates the schema and creates a Schema out of it
+// The schema version defaults to SchemaVersion
+func NewSchema(s Schema) (*Schema, error) {
+	if s.Version == 0 {
+		s.Version = SchemaVersion
+	}
+	if s.Version < 0 {
+		return nil, fmt.Errorf("schema version must be greater than 0, got %d", s.Version)
+	}
+
+	schema := &Schema{
+		Version:   s.Version,
+		Fields:    append([]string(nil), s.Fields...),
+		Rename:    make(map[string]string),
+		Constants: make(map[string]string),
+	}
+	for field, value := range s.Constants {
+		if !contains(ConstantFields, field) {
+			return nil, fmt.Errorf("field %q is not a constant field, expected one of %s", field, strings.Join(ConstantFields, ", "))
+		}
+		schema.Constants[field] = value
+	}
+
+	// the default fields are the fields of the billing log entries which have a JSON name
+	t := reflect.TypeOf(BillingLogs{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+		schema.columns = append(schema.columns, column{
+			field:     tag[0],
+			kind:      kindOf(t.Field(i).Type.Kind()),
+			index:     i,
+			omitEmpty: len(tag) > 1 && tag[1] == "omitempty",
+		})
+	}
+
+	enabled := make(map[string]bool)
+	for _, field := range s.Fields {
+		if !contains(OptionalFields, field) {
+			return nil, fmt.Errorf("unknown optional field %q, expected one of %s", field, strings.Join(OptionalFields, ", "))
+		}
+		if enabled[field] {
+			return nil, fmt.Errorf("optional field %q is enabled more than once", field)
+		}
+		enabled[field] = true
+
+		found := false
+		for i, c := range schema.columns {
+			if c.field == field {
+				// an enabled default field like site is written even when it is empty
+				schema.columns[i].omitEmpty = false
+				found = true
+			}
+		}
+		if !found {
+			schema.columns = append(schema.columns, column{field: field, kind: optionalKind(field), index: -1})
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, c := range schema.columns {
+		name := c.field
+		if rename, ok := s.Rename[c.field]; ok {
+			if rename == "" {
+				return nil, fmt.Errorf("field %q must not be renamed to an empty name", c.field)
+			}
+			name = rename
+			schema.Rename[c.field] = rename
+		}
+		if names[name] {
+			return nil, fmt.Errorf("more than one field is written as %q", name)
+		}
+		names[name] = true
+		schema.columns[i].name = name
+	}
+	for field := range s.Rename {
+		if _, ok := schema.Rename[field]; !ok {
+			return nil, fmt.Errorf("renamed field %q is not written by the schema", field)
+		}
+	}
+	return schema, nil
+}
+
+// LoadSchema reads a JSON schema file and creates a Schema out of it
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %v", err)
+	}
+
+	var s Schema
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %v", err)
+	}
+	return NewSchema(s)
+}
+
+// Columns returns the fields written by the schema in order with the names they are written with
+func (s *Schema) Columns() []Column {
+	columns := make([]Column, 0, len(s.columns))
+	for _, c := range s.columns {
+		columns = append(columns, Column{Name: c.name, Type: c.kind})
+	}
+	return columns
+}
+
+// Metadata returns the names the renamed fields are written with, which are recorded in the manifest of each output file
+func (s *Schema) Metadata() map[string]string {
+	metadata := make(map[string]string, len(s.Rename))
+	for field, name := range s.Rename {
+		metadata[renamePrefix+field] = name
+	}
+	return metadata
+}
+
+// Renamed returns the fields by the names they were written with out of the metadata of a manifest
+func Renamed(metadata map[string]string) map[string]string {
+	renamed := make(map[string]string)
+	for key, name := range metadata {
+		if field := strings.TrimPrefix(key, renamePrefix); field != key {
+			renamed[name] = field
+		}
+	}
+	return renamed
+}
+
+// Marshal returns the billing log entry as a JSON string with the fields of the schema
+func (s *Schema) Marshal(b *BillingLogs) (string, error) {
+	entry := reflect.ValueOf(b).Elem()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, c := range s.columns {
+		var value interface{}
+		if constant, ok := s.Constants[c.field]; ok {
+			value = constant
+		} else if c.index >= 0 {
+			field := entry.Field(c.index)
+			if c.omitEmpty && field.IsZero() {
+				continue
+			}
+			value = field.Interface()
+		} else {
+			value = b.optional(c.field)
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(c.name)
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("could not log billing entry: %v", err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.String(), nil
+}
+
+// optional returns the value of an optional field which is not one of the default fields
+func (b *BillingLogs) optional(field string) interface{} {
+	switch field {
+	case "trace_id":
+		return b.TraceID
+	case "user_agent":
+		return b.UserAgent
+	case "status":
+		return b.Status
+	case "duration":
+		return b.Duration
+	case "package_type":
+		return b.PackageType
+	case "request_count":
+		// every billing log entry is a single request
+		return 1
+	}
+	return nil
+}
+
+// optionalKind returns the kind of the values of an optional field
+func optionalKind(field string) string {
+	switch field {
+	case "status", "duration", "request_count":
+		return "int"
+	}
+	return "string"
+}
+
+// kindOf returns the kind of the values of a field with the reflect kind
+func kindOf(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	}
+	return "string"
+}
+
+// contains returns whether the value is one of the values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
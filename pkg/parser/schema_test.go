@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const schemaTestLine = "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123"
+
+func TestSchema_Marshal(t *testing.T) {
+	tests := []struct {
+		name       string
+		schema     Schema
+		site       string
+		wantResult string
+	}{
+		{
+			name:       "default schema",
+			schema:     Schema{},
+			wantResult: `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234}`,
+		},
+		{
+			name:       "default schema with site",
+			schema:     Schema{},
+			site:       "berlin",
+			wantResult: `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234,"site":"berlin"}`,
+		},
+		{
+			name:       "optional fields",
+			schema:     Schema{Version: 2, Fields: []string{"request_count", "trace_id", "user_agent", "status", "duration", "package_type", "site"}},
+			wantResult: `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234,"site":"","request_count":1,"trace_id":"abcdefgh12345678","user_agent":"user-agent123","status":200,"duration":567,"package_type":"docker"}`,
+		},
+		{
+			name: "renamed fields and constants",
+			schema: Schema{
+				Fields:    []string{"trace_id"},
+				Rename:    map[string]string{"user_name": "user", "trace_id": "request_id", "consumption_unit": "unit"},
+				Constants: map[string]string{"service": "jfrog", "consumption_unit": "B"},
+			},
+			wantResult: `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"jfrog","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user":"user","unit":"B","quantity":1234,"request_id":"abcdefgh12345678"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := NewSchema(tt.schema)
+			if err != nil {
+				t.Fatalf("NewSchema() error = %v", err)
+			}
+			entry, err := ParseEntry(schemaTestLine, "|", len(DefaultColumns), DefaultFieldMap(), "artifactory.domain", nil)
+			if err != nil || entry == nil {
+				t.Fatalf("ParseEntry() entry = %v, error = %v", entry, err)
+			}
+			entry.Site = tt.site
+
+			gotResult, err := schema.Marshal(entry)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if gotResult != tt.wantResult {
+				t.Errorf("Marshal() result = %v, want %v", gotResult, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestSchema_MarshalMatchesDefault(t *testing.T) {
+	schema, err := NewSchema(Schema{})
+	if err != nil {
+		t.Fatalf("NewSchema() error = %v", err)
+	}
+	entry := &BillingLogs{
+		Timestamp:      "2023-06-15 12:00:00.000",
+		User:           "<user>&",
+		Quantity:       1,
+		Team:           "team",
+		ServiceAccount: true,
+		Cost:           0.000123,
+		Currency:       "EUR",
+	}
+
+	want, err := entry.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	got, err := schema.Marshal(entry)
+	if err != nil {
+		t.Fatalf("Schema.Marshal() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Schema.Marshal() result = %v, want %v", got, want)
+	}
+}
+
+func TestSchema_Columns(t *testing.T) {
+	schema, err := NewSchema(Schema{Fields: []string{"status", "user_agent"}, Rename: map[string]string{"quantity": "bytes"}})
+	if err != nil {
+		t.Fatalf("NewSchema() error = %v", err)
+	}
+
+	columns := schema.Columns()
+	want := []Column{{Name: "bytes", Type: "int"}, {Name: "team", Type: "string"}, {Name: "cost", Type: "float"}, {Name: "status", Type: "int"}, {Name: "user_agent", Type: "string"}}
+	for _, column := range want {
+		found := false
+		for _, c := range columns {
+			if reflect.DeepEqual(c, column) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Columns() = %v, missing %v", columns, column)
+		}
+	}
+	if columns[len(columns)-1].Name != "user_agent" {
+		t.Errorf("Columns() = %v, want the optional fields last", columns)
+	}
+	if schema.Version != SchemaVersion {
+		t.Errorf("Version = %d, want %d", schema.Version, SchemaVersion)
+	}
+}
+
+func TestNewSchema_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema Schema
+	}{
+		{name: "negative version", schema: Schema{Version: -1}},
+		{name: "unknown optional field", schema: Schema{Fields: []string{"referer"}}},
+		{name: "optional field enabled twice", schema: Schema{Fields: []string{"status", "status"}}},
+		{name: "unknown constant field", schema: Schema{Constants: map[string]string{"repository": "x"}}},
+		{name: "empty name", schema: Schema{Rename: map[string]string{"ip": ""}}},
+		{name: "duplicate name", schema: Schema{Rename: map[string]string{"ip": "user_name"}}},
+		{name: "renamed field not written", schema: Schema{Rename: map[string]string{"trace_id": "id"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSchema(tt.schema); err == nil {
+				t.Errorf("NewSchema() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schema.json")
+	if err = os.WriteFile(path, []byte(`{"version": 3, "fields": ["request_count"], "rename": {"quantity": "bytes"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := LoadSchema(path)
+	if err != nil {
+		t.Fatalf("LoadSchema() error = %v", err)
+	}
+	if schema.Version != 3 || schema.Rename["quantity"] != "bytes" {
+		t.Errorf("LoadSchema() = %+v", schema)
+	}
+
+	if err = os.WriteFile(path, []byte(`{"version": 3, "field": ["request_count"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadSchema(path); err == nil {
+		t.Errorf("LoadSchema() error = nil, want an error for an unknown key")
+	}
+}
+
+func TestSchema_Metadata(t *testing.T) {
+	schema, err := NewSchema(Schema{Fields: []string{"trace_id"}, Rename: map[string]string{"quantity": "bytes", "trace_id": "request_id"}})
+	if err != nil {
+		t.Fatal("NewSchema() returned an error:", err)
+	}
+
+	metadata := schema.Metadata()
+	metadata["format"] = "json"
+	renamed := Renamed(metadata)
+	if len(renamed) != 2 || renamed["bytes"] != "quantity" || renamed["request_id"] != "trace_id" {
+		t.Errorf("Renamed() - Expected the fields by their written names, got: %v", renamed)
+	}
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+)
+
+// BillingTimestampFormat is the format of the billing log timestamp
+const BillingTimestampFormat = "2006-01-02 15:04:05.000"
+
+// maxClockSkew is how far in the future a request log timestamp can be before it is rejected
+const maxClockSkew = 24 * time.Hour
+
+var (
+	// timestampLayouts are the accepted request log timestamp layouts
+	// layouts without a zone are interpreted as UTC
+	timestampLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.000Z0700",
+		"2006-01-02T15:04:05.000",
+		"2006-01-02T15:04:05",
+		"20060102150405",
+	}
+
+	// minTimestamp is the earliest request log timestamp which is accepted
+	minTimestamp = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// now is used to get the current time and can be replaced in tests
+	now = time.Now
+)
+
+// ParseTimestamp parses a request log timestamp and validates that it is in an acceptable range
+func ParseTimestamp(value string) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range timestampLayouts {
+		t, err = time.Parse(layout, value)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse timestamp %q from request log", value)
+	}
+
+	if t.Before(minTimestamp) {
+		return time.Time{}, fmt.Errorf("timestamp %q is out of range: before %v", value, minTimestamp.Format(time.RFC3339))
+	}
+	if t.After(now().Add(maxClockSkew)) {
+		return time.Time{}, fmt.Errorf("timestamp %q is out of range: more than %v in the future", value, maxClockSkew)
+	}
+	return t, nil
+}
+
+// BillingTimestamp returns the billing log timestamp of a request, which is the start
+// of the hour the request was made in, converted to the location of the output
+// A nil location is treated as UTC
+func BillingTimestamp(t time.Time, location *time.Location) string {
+	if location == nil {
+		location = time.UTC
+	}
+	t = t.In(location)
+	hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location)
+	return hour.Format(BillingTimestampFormat)
+}
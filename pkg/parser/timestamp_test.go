@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	now = func() time.Time { return time.Date(2023, time.June, 15, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	tests := []struct {
+		name      string
+		value     string
+		wantTime  time.Time
+		wantError bool
+	}{
+		{
+			name:     "ArtifactoryMilliseconds",
+			value:    "2023-06-15T12:34:56.789Z",
+			wantTime: time.Date(2023, time.June, 15, 12, 34, 56, 789000000, time.UTC),
+		},
+		{
+			name:     "RFC3339Offset",
+			value:    "2023-06-15T14:34:56+02:00",
+			wantTime: time.Date(2023, time.June, 15, 12, 34, 56, 0, time.UTC),
+		},
+		{
+			name:     "OffsetWithoutColon",
+			value:    "2023-06-15T14:34:56.789+0200",
+			wantTime: time.Date(2023, time.June, 15, 12, 34, 56, 789000000, time.UTC),
+		},
+		{
+			name:     "WithoutZone",
+			value:    "2023-06-15T12:34:56.789",
+			wantTime: time.Date(2023, time.June, 15, 12, 34, 56, 789000000, time.UTC),
+		},
+		{
+			name:     "Compact",
+			value:    "20230615123456",
+			wantTime: time.Date(2023, time.June, 15, 12, 34, 56, 0, time.UTC),
+		},
+		{
+			name:      "InvalidDate",
+			value:     "2023-02-30T12:34:56.789Z",
+			wantError: true,
+		},
+		{
+			name:      "Garbage",
+			value:     "yesterday",
+			wantError: true,
+		},
+		{
+			name:      "TooOld",
+			value:     "1970-01-01T00:00:00.000Z",
+			wantError: true,
+		},
+		{
+			name:      "TooFarInFuture",
+			value:     "2023-06-17T12:00:00.000Z",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTime, gotError := ParseTimestamp(tt.value)
+
+			if tt.wantError {
+				if gotError == nil {
+					t.Errorf("ParseTimestamp() error = %v, wantErr %v", gotError, tt.wantError)
+				}
+				return
+			}
+			if gotError != nil {
+				t.Fatalf("ParseTimestamp() unexpected error = %v", gotError)
+			}
+			if !gotTime.Equal(tt.wantTime) {
+				t.Errorf("ParseTimestamp() result = %v, want %v", gotTime, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestBillingTimestamp(t *testing.T) {
+	requestTime := time.Date(2023, time.June, 15, 23, 34, 56, 789000000, time.UTC)
+
+	tests := []struct {
+		name       string
+		location   *time.Location
+		wantResult string
+	}{
+		{
+			name:       "NilLocation",
+			location:   nil,
+			wantResult: "2023-06-15 23:00:00.000",
+		},
+		{
+			name:       "PositiveOffset",
+			location:   time.FixedZone("UTC+2", 2*60*60),
+			wantResult: "2023-06-16 01:00:00.000",
+		},
+		{
+			name:       "HalfHourOffset",
+			location:   time.FixedZone("UTC+5:30", 5*60*60+30*60),
+			wantResult: "2023-06-16 05:00:00.000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotResult := BillingTimestamp(requestTime, tt.location)
+			if gotResult != tt.wantResult {
+				t.Errorf("BillingTimestamp() result = %v, want %v", gotResult, tt.wantResult)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/checkpoint"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// StateFile is the name of the file in the state directory where the used free allowances are persisted
+const StateFile = "pricing.json"
+
+// bytesPerGB is the number of bytes the rates are charged for
+const bytesPerGB = 1000 * 1000 * 1000
+
+// DefaultCurrency is the currency of price tables which do not set one
+const DefaultCurrency = "USD"
+
+// Table describes the price table file
+type Table struct {
+	// Version identifies the price table and is recorded in the manifest of each output file
+	Version  string `json:"version"`
+	Currency string `json:"currency"`
+	// Timezone is the time zone of the time of day tiers, UTC when empty
+	Timezone string `json:"timezone"`
+	// DefaultRate is the price per GB of requests which match no rate
+	DefaultRate float64 `json:"default_rate"`
+	// PackageTypes maps a package type to repository key patterns, e.g. "docker": ["docker-*", "*-docker-*"]
+	PackageTypes map[string][]string `json:"package_types"`
+	// Rates are checked in order and the first matching rate is used
+	Rates []Rate `json:"rates"`
+	// Tiers multiply the rate of requests made in a time of day
+	Tiers []Tier `json:"tiers"`
+	// Allowances are free GB per month which are used up before requests are charged
+	Allowances []Allowance `json:"allowances"`
+}
+
+// Rate is the price per GB of the requests matching all of its non-empty fields
+type Rate struct {
+	// Repository is a repository key pattern, e.g. "*-remote"
+	Repository  string  `json:"repository"`
+	PackageType string  `json:"package_type"`
+	Project     string  `json:"project"`
+	Site        string  `json:"site"`
+	PerGB       float64 `json:"per_gb"`
+}
+
+// Tier multiplies the rate of requests made from FromHour up to ToHour
+// A tier where ToHour is not after FromHour spans midnight
+type Tier struct {
+	Name       string  `json:"name"`
+	FromHour   int     `json:"from_hour"`
+	ToHour     int     `json:"to_hour"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// Allowance is a free amount of GB per month for the requests of a project or user
+// An allowance without project and user applies to all requests
+type Allowance struct {
+	Project string  `json:"project"`
+	User    string  `json:"user"`
+	FreeGB  float64 `json:"free_gb"`
+}
+
+// Validate checks the price table
+func (t Table) Validate() error {
+	if t.Version == "" {
+		return fmt.Errorf("price table version must not be empty")
+	}
+	if t.DefaultRate < 0 {
+		return fmt.Errorf("default_rate must not be negative, got %v", t.DefaultRate)
+	}
+	for packageType, patterns := range t.PackageTypes {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid repository pattern %q of package type %q: %v", pattern, packageType, err)
+			}
+		}
+	}
+	for i, rate := range t.Rates {
+		if rate.PerGB < 0 {
+			return fmt.Errorf("per_gb of rate %d must not be negative, got %v", i, rate.PerGB)
+		}
+		if _, err := path.Match(rate.Repository, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q of rate %d: %v", rate.Repository, i, err)
+		}
+	}
+	for _, tier := range t.Tiers {
+		if tier.FromHour < 0 || tier.FromHour > 23 || tier.ToHour < 0 || tier.ToHour > 24 {
+			return fmt.Errorf("hours of tier %q must be between 0 and 24, got %d to %d", tier.Name, tier.FromHour, tier.ToHour)
+		}
+		if tier.Multiplier < 0 {
+			return fmt.Errorf("multiplier of tier %q must not be negative, got %v", tier.Name, tier.Multiplier)
+		}
+	}
+	for _, allowance := range t.Allowances {
+		if allowance.FreeGB < 0 {
+			return fmt.Errorf("free_gb of allowance %v must not be negative", allowance.key())
+		}
+	}
+	return nil
+}
+
+// Pricer calculates the cost of billing log entries out of a price table
+type Pricer struct {
+	Table    Table
+	location *time.Location
+	// packageTypes are the package types of the table in a fixed order, so overlapping patterns always give the same result
+	packageTypes []string
+
+	// state is shared with the Pricer of a reloaded price table
+	state *allowances
+}
+
+// allowances are the used free allowances of a Pricer
+type allowances struct {
+	mu sync.Mutex
+	// used contains the bytes of the free allowances which were used per allowance and month
+	used map[string]int64
+	// unsaved are the bytes used by the lines after the input offset of the last Save,
+	// which are only recorded after the allowances were loaded
+	unsaved   []use
+	persisted bool
+}
+
+// use is the part of a free allowance which was used by the line at an input offset
+type use struct {
+	offset int64
+	key    string
+	bytes  int64
+}
+
+// NewPricer validates the price table and creates a Pricer out of it
+func NewPricer(t Table) (*Pricer, error) {
+	if t.Currency == "" {
+		t.Currency = DefaultCurrency
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid price table timezone: %v", err)
+	}
+	p := &Pricer{
+		Table:    t,
+		location: location,
+		state:    &allowances{used: make(map[string]int64)},
+	}
+	for packageType := range t.PackageTypes {
+		p.packageTypes = append(p.packageTypes, packageType)
+	}
+	sort.Strings(p.packageTypes)
+	return p, nil
+}
+
+// LoadPricer reads a JSON price table file and creates a Pricer out of it
+func LoadPricer(path string) (*Pricer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price table: %v", err)
+	}
+
+	var t Table
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse price table: %v", err)
+	}
+	return NewPricer(t)
+}
+
+// Inherit shares the used free allowances of another Pricer, e.g. when the price table is reloaded
+// The allowances are shared and not copied, so the ones used by the workers of the previous Pricer until they stop are kept
+// It must be called before the Pricer is used
+func (p *Pricer) Inherit(previous *Pricer) {
+	p.state = previous.state
+}
+
+// Process sets the cost of a billing log entry and never drops it
+func (p *Pricer) Process(entry *parser.BillingLogs) bool {
+	charged := p.charged(entry)
+	rate := p.rate(entry) * p.multiplier(entry.RequestTime)
+	entry.Cost = math.Round(float64(charged)/bytesPerGB*rate*1e6) / 1e6
+	entry.Currency = p.Table.Currency
+	return true
+}
+
+// Metadata returns the price table version which is recorded in the manifest of each output file
+func (p *Pricer) Metadata() map[string]string {
+	return map[string]string{
+		"price_table_version": p.Table.Version,
+		"price_currency":      p.Table.Currency,
+	}
+}
+
+// PackageType returns the package type of a repository and an empty string if it is unknown
+func (p *Pricer) PackageType(repository string) string {
+	for _, packageType := range p.packageTypes {
+		for _, pattern := range p.Table.PackageTypes[packageType] {
+			if ok, _ := path.Match(pattern, repository); ok {
+				return packageType
+			}
+		}
+	}
+	return ""
+}
+
+// rate returns the price per GB of the first rate matching the entry
+func (p *Pricer) rate(entry *parser.BillingLogs) float64 {
+	packageType := p.PackageType(entry.Repository)
+	for _, rate := range p.Table.Rates {
+		if rate.Repository != "" {
+			if ok, _ := path.Match(rate.Repository, entry.Repository); !ok {
+				continue
+			}
+		}
+		if rate.PackageType != "" && rate.PackageType != packageType {
+			continue
+		}
+		if rate.Project != "" && rate.Project != entry.Project {
+			continue
+		}
+		if rate.Site != "" && rate.Site != entry.Site {
+			continue
+		}
+		return rate.PerGB
+	}
+	return p.Table.DefaultRate
+}
+
+// multiplier returns the multiplier of the first tier the request time is in, 1 if there is none
+func (p *Pricer) multiplier(t time.Time) float64 {
+	if t.IsZero() {
+		return 1
+	}
+	hour := t.In(p.location).Hour()
+	for _, tier := range p.Table.Tiers {
+		if tier.FromHour < tier.ToHour && hour >= tier.FromHour && hour < tier.ToHour {
+			return tier.Multiplier
+		}
+		if tier.FromHour >= tier.ToHour && (hour >= tier.FromHour || hour < tier.ToHour) {
+			return tier.Multiplier
+		}
+	}
+	return 1
+}
+
+// charged uses up the free allowances matching the entry and returns the bytes which are charged
+// The first matching allowance with free bytes left in the month of the request is used
+func (p *Pricer) charged(entry *parser.BillingLogs) int64 {
+	charged := entry.Quantity
+	if len(p.Table.Allowances) == 0 || charged <= 0 {
+		return charged
+	}
+
+	month := entry.RequestTime.In(p.location).Format("2006-01")
+
+	p.state.mu.Lock()
+	defer p.state.mu.Unlock()
+	for _, allowance := range p.Table.Allowances {
+		if !allowance.matches(entry) {
+			continue
+		}
+		key := month + " " + allowance.key()
+		free := int64(allowance.FreeGB*bytesPerGB) - p.state.used[key]
+		if free <= 0 {
+			continue
+		}
+		if free > charged {
+			free = charged
+		}
+		p.state.used[key] += free
+		if p.state.persisted {
+			p.state.unsaved = append(p.state.unsaved, use{offset: entry.Offset, key: key, bytes: free})
+		}
+		charged -= free
+		if charged == 0 {
+			break
+		}
+	}
+	return charged
+}
+
+// Save persists the free allowances used by the lines up to the input position which was written to a file
+// The lines after it are read again on the next start, so the allowances they used must not be used up then
+func (p *Pricer) Save(path string, written int64) error {
+	p.state.mu.Lock()
+	used := make(map[string]int64, len(p.state.used))
+	for key, bytes := range p.state.used {
+		used[key] = bytes
+	}
+	unsaved := p.state.unsaved[:0]
+	for _, u := range p.state.unsaved {
+		if u.offset > written {
+			used[u.key] -= u.bytes
+			unsaved = append(unsaved, u)
+		}
+	}
+	p.state.unsaved = unsaved
+	p.state.mu.Unlock()
+
+	data, err := json.Marshal(used)
+	if err != nil {
+		return fmt.Errorf("failed to encode pricing state: %v", err)
+	}
+	if err = checkpoint.WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to write pricing state: %v", err)
+	}
+	return nil
+}
+
+// Load restores the used free allowances from a file written by Save
+// A missing file is not an error
+// From then on the allowances used by each line are recorded until a Save covers the line
+func (p *Pricer) Load(path string) error {
+	p.state.mu.Lock()
+	p.state.persisted = true
+	p.state.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read pricing state: %v", err)
+	}
+
+	used := make(map[string]int64)
+	if err = json.Unmarshal(data, &used); err != nil {
+		return fmt.Errorf("failed to parse pricing state: %v", err)
+	}
+	p.state.mu.Lock()
+	p.state.used = used
+	p.state.mu.Unlock()
+	return nil
+}
+
+// matches returns whether the allowance applies to an entry
+func (a Allowance) matches(entry *parser.BillingLogs) bool {
+	return (a.Project == "" || a.Project == entry.Project) && (a.User == "" || a.User == entry.User)
+}
+
+// key identifies the allowance in the persisted state
+func (a Allowance) key() string {
+	return "project=" + a.Project + ",user=" + a.User
+}
@@ -0,0 +1,208 @@
+package pricing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// gb is the number of bytes in a GB
+const gb = bytesPerGB
+
+func TestNewPricer_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		table Table
+	}{
+		{"MissingVersion", Table{}},
+		{"NegativeRate", Table{Version: "1", Rates: []Rate{{PerGB: -1}}}},
+		{"InvalidPattern", Table{Version: "1", PackageTypes: map[string][]string{"docker": {"["}}}},
+		{"InvalidTier", Table{Version: "1", Tiers: []Tier{{Name: "night", FromHour: 22, ToHour: 25}}}},
+		{"InvalidTimezone", Table{Version: "1", Timezone: "Mars/Olympus"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPricer(tt.table); err == nil {
+				t.Error("NewPricer() - Expected error")
+			}
+		})
+	}
+}
+
+func TestPricer_Process(t *testing.T) {
+	pricer, err := NewPricer(Table{
+		Version:      "2024-03",
+		DefaultRate:  0.1,
+		PackageTypes: map[string][]string{"docker": {"docker-*", "*-docker-*"}},
+		Rates: []Rate{
+			{Project: "ml", Site: "office", PerGB: 0},
+			{PackageType: "docker", PerGB: 0.05},
+			{Repository: "*-remote", PerGB: 0.2},
+		},
+		Tiers: []Tier{{Name: "night", FromHour: 22, ToHour: 6, Multiplier: 0.5}},
+	})
+	if err != nil {
+		t.Fatal("NewPricer() returned an error:", err)
+	}
+
+	day := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 3, 4, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		entry parser.BillingLogs
+		want  float64
+	}{
+		{"DefaultRate", parser.BillingLogs{Repository: "libs-release", Quantity: 2 * gb, RequestTime: day}, 0.2},
+		{"PackageType", parser.BillingLogs{Repository: "team-docker-local", Quantity: 2 * gb, RequestTime: day}, 0.1},
+		{"RepositoryPattern", parser.BillingLogs{Repository: "npm-remote", Quantity: gb / 2, RequestTime: day}, 0.1},
+		{"ProjectAndSite", parser.BillingLogs{Repository: "npm-remote", Project: "ml", Site: "office", Quantity: gb, RequestTime: day}, 0},
+		{"NightTier", parser.BillingLogs{Repository: "libs-release", Quantity: 2 * gb, RequestTime: night}, 0.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := tt.entry
+			if !pricer.Process(&entry) {
+				t.Fatal("Process() - Expected entry to be kept")
+			}
+			if entry.Cost != tt.want {
+				t.Errorf("Process() - Expected cost: %v, got: %v", tt.want, entry.Cost)
+			}
+			if entry.Currency != DefaultCurrency {
+				t.Errorf("Process() - Expected currency: %v, got: %v", DefaultCurrency, entry.Currency)
+			}
+		})
+	}
+
+	if version := pricer.Metadata()["price_table_version"]; version != "2024-03" {
+		t.Errorf("Metadata() - Expected price table version: 2024-03, got: %v", version)
+	}
+}
+
+func TestPricer_Allowances(t *testing.T) {
+	table := Table{
+		Version:     "1",
+		DefaultRate: 1,
+		Allowances:  []Allowance{{Project: "web", FreeGB: 1}},
+	}
+	pricer, err := NewPricer(table)
+	if err != nil {
+		t.Fatal("NewPricer() returned an error:", err)
+	}
+
+	march := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		entry parser.BillingLogs
+		want  float64
+	}{
+		{"Free", parser.BillingLogs{Project: "web", Quantity: gb / 2, RequestTime: march}, 0},
+		{"OtherProject", parser.BillingLogs{Project: "ml", Quantity: gb / 2, RequestTime: march}, 0.5},
+		{"PartlyFree", parser.BillingLogs{Project: "web", Quantity: gb, RequestTime: march}, 0.5},
+		{"UsedUp", parser.BillingLogs{Project: "web", Quantity: gb, RequestTime: march}, 1},
+		{"NextMonth", parser.BillingLogs{Project: "web", Quantity: gb, RequestTime: april}, 0},
+	}
+
+	for _, tt := range tests {
+		entry := tt.entry
+		pricer.Process(&entry)
+		if entry.Cost != tt.want {
+			t.Errorf("Process() %v - Expected cost: %v, got: %v", tt.name, tt.want, entry.Cost)
+		}
+	}
+
+	// The used allowances survive a restart
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, StateFile)
+	if err = pricer.Save(path, 0); err != nil {
+		t.Fatal("Save() returned an error:", err)
+	}
+
+	restored, _ := NewPricer(table)
+	if err = restored.Load(path); err != nil {
+		t.Fatal("Load() returned an error:", err)
+	}
+	entry := parser.BillingLogs{Project: "web", Quantity: gb / 2, RequestTime: march}
+	restored.Process(&entry)
+	if entry.Cost != 0.5 {
+		t.Errorf("Process() - Expected cost after restoring the allowances: 0.5, got: %v", entry.Cost)
+	}
+
+	// The used allowances are kept when the price table is reloaded
+	reloaded, _ := NewPricer(table)
+	reloaded.Inherit(restored)
+	entry = parser.BillingLogs{Project: "web", Quantity: gb / 2, RequestTime: april}
+	reloaded.Process(&entry)
+	if entry.Cost != 0.5 {
+		t.Errorf("Process() - Expected cost after inheriting the allowances: 0.5, got: %v", entry.Cost)
+	}
+
+	// The allowances used by the previous Pricer after the reload are shared
+	entry = parser.BillingLogs{Project: "web", Quantity: gb, RequestTime: time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)}
+	restored.Process(&entry)
+	entry = parser.BillingLogs{Project: "web", Quantity: gb / 2, RequestTime: time.Date(2024, time.May, 2, 0, 0, 0, 0, time.UTC)}
+	reloaded.Process(&entry)
+	if entry.Cost != 0.5 {
+		t.Errorf("Process() - Expected cost after the previous Pricer used the allowance: 0.5, got: %v", entry.Cost)
+	}
+}
+
+func TestPricer_SaveWritten(t *testing.T) {
+	table := Table{
+		Version:     "1",
+		DefaultRate: 1,
+		Allowances:  []Allowance{{Project: "web", FreeGB: 1}},
+	}
+	pricer, err := NewPricer(table)
+	if err != nil {
+		t.Fatal("NewPricer() returned an error:", err)
+	}
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, StateFile)
+	if err = pricer.Load(path); err != nil {
+		t.Fatal("Load() returned an error:", err)
+	}
+
+	march := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	for _, offset := range []int64{10, 20} {
+		pricer.Process(&parser.BillingLogs{Project: "web", Quantity: gb / 2, RequestTime: march, Offset: offset})
+	}
+
+	tests := []struct {
+		written int64
+		// want is the cost of a GB after a restart
+		want float64
+	}{
+		// the line at offset 20 is read again after a restart, so the half of the allowance it used is still free
+		{10, 0.5},
+		{20, 1},
+	}
+
+	for _, tt := range tests {
+		if err = pricer.Save(path, tt.written); err != nil {
+			t.Fatal("Save() returned an error:", err)
+		}
+		restored, _ := NewPricer(table)
+		if err = restored.Load(path); err != nil {
+			t.Fatal("Load() returned an error:", err)
+		}
+		entry := parser.BillingLogs{Project: "web", Quantity: gb, RequestTime: march}
+		restored.Process(&entry)
+		if entry.Cost != tt.want {
+			t.Errorf("Process() - Expected cost after saving up to offset %d: %v, got: %v", tt.written, tt.want, entry.Cost)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/watch"
+)
+
+// Policy describes how a field containing personal data is written to the output
+type Policy string
+
+const (
+	// Keep writes the field as it is
+	Keep Policy = "keep"
+	// Drop removes the value of the field
+	Drop Policy = "drop"
+	// Truncate keeps only the network part of an IP address, /24 for IPv4 and /48 for IPv6
+	Truncate Policy = "truncate"
+	// Pseudonymize replaces the value of the field with a keyed HMAC of it
+	Pseudonymize Policy = "hmac"
+)
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+)
+
+// Config contains the privacy policies of the personal data fields
+// Position returns the input position of the last line which was read, the lines after it are pseudonymized
+// with a secret which is loaded then
+type Config struct {
+	IP         Policy
+	User       Policy
+	SecretPath string
+	Position   func() int64
+}
+
+// Privacy applies the privacy policies to the personal data fields of billing log entries
+// Each entry is pseudonymized with the secret which was loaded when its line was read, so a rotated secret
+// applies to the lines read after it and the entries of an output file can be kept on the same secret
+type Privacy struct {
+	IP         Policy
+	User       Policy
+	SecretPath string
+	Position   func() int64
+	Logger     *slog.Logger
+
+	mu      sync.RWMutex
+	secrets []secret
+}
+
+// secret is a secret used for pseudonymization and the input position after which the lines are pseudonymized with it
+type secret struct {
+	after int64
+	key   []byte
+}
+
+// NewPrivacy validates the policies and creates and returns a Privacy object
+// The secret is loaded when one of the policies pseudonymizes a field
+func NewPrivacy(c Config, logger *slog.Logger) (*Privacy, error) {
+	if c.IP == "" {
+		c.IP = Keep
+	}
+	if c.User == "" {
+		c.User = Keep
+	}
+
+	switch c.IP {
+	case Keep, Drop, Truncate, Pseudonymize:
+	default:
+		return nil, fmt.Errorf("unknown ip policy %q", c.IP)
+	}
+	switch c.User {
+	case Keep, Drop, Pseudonymize:
+	default:
+		return nil, fmt.Errorf("unknown user policy %q", c.User)
+	}
+
+	privacy := &Privacy{
+		IP:         c.IP,
+		User:       c.User,
+		SecretPath: c.SecretPath,
+		Position:   c.Position,
+		Logger:     logger,
+	}
+
+	if privacy.pseudonymizes() {
+		if privacy.SecretPath == "" {
+			return nil, fmt.Errorf("a secret file is required for the %q policy", Pseudonymize)
+		}
+		if err := privacy.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return privacy, nil
+}
+
+// Reload reads the secret file again and pseudonymizes the lines read from now on with the secret
+// The previous secrets are kept for the lines which were read before
+func (p *Privacy) Reload() error {
+	data, err := os.ReadFile(p.SecretPath)
+	if err != nil {
+		return fmt.Errorf("failed to read secret file: %v", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return fmt.Errorf("secret file %v is empty", p.SecretPath)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.secrets); n > 0 && bytes.Equal(p.secrets[n-1].key, key) {
+		return nil
+	}
+	p.secrets = append(p.secrets, secret{after: p.position(), key: key})
+	return nil
+}
+
+// Inherit keeps the secrets of the privacy of the previous config,
+// so the lines which were read before a config reload are pseudonymized with the same secret as before
+func (p *Privacy) Inherit(previous *Privacy) {
+	previous.mu.RLock()
+	secrets := append([]secret(nil), previous.secrets...)
+	previous.mu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.secrets) == 0 || len(secrets) == 0 {
+		return
+	}
+	current := p.secrets[len(p.secrets)-1]
+	if !bytes.Equal(secrets[len(secrets)-1].key, current.key) {
+		current.after = p.position()
+		secrets = append(secrets, current)
+	}
+	p.secrets = secrets
+}
+
+// Watch checks the secret file for changes every interval and reloads it when it was rotated
+// It stops when the context is done
+func (p *Privacy) Watch(ctx context.Context, interval time.Duration) {
+	if !p.pseudonymizes() {
+		return
+	}
+	watch.File(ctx, p.SecretPath, interval, p.Reload, p.Logger)
+}
+
+// Process applies the privacy policies to a billing log entry
+func (p *Privacy) Process(entry *parser.BillingLogs) bool {
+	entry.RemoteIP = p.apply(p.IP, entry.RemoteIP, entry.Offset)
+	entry.User = p.apply(p.User, entry.User, entry.Offset)
+	return true
+}
+
+// Metadata returns the privacy policies and the ID of the secret of the line at an input offset
+// for recording them in the file manifests
+func (p *Privacy) Metadata(offset int64) map[string]string {
+	metadata := map[string]string{
+		"privacy_ip":        string(p.IP),
+		"privacy_user_name": string(p.User),
+	}
+	if p.pseudonymizes() {
+		metadata["privacy_secret_id"] = p.secretID(offset)
+	}
+	return metadata
+}
+
+// Changed returns whether the lines at two input offsets are pseudonymized with different secrets
+func (p *Privacy) Changed(from, to int64) bool {
+	if !p.pseudonymizes() {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.secretAt(from) != p.secretAt(to)
+}
+
+// apply returns the value of a field of the line at an input offset after applying a policy to it
+func (p *Privacy) apply(policy Policy, value string, offset int64) string {
+	switch policy {
+	case Drop:
+		return ""
+	case Truncate:
+		return truncateIP(value)
+	case Pseudonymize:
+		return p.pseudonymize(value, offset)
+	default:
+		return value
+	}
+}
+
+// position returns the input position of the last line which was read, -1 when it is unknown
+// so the secret applies to all lines
+func (p *Privacy) position() int64 {
+	if p.Position == nil || len(p.secrets) == 0 {
+		return -1
+	}
+	return p.Position()
+}
+
+// secretAt returns the index of the secret of the line at an input offset, the caller must hold the lock
+func (p *Privacy) secretAt(offset int64) int {
+	for i := len(p.secrets) - 1; i > 0; i-- {
+		if offset > p.secrets[i].after {
+			return i
+		}
+	}
+	return 0
+}
+
+// pseudonymizes returns true if one of the policies requires a secret
+func (p *Privacy) pseudonymizes() bool {
+	return p.IP == Pseudonymize || p.User == Pseudonymize
+}
+
+// pseudonymize returns the hex encoded HMAC-SHA256 of a value of the line at an input offset truncated to 128 bits
+func (p *Privacy) pseudonymize(value string, offset int64) string {
+	p.mu.RLock()
+	mac := hmac.New(sha256.New, p.secrets[p.secretAt(offset)].key)
+	p.mu.RUnlock()
+
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// secretID returns a short identifier of the secret of the line at an input offset which does not reveal it
+func (p *Privacy) secretID(offset int64) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	sum := sha256.Sum256(p.secrets[p.secretAt(offset)].key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// truncateIP returns the network address of an IP, /24 for IPv4 and /48 for IPv6
+// Values which are not IP addresses are dropped
+func truncateIP(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(ipv4Mask).String()
+	}
+	return ip.Mask(ipv6Mask).String()
+}
@@ -0,0 +1,284 @@
+package privacy
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func writeSecret(t *testing.T, dir string, secret string) string {
+	path := filepath.Join(dir, "secret")
+	if err := os.WriteFile(path, []byte(secret), 0600); err != nil {
+		t.Fatal("Failed to write secret file:", err)
+	}
+	return path
+}
+
+func TestNewPrivacy(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := writeSecret(t, dir, "secret\n")
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	tests := []struct {
+		name      string
+		config    Config
+		wantError bool
+	}{
+		{
+			name:   "DefaultPolicies",
+			config: Config{},
+		},
+		{
+			name:   "TruncateAndDrop",
+			config: Config{IP: Truncate, User: Drop},
+		},
+		{
+			name:   "PseudonymizeWithSecret",
+			config: Config{IP: Pseudonymize, User: Pseudonymize, SecretPath: secretPath},
+		},
+		{
+			name:      "PseudonymizeWithoutSecret",
+			config:    Config{User: Pseudonymize},
+			wantError: true,
+		},
+		{
+			name:      "PseudonymizeMissingSecret",
+			config:    Config{User: Pseudonymize, SecretPath: filepath.Join(dir, "missing")},
+			wantError: true,
+		},
+		{
+			name:      "TruncateUser",
+			config:    Config{User: Truncate},
+			wantError: true,
+		},
+		{
+			name:      "UnknownPolicy",
+			config:    Config{IP: "mask"},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, gotError := NewPrivacy(tt.config, logger)
+			if (gotError != nil) != tt.wantError {
+				t.Errorf("NewPrivacy() error = %v, wantErr %v", gotError, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestPrivacy_Process(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := writeSecret(t, dir, "secret")
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	tests := []struct {
+		name     string
+		config   Config
+		ip       string
+		user     string
+		wantIP   string
+		wantUser string
+	}{
+		{
+			name:     "Keep",
+			config:   Config{},
+			ip:       "1.2.3.4",
+			user:     "user",
+			wantIP:   "1.2.3.4",
+			wantUser: "user",
+		},
+		{
+			name:     "Drop",
+			config:   Config{IP: Drop, User: Drop},
+			ip:       "1.2.3.4",
+			user:     "user",
+			wantIP:   "",
+			wantUser: "",
+		},
+		{
+			name:     "TruncateIPv4",
+			config:   Config{IP: Truncate},
+			ip:       "1.2.3.4",
+			user:     "user",
+			wantIP:   "1.2.3.0",
+			wantUser: "user",
+		},
+		{
+			name:     "TruncateIPv6",
+			config:   Config{IP: Truncate},
+			ip:       "2001:db8:1234:5678::1",
+			user:     "user",
+			wantIP:   "2001:db8:1234::",
+			wantUser: "user",
+		},
+		{
+			name:     "TruncateInvalidIP",
+			config:   Config{IP: Truncate},
+			ip:       "unknown",
+			user:     "user",
+			wantIP:   "",
+			wantUser: "user",
+		},
+		{
+			name:     "Pseudonymize",
+			config:   Config{User: Pseudonymize, SecretPath: secretPath},
+			ip:       "1.2.3.4",
+			user:     "user",
+			wantIP:   "1.2.3.4",
+			wantUser: "9b46c34925f5b319786d5cede4dc72ed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privacy, err := NewPrivacy(tt.config, logger)
+			if err != nil {
+				t.Fatal("NewPrivacy() returned an error:", err)
+			}
+
+			entry := &parser.BillingLogs{RemoteIP: tt.ip, User: tt.user}
+			if !privacy.Process(entry) {
+				t.Error("Privacy.Process() - Expected entry to be kept")
+			}
+			if entry.RemoteIP != tt.wantIP {
+				t.Errorf("Privacy.Process() ip = %v, want %v", entry.RemoteIP, tt.wantIP)
+			}
+			if entry.User != tt.wantUser {
+				t.Errorf("Privacy.Process() user = %v, want %v", entry.User, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestPrivacy_Reload(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := writeSecret(t, dir, "secret")
+	privacy, err := NewPrivacy(Config{User: Pseudonymize, SecretPath: secretPath}, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err != nil {
+		t.Fatal("NewPrivacy() returned an error:", err)
+	}
+
+	before := privacy.pseudonymize("user", 0)
+	beforeID := privacy.Metadata(0)["privacy_secret_id"]
+
+	// Rotate the secret
+	writeSecret(t, dir, "rotated")
+	if err = privacy.Reload(); err != nil {
+		t.Fatal("Privacy.Reload() returned an error:", err)
+	}
+
+	if after := privacy.pseudonymize("user", 0); after == before {
+		t.Error("Privacy.Reload() - Expected a different pseudonym after rotating the secret")
+	}
+	if afterID := privacy.Metadata(0)["privacy_secret_id"]; afterID == beforeID {
+		t.Error("Privacy.Reload() - Expected a different secret id after rotating the secret")
+	}
+
+	// An empty secret should be rejected
+	writeSecret(t, dir, "\n")
+	if err = privacy.Reload(); err == nil {
+		t.Error("Privacy.Reload() - Expected an error for an empty secret")
+	}
+}
+
+func TestPrivacy_ReloadPosition(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	position := int64(100)
+	secretPath := writeSecret(t, dir, "secret")
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	privacy, err := NewPrivacy(Config{User: Pseudonymize, SecretPath: secretPath, Position: func() int64 { return position }}, logger)
+	if err != nil {
+		t.Fatal("NewPrivacy() returned an error:", err)
+	}
+	before := privacy.pseudonymize("user", 50)
+
+	// The rotated secret only applies to the lines read after it was loaded
+	writeSecret(t, dir, "rotated")
+	if err = privacy.Reload(); err != nil {
+		t.Fatal("Privacy.Reload() returned an error:", err)
+	}
+	if got := privacy.pseudonymize("user", 100); got != before {
+		t.Error("Privacy.Reload() - Expected the lines read before the rotation to keep the previous secret")
+	}
+	if got := privacy.pseudonymize("user", 150); got == before {
+		t.Error("Privacy.Reload() - Expected the lines read after the rotation to use the rotated secret")
+	}
+	if !privacy.Changed(50, 150) || privacy.Changed(150, 200) || privacy.Changed(10, 100) {
+		t.Error("Privacy.Changed() - Expected a change only between the lines read before and after the rotation")
+	}
+	if privacy.Metadata(50)["privacy_secret_id"] == privacy.Metadata(150)["privacy_secret_id"] {
+		t.Error("Privacy.Metadata() - Expected the secret id of the line")
+	}
+
+	// Loading the same secret again does not change it
+	position = 200
+	if err = privacy.Reload(); err != nil {
+		t.Fatal("Privacy.Reload() returned an error:", err)
+	}
+	if privacy.Changed(150, 250) {
+		t.Error("Privacy.Reload() - Expected the same secret to be kept")
+	}
+
+	// The privacy of a reloaded config keeps the secrets of the lines which were read before
+	reloaded, err := NewPrivacy(Config{User: Pseudonymize, SecretPath: secretPath, Position: func() int64 { return position }}, logger)
+	if err != nil {
+		t.Fatal("NewPrivacy() returned an error:", err)
+	}
+	reloaded.Inherit(privacy)
+	if got := reloaded.pseudonymize("user", 50); got != before {
+		t.Error("Privacy.Inherit() - Expected the previous secrets to be kept")
+	}
+	if !reloaded.Changed(50, 250) {
+		t.Error("Privacy.Inherit() - Expected the rotated secret to be kept")
+	}
+
+	// A secret which was rotated with the config reload only applies to the lines read after it
+	writeSecret(t, dir, "third")
+	rotated := privacy.pseudonymize("user", 250)
+	reloaded, err = NewPrivacy(Config{User: Pseudonymize, SecretPath: secretPath, Position: func() int64 { return position }}, logger)
+	if err != nil {
+		t.Fatal("NewPrivacy() returned an error:", err)
+	}
+	reloaded.Inherit(privacy)
+	if got := reloaded.pseudonymize("user", 200); got != rotated {
+		t.Error("Privacy.Inherit() - Expected the lines read before the reload to keep their secret")
+	}
+	if !reloaded.Changed(200, 250) {
+		t.Error("Privacy.Inherit() - Expected the secret of the reloaded config for the lines read after the reload")
+	}
+}
@@ -0,0 +1,95 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// Unassigned is the project of billing log entries for repositories which are not mapped to a project
+const Unassigned = "unassigned"
+
+// Mapping describes the repository to project mapping file
+type Mapping struct {
+	// Repositories maps a repository key to a project key
+	Repositories map[string]string `json:"repositories"`
+	// Projects contains project keys which are used as repository key prefixes,
+	// e.g. repository "proj-docker-remote" belongs to project "proj"
+	Projects []string `json:"projects"`
+}
+
+// Resolver resolves the project of a repository
+type Resolver struct {
+	repositories map[string]string
+	prefixes     []string
+}
+
+// NewResolver creates and returns a Resolver out of a mapping
+func NewResolver(m Mapping) (*Resolver, error) {
+	r := &Resolver{
+		repositories: make(map[string]string, len(m.Repositories)),
+	}
+
+	for repo, project := range m.Repositories {
+		if repo == "" || project == "" {
+			return nil, fmt.Errorf("repository and project keys must not be empty: %q : %q", repo, project)
+		}
+		r.repositories[repo] = project
+	}
+
+	for _, project := range m.Projects {
+		if project == "" {
+			return nil, fmt.Errorf("project keys must not be empty")
+		}
+		r.prefixes = append(r.prefixes, project)
+	}
+	// check the longest prefixes first so the most specific project wins
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i]) > len(r.prefixes[j])
+	})
+
+	return r, nil
+}
+
+// LoadResolver reads a JSON mapping file and creates a Resolver out of it
+func LoadResolver(path string) (*Resolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project mapping: %v", err)
+	}
+
+	var m Mapping
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse project mapping: %v", err)
+	}
+	return NewResolver(m)
+}
+
+// Resolve returns the project of a repository and false if the repository is not mapped
+func (r *Resolver) Resolve(repository string) (string, bool) {
+	if project, ok := r.repositories[repository]; ok {
+		return project, true
+	}
+	for _, project := range r.prefixes {
+		if strings.HasPrefix(repository, project+"-") {
+			return project, true
+		}
+	}
+	return Unassigned, false
+}
+
+// Process sets the project of a billing log entry
+// Entries of unmapped repositories are assigned to the Unassigned project and counted
+func (r *Resolver) Process(entry *parser.BillingLogs) bool {
+	project, ok := r.Resolve(entry.Repository)
+	if !ok {
+		metrics.UnmappedRepositories.Add(entry.Repository, 1)
+	}
+	entry.Project = project
+	return true
+}
@@ -0,0 +1,126 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver, err := NewResolver(Mapping{
+		Repositories: map[string]string{
+			"registry-docker-remote": "platform",
+		},
+		Projects: []string{"ml", "ml-gpu"},
+	})
+	if err != nil {
+		t.Fatal("NewResolver() returned an error:", err)
+	}
+
+	tests := []struct {
+		name        string
+		repository  string
+		wantProject string
+		wantMapped  bool
+	}{
+		{
+			name:        "MappedRepository",
+			repository:  "registry-docker-remote",
+			wantProject: "platform",
+			wantMapped:  true,
+		},
+		{
+			name:        "ProjectPrefix",
+			repository:  "ml-pypi-remote",
+			wantProject: "ml",
+			wantMapped:  true,
+		},
+		{
+			name:        "LongestProjectPrefix",
+			repository:  "ml-gpu-pypi-remote",
+			wantProject: "ml-gpu",
+			wantMapped:  true,
+		},
+		{
+			name:        "UnmappedRepository",
+			repository:  "npm-remote",
+			wantProject: Unassigned,
+			wantMapped:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotProject, gotMapped := resolver.Resolve(tt.repository)
+			if gotProject != tt.wantProject || gotMapped != tt.wantMapped {
+				t.Errorf("Resolver.Resolve() = %v, %v, want %v, %v", gotProject, gotMapped, tt.wantProject, tt.wantMapped)
+			}
+		})
+	}
+}
+
+func TestResolver_Process(t *testing.T) {
+	resolver, err := NewResolver(Mapping{
+		Repositories: map[string]string{
+			"registry-docker-remote": "platform",
+		},
+	})
+	if err != nil {
+		t.Fatal("NewResolver() returned an error:", err)
+	}
+
+	// Process a mapped repository
+	entry := &parser.BillingLogs{Repository: "registry-docker-remote", Project: parser.DefaultProject}
+	if !resolver.Process(entry) {
+		t.Error("Resolver.Process() - Expected entry to be kept")
+	}
+	if entry.Project != "platform" {
+		t.Errorf("Resolver.Process() - Expected project: %s, got: %s", "platform", entry.Project)
+	}
+
+	// Process an unmapped repository and check that it is counted
+	entry = &parser.BillingLogs{Repository: "unmapped-test-remote", Project: parser.DefaultProject}
+	if !resolver.Process(entry) {
+		t.Error("Resolver.Process() - Expected entry to be kept")
+	}
+	if entry.Project != Unassigned {
+		t.Errorf("Resolver.Process() - Expected project: %s, got: %s", Unassigned, entry.Project)
+	}
+	if count := metrics.UnmappedRepositories.Get("unmapped-test-remote"); count == nil || count.String() != "1" {
+		t.Errorf("Resolver.Process() - Expected unmapped repository count: 1, got: %v", count)
+	}
+}
+
+func TestLoadResolver(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "projects.json")
+	content := `{"repositories": {"registry-docker-remote": "platform"}, "projects": ["ml"]}`
+	if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("Failed to write mapping file:", err)
+	}
+
+	resolver, err := LoadResolver(path)
+	if err != nil {
+		t.Fatal("LoadResolver() returned an error:", err)
+	}
+	if project, _ := resolver.Resolve("ml-npm-remote"); project != "ml" {
+		t.Errorf("LoadResolver() - Expected project: %s, got: %s", "ml", project)
+	}
+
+	// Check that an invalid mapping file returns an error
+	if err = os.WriteFile(path, []byte(`{"repositories": {"registry-docker-remote": ""}}`), 0644); err != nil {
+		t.Fatal("Failed to write mapping file:", err)
+	}
+	if _, err = LoadResolver(path); err == nil {
+		t.Error("LoadResolver() - Expected an error for an empty project key")
+	}
+}
@@ -0,0 +1,422 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/checkpoint"
+	"github.com/svetlyopet/logcat/pkg/hook"
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// StateFile is the name of the file in the state directory where the monthly totals are persisted
+const StateFile = "quota.json"
+
+// bytesPerGB is the number of bytes the limits are set in
+const bytesPerGB = 1000 * 1000 * 1000
+
+// Each is the quota value which applies to every user, project or repository separately
+const Each = "*"
+
+const (
+	// defaultHookTimeout is how long an alert hook can run when the config does not set it
+	defaultHookTimeout = 10 * time.Second
+	// alertQueueSize is the number of alerts which can wait for delivery
+	alertQueueSize = 100
+)
+
+// defaultThresholds are the percentages of a quota at which alerts fire when the config does not set them
+var defaultThresholds = []float64{80, 100}
+
+// Config describes the quota file
+type Config struct {
+	Quotas []Quota `json:"quotas"`
+	// Thresholds are the percentages of a quota at which an alert fires
+	Thresholds []float64 `json:"thresholds"`
+	// Timezone is the time zone the months start in, UTC when empty
+	Timezone string `json:"timezone"`
+	// Webhook is a URL the alerts are posted to
+	Webhook string `json:"webhook"`
+	// Exec is a command which is run for each alert
+	Exec []string `json:"exec"`
+	// HookTimeout is how long a webhook or command can take, e.g. "10s"
+	HookTimeout string `json:"hook_timeout"`
+}
+
+// Quota is a monthly download budget of a user, project or repository
+// Exactly one of them is set, the value "*" sets the budget of each of them which has no quota of its own
+type Quota struct {
+	User       string  `json:"user"`
+	Project    string  `json:"project"`
+	Repository string  `json:"repository"`
+	LimitGB    float64 `json:"limit_gb"`
+}
+
+// Alert is sent when the usage of a quota crosses a threshold
+type Alert struct {
+	Kind      string  `json:"kind"`
+	Name      string  `json:"name"`
+	Month     string  `json:"month"`
+	Threshold float64 `json:"threshold"`
+	Used      int64   `json:"used_bytes"`
+	Limit     int64   `json:"limit_bytes"`
+	Percent   float64 `json:"percent"`
+}
+
+// usage is the total of a quota in a month and the highest threshold an alert fired for
+type usage struct {
+	Bytes int64   `json:"bytes"`
+	Fired float64 `json:"fired"`
+}
+
+// Tracker maintains the monthly totals of the quotas out of the billing log entries and alerts when they cross thresholds
+type Tracker struct {
+	Config Config
+	Logger *slog.Logger
+
+	location *time.Location
+	timeout  time.Duration
+	hooks    []hook.Hook
+	alerts   chan Alert
+	// specific contains the kind and name of the quotas which are not set for each value
+	specific map[string]bool
+
+	// state is shared with the Tracker of a reloaded quota file
+	state *totals
+}
+
+// totals are the running totals of a Tracker
+type totals struct {
+	mu sync.Mutex
+	// usage contains the totals per month, kind and name of a quota
+	usage map[string]*usage
+	// month is the newest month seen, older totals are forgotten when a new one starts
+	month string
+	// unsaved are the bytes added by the lines after the input offset of the last Save,
+	// which are only recorded after the totals were loaded
+	unsaved   []addition
+	persisted bool
+}
+
+// addition is the quantity the line at an input offset added to the total of a quota
+type addition struct {
+	offset int64
+	key    string
+	bytes  int64
+}
+
+// NewTracker validates the config and creates a Tracker out of it
+func NewTracker(c Config, logger *slog.Logger) (*Tracker, error) {
+	if len(c.Thresholds) == 0 {
+		c.Thresholds = defaultThresholds
+	}
+	c.Thresholds = append([]float64(nil), c.Thresholds...)
+	sort.Float64s(c.Thresholds)
+	if c.Thresholds[0] <= 0 {
+		return nil, fmt.Errorf("quota thresholds must be greater than 0, got %v", c.Thresholds[0])
+	}
+	t := &Tracker{
+		Config:   c,
+		Logger:   logger,
+		timeout:  defaultHookTimeout,
+		alerts:   make(chan Alert, alertQueueSize),
+		specific: make(map[string]bool),
+		state:    &totals{usage: make(map[string]*usage)},
+	}
+	for _, q := range c.Quotas {
+		kind, name, err := q.kind()
+		if err != nil {
+			return nil, err
+		}
+		if q.LimitGB <= 0 {
+			return nil, fmt.Errorf("limit_gb of quota %+v must be greater than 0, got %v", q, q.LimitGB)
+		}
+		if t.specific[kind+"="+name] {
+			return nil, fmt.Errorf("quota of %s %s is set more than once", kind, name)
+		}
+		t.specific[kind+"="+name] = true
+	}
+	var err error
+	if t.location, err = time.LoadLocation(c.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid quota timezone: %v", err)
+	}
+	if c.HookTimeout != "" {
+		if t.timeout, err = time.ParseDuration(c.HookTimeout); err != nil || t.timeout <= 0 {
+			return nil, fmt.Errorf("invalid quota hook_timeout %q", c.HookTimeout)
+		}
+	}
+	if c.Webhook != "" {
+		t.hooks = append(t.hooks, hook.NewWebhook(hook.Webhook{URL: c.Webhook}))
+	}
+	if len(c.Exec) > 0 {
+		e, err := hook.NewExec(hook.Exec{Command: c.Exec})
+		if err != nil {
+			return nil, err
+		}
+		t.hooks = append(t.hooks, e)
+	}
+	return t, nil
+}
+
+// LoadTracker reads a JSON quota file and creates a Tracker out of it
+func LoadTracker(path string, logger *slog.Logger) (*Tracker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota file: %v", err)
+	}
+
+	var c Config
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse quota file: %v", err)
+	}
+	return NewTracker(c, logger)
+}
+
+// Start delivers the alerts in the background until the context is done
+func (t *Tracker) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case alert := <-t.alerts:
+				t.deliver(ctx, alert)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Inherit shares the totals of another Tracker, e.g. when the quota file is reloaded
+// The totals are shared and not copied, so the bytes added by the workers of the previous Tracker until they stop are kept
+// Alerts which already fired for a threshold do not fire again
+// It must be called before the Tracker is used
+func (t *Tracker) Inherit(previous *Tracker) {
+	t.state = previous.state
+}
+
+// Process adds a billing log entry to the totals of the quotas it belongs to and never drops it
+func (t *Tracker) Process(entry *parser.BillingLogs) bool {
+	if entry.Quantity <= 0 || len(t.Config.Quotas) == 0 {
+		return true
+	}
+	month := entry.RequestTime.In(t.location).Format("2006-01")
+
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if month > t.state.month {
+		t.forget(month)
+	}
+	for _, q := range t.Config.Quotas {
+		kind, name, _ := q.kind()
+		value := q.value(entry)
+		// a quota set for a value takes precedence over the one set for each value
+		if name != Each && name != value || name == Each && t.specific[kind+"="+value] {
+			continue
+		}
+
+		key := month + " " + kind + "=" + value
+		u, ok := t.state.usage[key]
+		if !ok {
+			u = &usage{}
+			t.state.usage[key] = u
+		}
+		u.Bytes += entry.Quantity
+		if t.state.persisted {
+			t.state.unsaved = append(t.state.unsaved, addition{offset: entry.Offset, key: key, bytes: entry.Quantity})
+		}
+
+		limit := int64(q.LimitGB * bytesPerGB)
+		percent := float64(u.Bytes) / float64(limit) * 100
+		threshold := t.crossed(percent)
+		if threshold <= u.Fired {
+			continue
+		}
+		u.Fired = threshold
+		t.alert(Alert{
+			Kind:      kind,
+			Name:      value,
+			Month:     month,
+			Threshold: threshold,
+			Used:      u.Bytes,
+			Limit:     limit,
+			Percent:   percent,
+		})
+	}
+	return true
+}
+
+// Usage returns the total bytes of a user, project or repository in a month, e.g. Usage("2024-03", "user", "alice")
+func (t *Tracker) Usage(month, kind, name string) int64 {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if u, ok := t.state.usage[month+" "+kind+"="+name]; ok {
+		return u.Bytes
+	}
+	return 0
+}
+
+// Save persists the totals of the lines up to the input position which was written to a file
+// The lines after it are read again on the next start, so their bytes must not be counted twice then
+// The fired alerts are kept, so they do not fire again for the lines which are read again
+func (t *Tracker) Save(path string, written int64) error {
+	t.state.mu.Lock()
+	totals := make(map[string]*usage, len(t.state.usage))
+	for key, u := range t.state.usage {
+		saved := *u
+		totals[key] = &saved
+	}
+	unsaved := t.state.unsaved[:0]
+	for _, a := range t.state.unsaved {
+		if a.offset <= written {
+			continue
+		}
+		// the totals of a forgotten month are not saved anyway
+		if u, ok := totals[a.key]; ok {
+			u.Bytes -= a.bytes
+			unsaved = append(unsaved, a)
+		}
+	}
+	t.state.unsaved = unsaved
+	t.state.mu.Unlock()
+
+	data, err := json.Marshal(totals)
+	if err != nil {
+		return fmt.Errorf("failed to encode quota state: %v", err)
+	}
+	if err = checkpoint.WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to write quota state: %v", err)
+	}
+	return nil
+}
+
+// Load restores the totals from a file written by Save
+// A missing file is not an error
+// From then on the bytes added by each line are recorded until a Save covers the line
+func (t *Tracker) Load(path string) error {
+	t.state.mu.Lock()
+	t.state.persisted = true
+	t.state.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read quota state: %v", err)
+	}
+
+	loaded := make(map[string]*usage)
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse quota state: %v", err)
+	}
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	t.state.usage = loaded
+	for key := range loaded {
+		if month, _, _ := strings.Cut(key, " "); month > t.state.month {
+			t.state.month = month
+		}
+	}
+	return nil
+}
+
+// crossed returns the highest threshold which is not above the percentage, 0 if there is none
+func (t *Tracker) crossed(percent float64) float64 {
+	var crossed float64
+	for _, threshold := range t.Config.Thresholds {
+		if threshold <= percent {
+			crossed = threshold
+		}
+	}
+	return crossed
+}
+
+// forget removes the totals of the months before the previous one when a new month starts
+// The previous month is kept for requests which are processed late
+func (t *Tracker) forget(month string) {
+	t.state.month = month
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return
+	}
+	previous := start.AddDate(0, -1, 0).Format("2006-01")
+	for key := range t.state.usage {
+		if key < previous {
+			delete(t.state.usage, key)
+		}
+	}
+}
+
+// alert queues an alert for delivery without blocking the worker
+func (t *Tracker) alert(alert Alert) {
+	metrics.QuotaAlerts.Add(1)
+	select {
+	case t.alerts <- alert:
+	default:
+		t.Logger.Error("dropped quota alert because too many are waiting for delivery", "kind", alert.Kind, "name", alert.Name, "threshold", alert.Threshold)
+	}
+}
+
+// deliver logs an alert and runs the hooks
+func (t *Tracker) deliver(ctx context.Context, alert Alert) {
+	t.Logger.Warn("quota alert", "kind", alert.Kind, "name", alert.Name, "percent", alert.Percent, "month", alert.Month, "used", alert.Used, "limit", alert.Limit)
+	if len(t.hooks) == 0 {
+		return
+	}
+
+	event, err := json.Marshal(alert)
+	if err != nil {
+		t.Logger.Error("failed to encode quota alert", "error", err)
+		return
+	}
+	for _, h := range t.hooks {
+		hookCtx, cancel := context.WithTimeout(ctx, t.timeout)
+		if err = h.Run(hookCtx, event); err != nil {
+			metrics.QuotaAlertFailures.Add(1)
+			t.Logger.Error("failed to deliver quota alert", "hook", h.String(), "error", err)
+		}
+		cancel()
+	}
+}
+
+// kind returns which kind of quota it is and the name it applies to
+func (q Quota) kind() (string, string, error) {
+	var kind, name string
+	set := 0
+	if q.User != "" {
+		kind, name = "user", q.User
+		set++
+	}
+	if q.Project != "" {
+		kind, name = "project", q.Project
+		set++
+	}
+	if q.Repository != "" {
+		kind, name = "repository", q.Repository
+		set++
+	}
+	if set != 1 {
+		return "", "", fmt.Errorf("quota %+v must set exactly one of user, project and repository", q)
+	}
+	return kind, name, nil
+}
+
+// value returns the value of an entry the quota applies to
+func (q Quota) value(entry *parser.BillingLogs) string {
+	switch {
+	case q.User != "":
+		return entry.User
+	case q.Project != "":
+		return entry.Project
+	default:
+		return entry.Repository
+	}
+}
@@ -0,0 +1,264 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+// gb is the number of bytes in a GB
+const gb = bytesPerGB
+
+var march = time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func TestNewTracker_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"NoKind", Config{Quotas: []Quota{{LimitGB: 1}}}},
+		{"TwoKinds", Config{Quotas: []Quota{{User: "alice", Project: "web", LimitGB: 1}}}},
+		{"NoLimit", Config{Quotas: []Quota{{User: "alice"}}}},
+		{"NegativeThreshold", Config{Thresholds: []float64{-5}}},
+		{"InvalidTimeout", Config{HookTimeout: "soon"}},
+		{"EmptyExec", Config{Exec: []string{""}}},
+		{"Duplicate", Config{Quotas: []Quota{{User: "alice", LimitGB: 1}, {User: "alice", LimitGB: 2}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTracker(tt.config, slog.New(slog.NewTextHandler(&MockLogger{}, nil))); err == nil {
+				t.Error("NewTracker() - Expected error")
+			}
+		})
+	}
+}
+
+func TestTracker_Process(t *testing.T) {
+	tracker, err := NewTracker(Config{
+		Quotas: []Quota{
+			{User: Each, LimitGB: 10},
+			{User: "ci", LimitGB: 100},
+			{Project: "ml", LimitGB: 4},
+		},
+		Thresholds: []float64{100, 50},
+	}, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err != nil {
+		t.Fatal("NewTracker() returned an error:", err)
+	}
+
+	process := func(user, project string, quantity int64, at time.Time) {
+		entry := parser.BillingLogs{User: user, Project: project, Quantity: quantity, RequestTime: at}
+		if !tracker.Process(&entry) {
+			t.Fatal("Process() - Expected entry to be kept")
+		}
+	}
+	expectAlerts := func(want ...Alert) {
+		t.Helper()
+		for _, alert := range want {
+			select {
+			case got := <-tracker.alerts:
+				if got.Kind != alert.Kind || got.Name != alert.Name || got.Threshold != alert.Threshold || got.Month != alert.Month {
+					t.Errorf("Process() - Expected alert: %+v, got: %+v", alert, got)
+				}
+			default:
+				t.Errorf("Process() - Expected alert: %+v", alert)
+			}
+		}
+		select {
+		case got := <-tracker.alerts:
+			t.Errorf("Process() - Unexpected alert: %+v", got)
+		default:
+		}
+	}
+
+	process("alice", "web", 4*gb, march)
+	expectAlerts()
+	process("bob", "ml", 2*gb, march)
+	expectAlerts(Alert{Kind: "project", Name: "ml", Threshold: 50, Month: "2024-03"})
+
+	// Each user has its own budget and a threshold only fires once
+	process("alice", "web", 2*gb, march)
+	expectAlerts(Alert{Kind: "user", Name: "alice", Threshold: 50, Month: "2024-03"})
+	process("alice", "web", gb/2, march)
+	expectAlerts()
+
+	// A user with an own quota does not use the one for each user
+	process("ci", "web", 20*gb, march)
+	expectAlerts()
+	if used := tracker.Usage("2024-03", "user", "ci"); used != 20*gb {
+		t.Errorf("Usage() - Expected usage of ci: %d, got: %d", 20*gb, used)
+	}
+
+	// Several thresholds crossed at once fire the highest of them
+	process("carol", "ml", 11*gb, march)
+	expectAlerts(
+		Alert{Kind: "user", Name: "carol", Threshold: 100, Month: "2024-03"},
+		Alert{Kind: "project", Name: "ml", Threshold: 100, Month: "2024-03"},
+	)
+
+	// The budgets start again each month
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	process("alice", "web", 6*gb, april)
+	expectAlerts(Alert{Kind: "user", Name: "alice", Threshold: 50, Month: "2024-04"})
+	if used := tracker.Usage("2024-03", "user", "alice"); used != 6*gb+gb/2 {
+		t.Errorf("Usage() - Expected usage of alice in March: %d, got: %d", 6*gb+gb/2, used)
+	}
+
+	// Months before the previous one are forgotten
+	process("alice", "web", gb, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if used := tracker.Usage("2024-03", "user", "alice"); used != 0 {
+		t.Errorf("Usage() - Expected usage of March to be forgotten, got: %d", used)
+	}
+	if used := tracker.Usage("2024-04", "user", "alice"); used != 6*gb {
+		t.Errorf("Usage() - Expected usage of April to be kept: %d, got: %d", 6*gb, used)
+	}
+}
+
+func TestTracker_Persist(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, StateFile)
+
+	config := Config{Quotas: []Quota{{Repository: "docker-remote", LimitGB: 2}}, Thresholds: []float64{50}}
+	tracker, _ := NewTracker(config, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	entry := parser.BillingLogs{Repository: "docker-remote", Quantity: gb, RequestTime: march}
+	tracker.Process(&entry)
+	<-tracker.alerts
+	if err = tracker.Save(path, 0); err != nil {
+		t.Fatal("Save() returned an error:", err)
+	}
+
+	// The totals survive a restart and the alert does not fire again
+	restored, _ := NewTracker(config, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err = restored.Load(path); err != nil {
+		t.Fatal("Load() returned an error:", err)
+	}
+	restored.Process(&entry)
+	if used := restored.Usage("2024-03", "repository", "docker-remote"); used != 2*gb {
+		t.Errorf("Usage() - Expected usage: %d, got: %d", 2*gb, used)
+	}
+	if len(restored.alerts) != 0 {
+		t.Errorf("Process() - Expected no alert after restoring the state, got: %d", len(restored.alerts))
+	}
+
+	// The totals are kept when the quota file is reloaded
+	reloaded, _ := NewTracker(config, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	reloaded.Inherit(restored)
+	if used := reloaded.Usage("2024-03", "repository", "docker-remote"); used != 2*gb {
+		t.Errorf("Usage() - Expected usage after inheriting: %d, got: %d", 2*gb, used)
+	}
+
+	// The bytes added by the previous Tracker after the reload are kept
+	restored.Process(&entry)
+	if used := reloaded.Usage("2024-03", "repository", "docker-remote"); used != 3*gb {
+		t.Errorf("Usage() - Expected usage added by the previous Tracker: %d, got: %d", 3*gb, used)
+	}
+}
+
+func TestTracker_SaveWritten(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, StateFile)
+
+	config := Config{Quotas: []Quota{{Repository: "docker-remote", LimitGB: 10}}}
+	tracker, _ := NewTracker(config, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err = tracker.Load(path); err != nil {
+		t.Fatal("Load() returned an error:", err)
+	}
+	for _, offset := range []int64{10, 20} {
+		tracker.Process(&parser.BillingLogs{Repository: "docker-remote", Quantity: gb, RequestTime: march, Offset: offset})
+	}
+
+	tests := []struct {
+		written int64
+		want    int64
+	}{
+		// the line at offset 20 is read again after a restart, so its bytes are not saved
+		{10, gb},
+		{20, 2 * gb},
+	}
+
+	for _, tt := range tests {
+		if err = tracker.Save(path, tt.written); err != nil {
+			t.Fatal("Save() returned an error:", err)
+		}
+		restored, _ := NewTracker(config, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+		if err = restored.Load(path); err != nil {
+			t.Fatal("Load() returned an error:", err)
+		}
+		if used := restored.Usage("2024-03", "repository", "docker-remote"); used != tt.want {
+			t.Errorf("Usage() - Expected usage after saving up to offset %d: %d, got: %d", tt.written, tt.want, used)
+		}
+	}
+	if used := tracker.Usage("2024-03", "repository", "docker-remote"); used != 2*gb {
+		t.Errorf("Usage() - Expected the running total to include all lines: %d, got: %d", 2*gb, used)
+	}
+}
+
+func TestTracker_Hooks(t *testing.T) {
+	received := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &alert)
+		received <- alert
+	}))
+	defer server.Close()
+
+	tracker, err := NewTracker(Config{
+		Quotas:  []Quota{{User: "ci", LimitGB: 1}},
+		Webhook: server.URL,
+		Exec:    []string{"false"},
+	}, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+	if err != nil {
+		t.Fatal("NewTracker() returned an error:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.Start(ctx)
+
+	failures := metrics.QuotaAlertFailures.Value()
+	entry := parser.BillingLogs{User: "ci", Quantity: gb, RequestTime: march}
+	tracker.Process(&entry)
+
+	select {
+	case alert := <-received:
+		if alert.Name != "ci" || alert.Threshold != 100 || alert.Used != gb {
+			t.Errorf("Start() - Unexpected alert: %+v", alert)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start() - Expected alert to be posted to the webhook")
+	}
+
+	// The failing command is counted
+	deadline := time.Now().Add(2 * time.Second)
+	for metrics.QuotaAlertFailures.Value() == failures && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if metrics.QuotaAlertFailures.Value() != failures+1 {
+		t.Errorf("Start() - Expected failed delivery to be counted")
+	}
+}
@@ -0,0 +1,223 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/report"
+)
+
+// Key aligns the billing log entries of logcat with the ones of the reference
+type Key struct {
+	Hour       string `json:"hour"`
+	Repository string `json:"repository"`
+	Path       string `json:"artifactory_path"`
+	User       string `json:"user_name"`
+}
+
+// Totals sums up the billing log entries of a key
+type Totals struct {
+	Records  int64 `json:"records"`
+	Quantity int64 `json:"quantity"`
+}
+
+// Entry contains the totals of a key in the logcat output and in the reference
+type Entry struct {
+	Key
+	Ours      Totals `json:"logcat"`
+	Reference Totals `json:"reference"`
+}
+
+// Reconciler compares the billing log entries of logcat with the ones of a reference, e.g. Artifactory Cloud
+// The entries are summed up per key, so several requests for the same artifact in an hour are compared as one
+type Reconciler struct {
+	Filter report.Filter
+
+	entries map[Key]*Entry
+}
+
+// NewReconciler creates and returns a Reconciler which only compares the entries selected by the filter
+func NewReconciler(filter report.Filter) *Reconciler {
+	return &Reconciler{
+		Filter:  filter,
+		entries: make(map[Key]*Entry),
+	}
+}
+
+// AddOurs adds a billing log entry written by logcat
+func (r *Reconciler) AddOurs(b *parser.BillingLogs) error {
+	totals, err := r.totals(b, func(e *Entry) *Totals { return &e.Ours })
+	if err != nil || totals == nil {
+		return err
+	}
+	totals.Records++
+	totals.Quantity += b.Quantity
+	return nil
+}
+
+// AddReference adds a billing log entry of the reference
+func (r *Reconciler) AddReference(b *parser.BillingLogs) error {
+	totals, err := r.totals(b, func(e *Entry) *Totals { return &e.Reference })
+	if err != nil || totals == nil {
+		return err
+	}
+	totals.Records++
+	totals.Quantity += b.Quantity
+	return nil
+}
+
+// totals returns the totals of the entry's key on one side, nil if the entry is not selected by the filter
+func (r *Reconciler) totals(b *parser.BillingLogs, side func(e *Entry) *Totals) (*Totals, error) {
+	ok, err := r.Filter.Match(b)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	key := Key{
+		Hour:       hour(b.Timestamp),
+		Repository: b.Repository,
+		Path:       b.ArtifactoryPath,
+		User:       b.User,
+	}
+	entry, ok := r.entries[key]
+	if !ok {
+		entry = &Entry{Key: key}
+		r.entries[key] = entry
+	}
+	return side(entry), nil
+}
+
+// Result contains the differences between logcat and the reference
+type Result struct {
+	// Keys is the number of keys in both of them, Matched the number of keys with the same quantity
+	Keys    int `json:"keys"`
+	Matched int `json:"matched"`
+	// Missing are the keys which are only in the reference
+	Missing []Entry `json:"missing"`
+	// Extra are the keys which are only in the logcat output
+	Extra []Entry `json:"extra"`
+	// Mismatched are the keys which are in both with a different quantity
+	Mismatched        []Entry `json:"mismatched"`
+	OursQuantity      int64   `json:"logcat_quantity"`
+	ReferenceQuantity int64   `json:"reference_quantity"`
+}
+
+// Result compares the added billing log entries
+func (r *Reconciler) Result() Result {
+	var result Result
+	for _, entry := range r.entries {
+		result.Keys++
+		result.OursQuantity += entry.Ours.Quantity
+		result.ReferenceQuantity += entry.Reference.Quantity
+		switch {
+		case entry.Ours.Records == 0:
+			result.Missing = append(result.Missing, *entry)
+		case entry.Reference.Records == 0:
+			result.Extra = append(result.Extra, *entry)
+		case entry.Ours.Quantity != entry.Reference.Quantity:
+			result.Mismatched = append(result.Mismatched, *entry)
+		default:
+			result.Matched++
+		}
+	}
+	for _, entries := range [][]Entry{result.Missing, result.Extra, result.Mismatched} {
+		sort.Slice(entries, func(i, j int) bool { return less(entries[i].Key, entries[j].Key) })
+	}
+	return result
+}
+
+// Differences returns the number of keys which do not match
+func (r Result) Differences() int {
+	return len(r.Missing) + len(r.Extra) + len(r.Mismatched)
+}
+
+// Write prints the summary and at most limit entries of each kind of difference as text or JSON, all of them when limit is 0
+func (r Result) Write(w io.Writer, format report.Format, limit int) error {
+	switch format {
+	case report.Table:
+		return r.writeText(w, limit)
+	case report.JSON:
+		r.Missing, r.Extra, r.Mismatched = head(r.Missing, limit), head(r.Extra, limit), head(r.Mismatched, limit)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	default:
+		return fmt.Errorf("unknown diff format %q", format)
+	}
+}
+
+func (r Result) writeText(w io.Writer, limit int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "keys\t%d\t\n", r.Keys)
+	fmt.Fprintf(tw, "matched\t%d\t%s\n", r.Matched, percent(int64(r.Matched), int64(r.Keys)))
+	fmt.Fprintf(tw, "missing\t%d\t%s\n", len(r.Missing), percent(int64(len(r.Missing)), int64(r.Keys)))
+	fmt.Fprintf(tw, "extra\t%d\t%s\n", len(r.Extra), percent(int64(len(r.Extra)), int64(r.Keys)))
+	fmt.Fprintf(tw, "mismatched\t%d\t%s\n", len(r.Mismatched), percent(int64(len(r.Mismatched)), int64(r.Keys)))
+	fmt.Fprintf(tw, "logcat quantity\t%d\t\n", r.OursQuantity)
+	fmt.Fprintf(tw, "reference quantity\t%d\t%s difference\n", r.ReferenceQuantity, percent(r.OursQuantity-r.ReferenceQuantity, r.ReferenceQuantity))
+
+	for _, section := range []struct {
+		name    string
+		entries []Entry
+	}{
+		{"missing", r.Missing},
+		{"extra", r.Extra},
+		{"mismatched", r.Mismatched},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s\n", section.name)
+		fmt.Fprintln(tw, "hour\trepository\tpath\tuser\tlogcat\treference")
+		for _, e := range head(section.entries, limit) {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\n", e.Hour, e.Repository, e.Path, e.User, e.Ours.Quantity, e.Reference.Quantity)
+		}
+		if limit > 0 && len(section.entries) > limit {
+			fmt.Fprintf(tw, "... %d more\n", len(section.entries)-limit)
+		}
+	}
+	return tw.Flush()
+}
+
+// hour returns the hour of a billing log timestamp, so timestamps with and without minutes
+// and with a space or a "T" between the date and the time align
+func hour(timestamp string) string {
+	if len(timestamp) < len("2006-01-02 15") {
+		return timestamp
+	}
+	return timestamp[:len("2006-01-02")] + " " + timestamp[len("2006-01-02 "):len("2006-01-02 15")]
+}
+
+// less orders keys by hour, repository, path and user
+func less(a, b Key) bool {
+	if a.Hour != b.Hour {
+		return a.Hour < b.Hour
+	}
+	if a.Repository != b.Repository {
+		return a.Repository < b.Repository
+	}
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return a.User < b.User
+}
+
+// head returns at most the first n entries, all of them when n is 0
+func head(entries []Entry, n int) []Entry {
+	if n > 0 && len(entries) > n {
+		return entries[:n]
+	}
+	return entries
+}
+
+// percent formats a part of a total as a percentage
+func percent(part, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(part)/float64(total)*100)
+}
@@ -0,0 +1,106 @@
+package reconcile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/report"
+)
+
+func entry(timestamp, repository, path, user string, quantity int64) *parser.BillingLogs {
+	return &parser.BillingLogs{
+		Timestamp:       timestamp,
+		Repository:      repository,
+		ArtifactoryPath: path,
+		User:            user,
+		Quantity:        quantity,
+	}
+}
+
+func reconciler(t *testing.T) *Reconciler {
+	r := NewReconciler(report.Filter{})
+	ours := []*parser.BillingLogs{
+		entry("2024-03-04 10:00:00.000", "docker-remote", "library/alpine", "alice", 100),
+		entry("2024-03-04 10:00:00.000", "docker-remote", "library/alpine", "alice", 50),
+		entry("2024-03-04 10:00:00.000", "npm-remote", "left-pad", "bob", 10),
+		entry("2024-03-04 11:00:00.000", "npm-remote", "react", "bob", 70),
+		entry("2024-03-04 12:00:00.000", "pypi-remote", "numpy", "carol", 5),
+	}
+	reference := []*parser.BillingLogs{
+		// several requests of an hour are compared as one and the time may be written with a "T"
+		entry("2024-03-04T10:00:00Z", "docker-remote", "library/alpine", "alice", 150),
+		entry("2024-03-04 10:00:00.000", "npm-remote", "left-pad", "bob", 10),
+		entry("2024-03-04 11:00:00.000", "npm-remote", "react", "bob", 80),
+		entry("2024-03-04 11:00:00.000", "generic-local", "tool.zip", "dave", 40),
+	}
+	for _, b := range ours {
+		if err := r.AddOurs(b); err != nil {
+			t.Fatal("AddOurs() returned an error:", err)
+		}
+	}
+	for _, b := range reference {
+		if err := r.AddReference(b); err != nil {
+			t.Fatal("AddReference() returned an error:", err)
+		}
+	}
+	return r
+}
+
+func TestReconciler_Result(t *testing.T) {
+	result := reconciler(t).Result()
+
+	if result.Keys != 5 || result.Matched != 2 || result.Differences() != 3 {
+		t.Errorf("Result() - Expected 5 keys, 2 matched and 3 differences, got: %d, %d, %d", result.Keys, result.Matched, result.Differences())
+	}
+	if len(result.Missing) != 1 || result.Missing[0].Path != "tool.zip" || result.Missing[0].Reference.Quantity != 40 {
+		t.Errorf("Result() - Expected tool.zip to be missing, got: %+v", result.Missing)
+	}
+	if len(result.Extra) != 1 || result.Extra[0].Path != "numpy" || result.Extra[0].Ours.Quantity != 5 {
+		t.Errorf("Result() - Expected numpy to be extra, got: %+v", result.Extra)
+	}
+	if len(result.Mismatched) != 1 || result.Mismatched[0].Path != "react" || result.Mismatched[0].Ours.Quantity != 70 || result.Mismatched[0].Reference.Quantity != 80 {
+		t.Errorf("Result() - Expected react to be mismatched, got: %+v", result.Mismatched)
+	}
+	if result.OursQuantity != 235 || result.ReferenceQuantity != 280 {
+		t.Errorf("Result() - Expected quantities 235 and 280, got: %d, %d", result.OursQuantity, result.ReferenceQuantity)
+	}
+}
+
+func TestReconciler_Filter(t *testing.T) {
+	r := NewReconciler(report.Filter{Repository: "npm-remote"})
+	r.AddOurs(entry("2024-03-04 10:00:00.000", "docker-remote", "library/alpine", "alice", 100))
+	r.AddReference(entry("2024-03-04 10:00:00.000", "npm-remote", "react", "bob", 80))
+
+	result := r.Result()
+	if result.Keys != 1 || len(result.Missing) != 1 {
+		t.Errorf("Result() - Expected only the npm-remote entry, got: %+v", result)
+	}
+}
+
+func TestResult_Write(t *testing.T) {
+	result := reconciler(t).Result()
+
+	var buf bytes.Buffer
+	if err := result.Write(&buf, report.Table, 0); err != nil {
+		t.Fatal("Write() returned an error:", err)
+	}
+	for _, want := range []string{"matched             2    40.00%", "missing             1    20.00%", "-16.07% difference", "tool.zip", "numpy", "react"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Write() - Expected %q in output:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := result.Write(&buf, report.JSON, 1); err != nil {
+		t.Fatal("Write() returned an error:", err)
+	}
+	if !strings.Contains(buf.String(), `"artifactory_path": "react"`) || !strings.Contains(buf.String(), `"reference_quantity": 280`) {
+		t.Errorf("Write() - Unexpected JSON output: %s", buf.String())
+	}
+
+	if err := result.Write(&buf, report.CSV, 0); err == nil {
+		t.Error("Write() - Expected error for unsupported format")
+	}
+}
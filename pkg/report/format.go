@@ -0,0 +1,104 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is the output format of a report
+type Format string
+
+const (
+	// Table prints aligned columns for reading in a terminal
+	Table Format = "table"
+	// CSV prints comma separated values with a header
+	CSV Format = "csv"
+	// JSON prints a JSON document with the rows and the total
+	JSON Format = "json"
+)
+
+// Write prints the top rows and the total of a report in a format
+func (r *Report) Write(w io.Writer, format Format, top int) error {
+	rows := r.Rows(top)
+	switch format {
+	case Table:
+		return r.writeTable(w, rows)
+	case CSV:
+		return r.writeCSV(w, rows)
+	case JSON:
+		return r.writeJSON(w, rows)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// header returns the column names of a report
+// The cost column is only printed when the billing logs were priced
+func (r *Report) header() []string {
+	header := append(append([]string{}, r.GroupBy...), "requests", "quantity")
+	if r.currency != "" {
+		header = append(header, "cost_"+strings.ToLower(r.currency))
+	}
+	return header
+}
+
+// record returns the columns of a row
+func (r *Report) record(row Row) []string {
+	record := append(append([]string{}, row.Group...), strconv.FormatInt(row.Requests, 10), strconv.FormatInt(row.Quantity, 10))
+	if r.currency != "" {
+		record = append(record, strconv.FormatFloat(row.Cost, 'f', 2, 64))
+	}
+	return record
+}
+
+func (r *Report) writeTable(w io.Writer, rows []Row) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(r.header(), "\t")+"\t")
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(r.record(row), "\t")+"\t")
+	}
+
+	// the total is printed below the rows with the label in the first group column
+	total := r.total
+	total.Group = make([]string, len(r.GroupBy))
+	total.Group[0] = "total"
+	fmt.Fprintln(tw, strings.Join(r.record(total), "\t")+"\t")
+	return tw.Flush()
+}
+
+func (r *Report) writeCSV(w io.Writer, rows []Row) error {
+	cw := csv.NewWriter(w)
+	cw.Write(r.header())
+	for _, row := range rows {
+		cw.Write(r.record(row))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *Report) writeJSON(w io.Writer, rows []Row) error {
+	type total struct {
+		Requests int64   `json:"requests"`
+		Quantity int64   `json:"quantity"`
+		Cost     float64 `json:"cost,omitempty"`
+	}
+	document := struct {
+		GroupBy  []string `json:"group_by"`
+		Currency string   `json:"currency,omitempty"`
+		Rows     []Row    `json:"rows"`
+		Total    total    `json:"total"`
+	}{
+		GroupBy:  r.GroupBy,
+		Currency: r.currency,
+		Rows:     rows,
+		Total:    total{Requests: r.total.Requests, Quantity: r.total.Quantity, Cost: r.total.Cost},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
@@ -0,0 +1,164 @@
+package report
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+// maxLineSize is the maximum size of a billing log line which can be read
+const maxLineSize = 1024 * 1024
+
+// Stats counts what was read from the billing log files
+type Stats struct {
+	Files   int
+	Entries int64
+	// Invalid is the number of lines which are not billing log entries
+	Invalid int64
+}
+
+// unreadable are the file name patterns of the output formats which can not be read
+var unreadable = []string{"*.csv", "*.csv.gz", "*.parquet"}
+
+// Files returns the billing log files in a directory written with the file name prefix, in the order they were written
+// Plain and gzip compressed files are returned, the manifests are not
+// An empty prefix returns all billing log files in the directory
+// It returns an error when the directory has billing log files in the csv or parquet format, which can not be read
+func Files(dir string, prefix string) ([]string, error) {
+	if prefix != "" {
+		prefix += "-"
+	}
+	for _, pattern := range unreadable {
+		matches, err := filepath.Glob(filepath.Join(dir, prefix+pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list billing log files: %v", err)
+		}
+		if len(matches) > 0 {
+			return nil, fmt.Errorf("billing log file %v can not be read, only the json and ndjson output formats are supported", matches[0])
+		}
+	}
+	var files []string
+	for _, pattern := range []string{prefix + "*.log", prefix + "*.log.gz"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list billing log files: %v", err)
+		}
+		files = append(files, matches...)
+	}
+	// the file names start with the time they were created
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadDir reads the billing log files in a directory written with the file name prefix
+// and calls fn for each billing log entry, one entry at a time
+func ReadDir(dir string, prefix string, fn func(b *parser.BillingLogs) error) (Stats, error) {
+	var stats Stats
+	files, err := Files(dir, prefix)
+	if err != nil {
+		return stats, err
+	}
+	for _, file := range files {
+		if err = ReadFile(file, &stats, fn); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// ReadFile reads a plain or gzip compressed billing log file and calls fn for each billing log entry
+func ReadFile(path string, stats *Stats, fn func(b *parser.BillingLogs) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open billing log file: %v", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress billing log file %v: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// the fields which were renamed by the output schema are read with their default names
+	renamed, err := renames(path)
+	if err != nil {
+		return err
+	}
+
+	stats.Files++
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var b parser.BillingLogs
+		if err = unmarshal(line, renamed, &b); err != nil {
+			stats.Invalid++
+			continue
+		}
+		stats.Entries++
+		if err = fn(&b); err != nil {
+			return fmt.Errorf("failed to process billing log file %v: %v", path, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read billing log file %v: %v", path, err)
+	}
+	return nil
+}
+
+// renames returns the fields by the names they were written with, which are recorded in the manifest of a billing log file
+// Files without a manifest are read with the default field names
+func renames(path string) (map[string]string, error) {
+	data, err := os.ReadFile(writer.ManifestPath(strings.TrimSuffix(path, ".gz")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of billing log file %v: %v", path, err)
+	}
+	var manifest writer.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of billing log file %v: %v", path, err)
+	}
+	return parser.Renamed(manifest.Metadata), nil
+}
+
+// unmarshal decodes a billing log line and maps the renamed fields back to their default names
+func unmarshal(line []byte, renamed map[string]string, b *parser.BillingLogs) error {
+	if len(renamed) == 0 {
+		return json.Unmarshal(line, b)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return err
+	}
+	entry := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		if field, ok := renamed[name]; ok {
+			name = field
+		}
+		entry[name] = value
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, b)
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+func TestReadDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := `{"billing_timestamp":"2024-03-04 10:00:00.000","user_name":"alice","quantity":100}` + "\n" + "not json\n"
+	second := `{"billing_timestamp":"2024-03-04 11:00:00.000","user_name":"bob","quantity":300}` + "\n"
+
+	// A plain file, a compressed file, a manifest and a dead-letter file
+	if err = os.WriteFile(filepath.Join(dir, writer.DefaultPrefix+"-20240304100000-aaaa.log"), []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(dir, writer.DefaultPrefix+"-20240304110000-bbbb.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(second))
+	gz.Close()
+	file.Close()
+	os.WriteFile(filepath.Join(dir, writer.DefaultPrefix+"-20240304100000-aaaa"+writer.ManifestSuffix), []byte("{}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "logcat-dead-letter-20240304100000-cccc.log"), []byte("broken line\n"), 0644)
+
+	var users []string
+	stats, err := ReadDir(dir, writer.DefaultPrefix, func(b *parser.BillingLogs) error {
+		users = append(users, b.User)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("ReadDir() returned an error:", err)
+	}
+	if len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Errorf("ReadDir() - Expected entries of alice and bob, got: %v", users)
+	}
+	if stats.Files != 2 || stats.Entries != 2 || stats.Invalid != 1 {
+		t.Errorf("ReadDir() - Expected 2 files, 2 entries and 1 invalid line, got: %+v", stats)
+	}
+}
+
+func TestFiles_Unreadable(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		file string
+		err  bool
+	}{
+		{writer.DefaultPrefix + "-20240304100000-aaaa.log", false},
+		{"other-20240304100000-aaaa.csv", false},
+		{writer.DefaultPrefix + "-20240304100000-bbbb.csv", true},
+		{writer.DefaultPrefix + "-20240304100000-cccc.parquet", true},
+	}
+
+	for _, tt := range tests {
+		if err = os.WriteFile(filepath.Join(dir, tt.file), []byte("\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = Files(dir, writer.DefaultPrefix); (err != nil) != tt.err {
+			t.Errorf("Files() - Expected an error after %v was written: %v, got: %v", tt.file, tt.err, err)
+		}
+		os.Remove(filepath.Join(dir, tt.file))
+	}
+}
+
+func TestReadFile_Renamed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	schema, err := parser.NewSchema(parser.Schema{Rename: map[string]string{"billing_timestamp": "time", "quantity": "user_name", "user_name": "user"}})
+	if err != nil {
+		t.Fatal("NewSchema() returned an error:", err)
+	}
+	path := filepath.Join(dir, writer.DefaultPrefix+"-20240304100000-aaaa.log")
+	line := `{"time":"2024-03-04 10:00:00.000","user":"alice","user_name":100}` + "\n"
+	if err = os.WriteFile(path, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.WriteManifest(path, schema.Metadata()); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []parser.BillingLogs
+	var stats Stats
+	if err = ReadFile(path, &stats, func(b *parser.BillingLogs) error {
+		entries = append(entries, *b)
+		return nil
+	}); err != nil {
+		t.Fatal("ReadFile() returned an error:", err)
+	}
+	if len(entries) != 1 || entries[0].Timestamp != "2024-03-04 10:00:00.000" || entries[0].User != "alice" || entries[0].Quantity != 100 {
+		t.Errorf("ReadFile() - Expected the renamed fields with their default names, got: %+v", entries)
+	}
+}
@@ -0,0 +1,192 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+// groupKeys returns the value of a billing log entry a report can be grouped by
+var groupKeys = map[string]func(b *parser.BillingLogs) string{
+	"user":        func(b *parser.BillingLogs) string { return b.User },
+	"repository":  func(b *parser.BillingLogs) string { return b.Repository },
+	"project":     func(b *parser.BillingLogs) string { return b.Project },
+	"server":      func(b *parser.BillingLogs) string { return b.ServerName },
+	"site":        func(b *parser.BillingLogs) string { return b.Site },
+	"team":        func(b *parser.BillingLogs) string { return b.Team },
+	"cost_center": func(b *parser.BillingLogs) string { return b.CostCenter },
+	"action":      func(b *parser.BillingLogs) string { return b.Action },
+	"day":         func(b *parser.BillingLogs) string { return prefix(b.Timestamp, len("2006-01-02")) },
+	"hour":        func(b *parser.BillingLogs) string { return prefix(b.Timestamp, len("2006-01-02 15:04")) },
+}
+
+// GroupKeys returns the names of the keys a report can be grouped by
+func GroupKeys() []string {
+	var keys []string
+	for key := range groupKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Filter selects the billing log entries which are included in a report
+// Empty fields match all entries, the time range includes From and excludes To
+type Filter struct {
+	From       time.Time
+	To         time.Time
+	Server     string
+	Repository string
+	User       string
+	Project    string
+	// Location is the time zone of the billing log timestamps, nil is treated as UTC
+	Location *time.Location
+}
+
+// Match returns whether a billing log entry is selected by the filter
+func (f Filter) Match(b *parser.BillingLogs) (bool, error) {
+	if f.Server != "" && b.ServerName != f.Server {
+		return false, nil
+	}
+	if f.Repository != "" && b.Repository != f.Repository {
+		return false, nil
+	}
+	if f.User != "" && b.User != f.User {
+		return false, nil
+	}
+	if f.Project != "" && b.Project != f.Project {
+		return false, nil
+	}
+	if f.From.IsZero() && f.To.IsZero() {
+		return true, nil
+	}
+
+	location := f.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t, err := time.ParseInLocation(parser.BillingTimestampFormat, b.Timestamp, location)
+	if err != nil {
+		return false, fmt.Errorf("invalid billing timestamp %q: %v", b.Timestamp, err)
+	}
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false, nil
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Row contains the totals of a group of billing log entries
+type Row struct {
+	Group    []string `json:"group"`
+	Requests int64    `json:"requests"`
+	Quantity int64    `json:"quantity"`
+	Cost     float64  `json:"cost,omitempty"`
+}
+
+// Report sums up the billing log entries selected by the filter per group
+// Only the totals are kept, so the memory used depends on the number of groups and not on the number of entries
+type Report struct {
+	GroupBy []string
+	Filter  Filter
+
+	keys   []func(b *parser.BillingLogs) string
+	groups map[string]*Row
+	total  Row
+	// currency is the currency of the cost of the entries, empty if none of them was priced
+	currency string
+}
+
+// NewReport creates and returns a Report and validates the group keys
+func NewReport(r Report) (*Report, error) {
+	report := &Report{
+		GroupBy: r.GroupBy,
+		Filter:  r.Filter,
+		groups:  make(map[string]*Row),
+	}
+	if len(r.GroupBy) == 0 {
+		return nil, fmt.Errorf("a report must be grouped by at least one key")
+	}
+	for _, name := range r.GroupBy {
+		key, ok := groupKeys[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown group key %q, expected one of %s", name, strings.Join(GroupKeys(), ", "))
+		}
+		report.keys = append(report.keys, key)
+	}
+	return report, nil
+}
+
+// Add adds a billing log entry to the totals if it is selected by the filter
+func (r *Report) Add(b *parser.BillingLogs) error {
+	ok, err := r.Filter.Match(b)
+	if err != nil || !ok {
+		return err
+	}
+
+	group := make([]string, len(r.keys))
+	for i, key := range r.keys {
+		group[i] = key(b)
+	}
+	id := strings.Join(group, "\x00")
+	row, ok := r.groups[id]
+	if !ok {
+		row = &Row{Group: group}
+		r.groups[id] = row
+	}
+	row.Requests++
+	row.Quantity += b.Quantity
+	row.Cost += b.Cost
+	r.total.Requests++
+	r.total.Quantity += b.Quantity
+	r.total.Cost += b.Cost
+	if b.Currency != "" {
+		if r.currency != "" && r.currency != b.Currency {
+			return fmt.Errorf("billing logs have different currencies: %s and %s", r.currency, b.Currency)
+		}
+		r.currency = b.Currency
+	}
+	return nil
+}
+
+// Rows returns the groups with the largest quantity first
+// At most top rows are returned, all of them when top is 0
+func (r *Report) Rows(top int) []Row {
+	rows := make([]Row, 0, len(r.groups))
+	for _, row := range r.groups {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Quantity != rows[j].Quantity {
+			return rows[i].Quantity > rows[j].Quantity
+		}
+		return strings.Join(rows[i].Group, "\x00") < strings.Join(rows[j].Group, "\x00")
+	})
+	if top > 0 && len(rows) > top {
+		rows = rows[:top]
+	}
+	return rows
+}
+
+// Total returns the totals of all selected billing log entries
+func (r *Report) Total() Row {
+	return r.total
+}
+
+// Currency returns the currency of the cost, empty if the billing logs were not priced
+func (r *Report) Currency() string {
+	return r.currency
+}
+
+// prefix returns the first n bytes of a string
+func prefix(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
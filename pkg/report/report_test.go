@@ -0,0 +1,209 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
+func entries() []parser.BillingLogs {
+	return []parser.BillingLogs{
+		{Timestamp: "2024-03-04 10:00:00.000", ServerName: "a", Repository: "docker-remote", Project: "platform", User: "alice", Quantity: 100},
+		{Timestamp: "2024-03-04 11:00:00.000", ServerName: "a", Repository: "npm-remote", Project: "web", User: "bob", Quantity: 300},
+		{Timestamp: "2024-03-05 10:00:00.000", ServerName: "b", Repository: "docker-remote", Project: "platform", User: "alice", Quantity: 50},
+		{Timestamp: "2024-03-06 10:00:00.000", ServerName: "a", Repository: "docker-remote", Project: "platform", User: "carol", Quantity: 10},
+	}
+}
+
+func TestNewReport_InvalidGroup(t *testing.T) {
+	if _, err := NewReport(Report{GroupBy: []string{"color"}}); err == nil {
+		t.Error("NewReport() - Expected error for unknown group key")
+	}
+	if _, err := NewReport(Report{}); err == nil {
+		t.Error("NewReport() - Expected error without group keys")
+	}
+}
+
+func TestReport_Rows(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupBy []string
+		filter  Filter
+		top     int
+		want    []Row
+		total   Row
+	}{
+		{
+			name:    "GroupByUser",
+			groupBy: []string{"user"},
+			want: []Row{
+				{Group: []string{"bob"}, Requests: 1, Quantity: 300},
+				{Group: []string{"alice"}, Requests: 2, Quantity: 150},
+				{Group: []string{"carol"}, Requests: 1, Quantity: 10},
+			},
+			total: Row{Requests: 4, Quantity: 460},
+		},
+		{
+			name:    "TopN",
+			groupBy: []string{"user"},
+			top:     1,
+			want:    []Row{{Group: []string{"bob"}, Requests: 1, Quantity: 300}},
+			total:   Row{Requests: 4, Quantity: 460},
+		},
+		{
+			name:    "GroupByProjectAndDay",
+			groupBy: []string{"project", "day"},
+			filter:  Filter{Project: "platform"},
+			want: []Row{
+				{Group: []string{"platform", "2024-03-04"}, Requests: 1, Quantity: 100},
+				{Group: []string{"platform", "2024-03-05"}, Requests: 1, Quantity: 50},
+				{Group: []string{"platform", "2024-03-06"}, Requests: 1, Quantity: 10},
+			},
+			total: Row{Requests: 3, Quantity: 160},
+		},
+		{
+			name:    "TimeRange",
+			groupBy: []string{"repository"},
+			filter: Filter{
+				From: time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC),
+				To:   time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC),
+			},
+			want: []Row{
+				{Group: []string{"npm-remote"}, Requests: 1, Quantity: 300},
+				{Group: []string{"docker-remote"}, Requests: 1, Quantity: 50},
+			},
+			total: Row{Requests: 2, Quantity: 350},
+		},
+		{
+			name:    "ServerAndUser",
+			groupBy: []string{"hour"},
+			filter:  Filter{Server: "a", User: "alice"},
+			want:    []Row{{Group: []string{"2024-03-04 10:00"}, Requests: 1, Quantity: 100}},
+			total:   Row{Requests: 1, Quantity: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := NewReport(Report{GroupBy: tt.groupBy, Filter: tt.filter})
+			if err != nil {
+				t.Fatal("NewReport() returned an error:", err)
+			}
+			for _, entry := range entries() {
+				entry := entry
+				if err = report.Add(&entry); err != nil {
+					t.Fatal("Add() returned an error:", err)
+				}
+			}
+
+			rows := report.Rows(tt.top)
+			if len(rows) != len(tt.want) {
+				t.Fatalf("Rows() - Expected rows: %v, got: %v", tt.want, rows)
+			}
+			for i := range rows {
+				if strings.Join(rows[i].Group, ",") != strings.Join(tt.want[i].Group, ",") || rows[i].Requests != tt.want[i].Requests || rows[i].Quantity != tt.want[i].Quantity {
+					t.Errorf("Rows() - Expected row %d: %v, got: %v", i, tt.want[i], rows[i])
+				}
+			}
+			if total := report.Total(); total.Requests != tt.total.Requests || total.Quantity != tt.total.Quantity {
+				t.Errorf("Total() - Expected total: %v, got: %v", tt.total, total)
+			}
+		})
+	}
+}
+
+func TestFilter_Location(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database is not available:", err)
+	}
+
+	// The billing timestamps are in the output time zone of logcat
+	filter := Filter{From: time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC), Location: berlin}
+	entry := entries()[0]
+	ok, err := filter.Match(&entry)
+	if err != nil || !ok {
+		t.Errorf("Match() - Expected 10:00 in Berlin to be after 09:00 UTC, got: %v, %v", ok, err)
+	}
+
+	entry.Timestamp = "invalid"
+	if _, err = filter.Match(&entry); err == nil {
+		t.Error("Match() - Expected error for invalid timestamp")
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	report, err := NewReport(Report{GroupBy: []string{"user", "project"}})
+	if err != nil {
+		t.Fatal("NewReport() returned an error:", err)
+	}
+	for _, entry := range entries()[:2] {
+		entry := entry
+		report.Add(&entry)
+	}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{CSV, "user,project,requests,quantity\nbob,web,1,300\nalice,platform,1,100\n"},
+		{Table, "   user   project  requests  quantity\n    bob       web         1       300\n  alice  platform         1       100\n  total                   2       400\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err = report.Write(&buf, tt.format, 0); err != nil {
+			t.Fatalf("Write() returned an error for %v: %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("Write() - Expected %v output:\n%q\ngot:\n%q", tt.format, tt.want, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = report.Write(&buf, JSON, 1); err != nil {
+		t.Fatal("Write() returned an error:", err)
+	}
+	if !strings.Contains(buf.String(), `"group": [`) || !strings.Contains(buf.String(), `"quantity": 400`) || strings.Contains(buf.String(), "alice") {
+		t.Errorf("Write() - Unexpected JSON output: %s", buf.String())
+	}
+
+	if err = report.Write(&buf, "xml", 0); err == nil {
+		t.Error("Write() - Expected error for unknown format")
+	}
+}
+
+func TestReport_Cost(t *testing.T) {
+	report, err := NewReport(Report{GroupBy: []string{"project"}})
+	if err != nil {
+		t.Fatal("NewReport() returned an error:", err)
+	}
+	for _, entry := range []parser.BillingLogs{
+		{Project: "web", Quantity: 100, Cost: 0.25, Currency: "USD"},
+		{Project: "web", Quantity: 100, Cost: 0.5, Currency: "USD"},
+		{Project: "ml", Quantity: 50, Currency: "USD"},
+	} {
+		entry := entry
+		if err = report.Add(&entry); err != nil {
+			t.Fatal("Add() returned an error:", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = report.Write(&buf, CSV, 0); err != nil {
+		t.Fatal("Write() returned an error:", err)
+	}
+	want := "project,requests,quantity,cost_usd\nweb,2,200,0.75\nml,1,50,0.00\n"
+	if buf.String() != want {
+		t.Errorf("Write() - Expected output:\n%q\ngot:\n%q", want, buf.String())
+	}
+
+	// Costs in different currencies can not be added up
+	entry := parser.BillingLogs{Project: "ml", Quantity: 50, Cost: 1, Currency: "EUR"}
+	if err = report.Add(&entry); err == nil {
+		t.Error("Add() - Expected error for a different currency")
+	}
+}
@@ -0,0 +1,131 @@
+package spill
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBytes is the default size limit of the spill file
+const DefaultMaxBytes = 1 << 30
+
+// ErrFull is returned when an entry does not fit in the spill file
+var ErrFull = errors.New("spill file is full")
+
+// Entry is an input line which is buffered on disk
+type Entry struct {
+	Offset int64
+	Line   string
+}
+
+// Queue is a first in, first out queue of input lines which is stored in a file
+// The file is emptied each time all entries were taken out
+// A Queue is not safe for concurrent use
+type Queue struct {
+	Path     string
+	MaxBytes int64
+
+	file   *os.File
+	input  *os.File
+	reader *bufio.Reader
+	size   int64
+	count  int
+}
+
+// Open creates the spill file at path and returns an empty Queue
+// Entries left in the file by a previous run are discarded, they are read again from the input
+func Open(path string, maxBytes int64) (*Queue, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %v", err)
+	}
+	input, err := os.Open(path)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open spill file: %v", err)
+	}
+
+	queue := &Queue{
+		Path:     path,
+		MaxBytes: maxBytes,
+		file:     file,
+		input:    input,
+		reader:   bufio.NewReader(input),
+	}
+	return queue, nil
+}
+
+// Push appends an entry to the end of the queue
+// It returns ErrFull when the entry would grow the file above MaxBytes
+func (q *Queue) Push(e Entry) error {
+	record := strconv.FormatInt(e.Offset, 10) + "\t" + e.Line + "\n"
+	if q.size+int64(len(record)) > q.MaxBytes {
+		return ErrFull
+	}
+	if _, err := q.file.WriteString(record); err != nil {
+		return fmt.Errorf("failed to write to spill file: %v", err)
+	}
+	q.size += int64(len(record))
+	q.count++
+	return nil
+}
+
+// Pop takes the first entry out of the queue
+// The second return value is false when the queue is empty
+func (q *Queue) Pop() (Entry, bool, error) {
+	if q.count == 0 {
+		return Entry{}, false, nil
+	}
+
+	record, err := q.reader.ReadString('\n')
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to read from spill file: %v", err)
+	}
+	q.count--
+	if q.count == 0 {
+		if err = q.reset(); err != nil {
+			return Entry{}, false, err
+		}
+	}
+
+	offset, line, _ := strings.Cut(strings.TrimSuffix(record, "\n"), "\t")
+	e := Entry{Line: line}
+	if e.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+		return Entry{}, false, fmt.Errorf("corrupt spill file entry: %v", err)
+	}
+	return e, true, nil
+}
+
+// Len returns the number of entries in the queue
+func (q *Queue) Len() int {
+	return q.count
+}
+
+// Close closes and removes the spill file
+func (q *Queue) Close() error {
+	q.input.Close()
+	if err := q.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(q.Path)
+}
+
+// reset empties the spill file after all entries were taken out
+func (q *Queue) reset() error {
+	if err := q.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate spill file: %v", err)
+	}
+	if _, err := q.input.Seek(0, 0); err != nil {
+		return fmt.Errorf("failed to rewind spill file: %v", err)
+	}
+	q.reader.Reset(q.input)
+	q.size = 0
+	return nil
+}
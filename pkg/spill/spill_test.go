@@ -0,0 +1,91 @@
+package spill
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spill.log")
+
+	// Entries left by a previous run are discarded
+	if err = os.WriteFile(path, []byte("10\tstale\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open() - Unexpected error: %v", err)
+	}
+	if queue.Len() != 0 {
+		t.Errorf("Open() - Expected empty queue, got: %d", queue.Len())
+	}
+
+	entries := []Entry{{Offset: 10, Line: "first|line"}, {Offset: 20, Line: "second\tline"}, {Offset: 30}}
+	for _, e := range entries[:2] {
+		if err = queue.Push(e); err != nil {
+			t.Fatalf("Push() - Unexpected error: %v", err)
+		}
+	}
+
+	// Entries pushed while the queue is read are taken out after the earlier ones
+	e, ok, err := queue.Pop()
+	if err != nil || !ok || e != entries[0] {
+		t.Errorf("Pop() - Expected entry: %v, got: %v, %v, %v", entries[0], e, ok, err)
+	}
+	if err = queue.Push(entries[2]); err != nil {
+		t.Fatalf("Push() - Unexpected error: %v", err)
+	}
+	for _, want := range entries[1:] {
+		e, ok, err = queue.Pop()
+		if err != nil || !ok || e != want {
+			t.Errorf("Pop() - Expected entry: %v, got: %v, %v, %v", want, e, ok, err)
+		}
+	}
+
+	// The file is emptied once all entries were taken out
+	if _, ok, _ = queue.Pop(); ok {
+		t.Error("Pop() - Expected empty queue")
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != 0 {
+		t.Errorf("Pop() - Expected empty spill file, got: %v, %v", info, err)
+	}
+
+	if err = queue.Close(); err != nil {
+		t.Errorf("Close() - Unexpected error: %v", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Close() - Expected spill file to be removed")
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := Open(filepath.Join(dir, "spill.log"), 20)
+	if err != nil {
+		t.Fatalf("Open() - Unexpected error: %v", err)
+	}
+	defer queue.Close()
+
+	if err = queue.Push(Entry{Offset: 10, Line: "0123456"}); err != nil {
+		t.Fatalf("Push() - Unexpected error: %v", err)
+	}
+	if err = queue.Push(Entry{Offset: 20, Line: "0123456"}); err != ErrFull {
+		t.Errorf("Push() - Expected error: %v, got: %v", ErrFull, err)
+	}
+	if queue.Len() != 1 {
+		t.Errorf("Push() - Expected 1 entry, got: %d", queue.Len())
+	}
+}
@@ -0,0 +1,605 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/checkpoint"
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+const (
+	// DefaultSegmentSize is the size after which a new segment file is started
+	DefaultSegmentSize = 64 << 20
+	// defaultRetryInterval is how long the first retry waits after the sink failed to write an entry
+	defaultRetryInterval = time.Second
+	// maxRetryInterval is the longest wait between retries
+	maxRetryInterval = 30 * time.Second
+	// syncInterval is how often the appended entries are flushed to disk
+	syncInterval = time.Second
+	// ackFile is the name of the file with the position of the first entry which was not written by the sink
+	ackFile = "ack.json"
+	// segmentSuffix is the file name suffix of the segment files
+	segmentSuffix = ".wal"
+)
+
+// Config contains the settings of a write-ahead log
+type Config struct {
+	Dir           string
+	SegmentSize   int64
+	RetryInterval time.Duration
+	InputQueue    chan writer.WriteRequest
+	OutputQueue   chan writer.WriteRequest
+	Acks          chan error
+	Logger        *slog.Logger
+}
+
+// Log is a write-ahead log between the workers and the sink
+// The billing log entries from the input queue are appended to segment files on disk and then sent
+// to the sink on the output queue one at a time. An entry is removed once the sink confirmed it on the acks channel,
+// otherwise it is sent again after a wait. Entries which were not confirmed before a crash are sent again on the next start
+type Log struct {
+	Dir           string
+	SegmentSize   int64
+	RetryInterval time.Duration
+	InputQueue    chan writer.WriteRequest
+	OutputQueue   chan writer.WriteRequest
+	Acks          chan error
+	Logger        *slog.Logger
+
+	mu sync.Mutex
+	// segments are the start positions of the segment files in order, the last one is appended to
+	segments []int64
+	file     *os.File
+	// head is the position after the last appended entry and ack the position of the first entry not confirmed by the sink
+	head    int64
+	ack     int64
+	pending int
+	// appended, synced and written are input offsets of the entries which were appended,
+	// flushed to disk and confirmed by the sink
+	appended int64
+	synced   int64
+	written  int64
+	closed   bool
+
+	wake   chan struct{}
+	abort  chan struct{}
+	done   chan struct{}
+	fed    chan struct{}
+	failed int
+}
+
+// position is the persisted position of the first entry which was not confirmed by the sink
+type position struct {
+	Ack int64 `json:"ack"`
+}
+
+// Open opens the write-ahead log in the directory and recovers the entries which were not confirmed by the sink
+// A partial entry at the end of the log, left by a crash while it was appended, is removed
+func Open(c Config) (*Log, error) {
+	if c.SegmentSize <= 0 {
+		c.SegmentSize = DefaultSegmentSize
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = defaultRetryInterval
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log directory: %v", err)
+	}
+
+	l := &Log{
+		Dir:           c.Dir,
+		SegmentSize:   c.SegmentSize,
+		RetryInterval: c.RetryInterval,
+		InputQueue:    c.InputQueue,
+		OutputQueue:   c.OutputQueue,
+		Acks:          c.Acks,
+		Logger:        c.Logger,
+		wake:          make(chan struct{}, 1),
+		abort:         make(chan struct{}),
+		done:          make(chan struct{}),
+		fed:           make(chan struct{}),
+	}
+	if err := l.restore(); err != nil {
+		return nil, err
+	}
+	metrics.WALPending.Set(int64(l.pending))
+	return l, nil
+}
+
+// Start starts appending the entries of the input queue and sending them to the sink
+func (l *Log) Start() {
+	go l.run()
+	go l.feed()
+}
+
+// Stop closes the input queue and waits until all entries were confirmed by the sink or the context is done
+// The entries which were appended but not confirmed stay in the log and are sent again on the next start
+// It returns the number of requests of the input queue which were not appended and the context error when the sink did not catch up in time
+func (l *Log) Stop(ctx context.Context) (int, error) {
+	close(l.InputQueue)
+
+	var err error
+	select {
+	case <-l.fed:
+		<-l.done
+	case <-ctx.Done():
+		err = ctx.Err()
+		close(l.abort)
+		<-l.fed
+		<-l.done
+	}
+
+	abandoned := 0
+	for range l.InputQueue {
+		abandoned++
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending > 0 {
+		l.Logger.Warn("entries were not written and are kept in the write-ahead log for the next start", "entries", l.pending)
+	}
+	if serr := l.sync(); serr != nil {
+		l.Logger.Error("failed to flush write-ahead log", "error", serr)
+	}
+	if cerr := l.file.Close(); cerr != nil {
+		l.Logger.Error("failed to close write-ahead log", "error", cerr)
+	}
+	return abandoned, err
+}
+
+// Checkpoint returns the input offset up to which the entries were flushed to disk
+func (l *Log) Checkpoint() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.synced
+}
+
+// Written returns the input offset up to which the entries were written by the sink
+func (l *Log) Written() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == 0 {
+		return l.appended
+	}
+	return l.written
+}
+
+// Pending returns the number of entries which were not confirmed by the sink
+func (l *Log) Pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pending
+}
+
+// run appends the entries of the input queue to the log and flushes them to disk every sync interval
+func (l *Log) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case request, ok := <-l.InputQueue:
+			if !ok {
+				l.mu.Lock()
+				l.closed = true
+				l.mu.Unlock()
+				l.signal()
+				return
+			}
+			if !l.append(request) {
+				return
+			}
+		case <-ticker.C:
+			l.mu.Lock()
+			if err := l.sync(); err != nil {
+				l.Logger.Error("failed to flush write-ahead log", "error", err)
+			}
+			l.mu.Unlock()
+		case <-l.abort:
+			return
+		}
+	}
+}
+
+// append appends an entry to the log, retrying until it succeeds
+// Requests without a line only advance the input offset
+// It returns false when the log was aborted before the entry was appended
+func (l *Log) append(request writer.WriteRequest) bool {
+	if request.Line == "" {
+		l.mu.Lock()
+		if request.Offset > 0 {
+			l.appended = request.Offset
+		}
+		l.mu.Unlock()
+		return true
+	}
+
+	record := strconv.FormatInt(request.Offset, 10) + "\t" + request.Line + "\n"
+	for wait := l.RetryInterval; ; wait = backoff(wait) {
+		l.mu.Lock()
+		err := l.write(record)
+		if err == nil {
+			if request.Offset > 0 {
+				l.appended = request.Offset
+			}
+			l.pending++
+			metrics.WALPending.Set(int64(l.pending))
+		}
+		l.mu.Unlock()
+		if err == nil {
+			l.signal()
+			return true
+		}
+
+		l.Logger.Error("failed to append to write-ahead log, retrying", "wait", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-l.abort:
+			return false
+		}
+	}
+}
+
+// write writes a record to the last segment and starts a new segment when it is full
+// A partially written record is removed again
+// The log must be locked by the caller
+func (l *Log) write(record string) error {
+	start := l.segments[len(l.segments)-1]
+	if l.head-start >= l.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+		start = l.head
+	}
+
+	if _, err := l.file.WriteString(record); err != nil {
+		if terr := l.file.Truncate(l.head - start); terr != nil {
+			l.Logger.Error("failed to remove partial write-ahead log entry", "error", terr)
+		}
+		return err
+	}
+	l.head += int64(len(record))
+	return nil
+}
+
+// rotate flushes the last segment and starts a new one at the head of the log
+// The log must be locked by the caller
+func (l *Log) rotate() error {
+	file, err := os.OpenFile(l.segmentPath(l.head), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err = l.sync(); err != nil {
+		l.Logger.Error("failed to flush write-ahead log", "error", err)
+	}
+	if err = l.file.Close(); err != nil {
+		l.Logger.Error("failed to close write-ahead log segment", "error", err)
+	}
+	l.file = file
+	l.segments = append(l.segments, l.head)
+	return nil
+}
+
+// feed sends the appended entries to the sink in order and waits until the sink confirmed each of them
+func (l *Log) feed() {
+	defer close(l.fed)
+
+	l.mu.Lock()
+	pos := l.ack
+	l.mu.Unlock()
+
+	var r *segmentReader
+	defer func() {
+		if r != nil {
+			r.Close()
+		}
+	}()
+
+	for {
+		l.mu.Lock()
+		head, closed, start := l.head, l.closed, l.segmentStart(pos)
+		l.mu.Unlock()
+
+		// wait for new entries
+		if pos == head {
+			if closed {
+				return
+			}
+			select {
+			case <-l.wake:
+				continue
+			case <-l.abort:
+				return
+			}
+		}
+
+		// move on to the segment which contains the next entry
+		if r == nil || r.start != start {
+			if r != nil {
+				r.Close()
+			}
+			var err error
+			if r, err = openSegment(l.segmentPath(start), start, pos); err != nil {
+				l.Logger.Error("failed to read write-ahead log, retrying", "wait", l.RetryInterval, "error", err)
+				r = nil
+				select {
+				case <-time.After(l.RetryInterval):
+					continue
+				case <-l.abort:
+					return
+				}
+			}
+		}
+
+		record, err := r.reader.ReadString('\n')
+		if err != nil {
+			l.Logger.Error("failed to read write-ahead log, retrying", "wait", l.RetryInterval, "error", err)
+			r.Close()
+			r = nil
+			select {
+			case <-time.After(l.RetryInterval):
+				continue
+			case <-l.abort:
+				return
+			}
+		}
+		request, err := parseRecord(record)
+		if err != nil {
+			l.Logger.Warn("skipping corrupt write-ahead log entry", "position", pos, "error", err)
+		} else if !l.deliver(request) {
+			return
+		}
+
+		pos += int64(len(record))
+		l.confirm(pos, request.Offset)
+	}
+}
+
+// deliver sends an entry to the sink until the sink confirms it was written
+// It returns false when the log was aborted before that
+func (l *Log) deliver(request writer.WriteRequest) bool {
+	for wait := l.RetryInterval; ; wait = backoff(wait) {
+		select {
+		case l.OutputQueue <- request:
+		case <-l.abort:
+			return false
+		}
+
+		var err error
+		select {
+		case err = <-l.Acks:
+		case <-l.abort:
+			return false
+		}
+		if err == nil {
+			if l.failed > 0 {
+				l.Logger.Info("sink recovered", "failed_writes", l.failed)
+				l.failed = 0
+			}
+			return true
+		}
+
+		l.failed++
+		metrics.WALRetries.Add(1)
+		l.Logger.Error("sink failed to write entry, retrying", "wait", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-l.abort:
+			return false
+		}
+	}
+}
+
+// confirm removes the entries before the position and the segments which only contain such entries
+func (l *Log) confirm(pos int64, offset int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ack = pos
+	l.pending--
+	if offset > 0 {
+		l.written = offset
+	}
+	metrics.WALPending.Set(int64(l.pending))
+
+	removed := false
+	for len(l.segments) > 1 && l.segments[1] <= pos {
+		if err := os.Remove(l.segmentPath(l.segments[0])); err != nil {
+			l.Logger.Error("failed to remove write-ahead log segment", "error", err)
+		}
+		l.segments = l.segments[1:]
+		removed = true
+	}
+	if removed {
+		if err := l.saveAck(); err != nil {
+			l.Logger.Error("failed to persist write-ahead log position", "error", err)
+		}
+	}
+}
+
+// sync flushes the appended entries to disk and persists the position of the first entry not confirmed by the sink
+// The log must be locked by the caller
+func (l *Log) sync() error {
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.synced = l.appended
+	return l.saveAck()
+}
+
+// saveAck persists the position of the first entry which was not confirmed by the sink
+// The log must be locked by the caller
+func (l *Log) saveAck() error {
+	data, err := json.Marshal(position{Ack: l.ack})
+	if err != nil {
+		return err
+	}
+
+	return checkpoint.WriteFile(filepath.Join(l.Dir, ackFile), data)
+}
+
+// restore finds the segment files and the position of the first entry not confirmed by the sink
+// and counts the entries after it
+func (l *Log) restore() error {
+	data, err := os.ReadFile(filepath.Join(l.Dir, ackFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read write-ahead log position: %v", err)
+	}
+	if err == nil {
+		var p position
+		if err = json.Unmarshal(data, &p); err != nil {
+			return fmt.Errorf("failed to parse write-ahead log position: %v", err)
+		}
+		l.ack = p.Ack
+	}
+
+	files, err := filepath.Glob(filepath.Join(l.Dir, "*"+segmentSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list write-ahead log segments: %v", err)
+	}
+	for _, file := range files {
+		start, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(file), segmentSuffix), 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected file in write-ahead log directory: %v", file)
+		}
+		l.segments = append(l.segments, start)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+
+	// remove the segments which only contain confirmed entries
+	for len(l.segments) > 1 && l.segments[1] <= l.ack {
+		if err = os.Remove(l.segmentPath(l.segments[0])); err != nil {
+			return fmt.Errorf("failed to remove write-ahead log segment: %v", err)
+		}
+		l.segments = l.segments[1:]
+	}
+	if len(l.segments) == 0 {
+		l.segments = []int64{l.ack}
+	}
+	if l.ack < l.segments[0] {
+		l.ack = l.segments[0]
+	}
+
+	// count the entries which were not confirmed and remove a partial entry at the end
+	for i, start := range l.segments {
+		data, err := os.ReadFile(l.segmentPath(start))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read write-ahead log segment: %v", err)
+		}
+		complete := int64(bytes.LastIndexByte(data, '\n') + 1)
+		if i == len(l.segments)-1 && complete < int64(len(data)) {
+			l.Logger.Warn("removing partial entry at the end of the write-ahead log")
+			if err = os.Truncate(l.segmentPath(start), complete); err != nil {
+				return fmt.Errorf("failed to remove partial write-ahead log entry: %v", err)
+			}
+		}
+		skip := int64(0)
+		if l.ack > start {
+			skip = l.ack - start
+		}
+		if skip < complete {
+			l.pending += bytes.Count(data[skip:complete], []byte("\n"))
+		}
+		l.head = start + complete
+	}
+	if l.pending > 0 {
+		l.Logger.Info("recovered entries from the write-ahead log which were not written", "entries", l.pending)
+	}
+
+	last := l.segments[len(l.segments)-1]
+	if l.file, err = os.OpenFile(l.segmentPath(last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return fmt.Errorf("failed to open write-ahead log segment: %v", err)
+	}
+	return nil
+}
+
+// segmentStart returns the start of the segment which contains the position
+// The log must be locked by the caller
+func (l *Log) segmentStart(pos int64) int64 {
+	start := l.segments[0]
+	for _, s := range l.segments {
+		if s > pos {
+			break
+		}
+		start = s
+	}
+	return start
+}
+
+// segmentPath returns the path of the segment file starting at the position
+func (l *Log) segmentPath(start int64) string {
+	return filepath.Join(l.Dir, fmt.Sprintf("%020d%s", start, segmentSuffix))
+}
+
+// signal wakes up the feeder
+func (l *Log) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// segmentReader reads the entries of a segment file
+type segmentReader struct {
+	start  int64
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// openSegment opens the segment file starting at start for reading from the position
+func openSegment(path string, start, pos int64) (*segmentReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(pos-start, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &segmentReader{start: start, file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// Close closes the segment file
+func (r *segmentReader) Close() error {
+	return r.file.Close()
+}
+
+// parseRecord parses an entry of a segment file
+func parseRecord(record string) (writer.WriteRequest, error) {
+	offset, line, found := strings.Cut(strings.TrimSuffix(record, "\n"), "\t")
+	if !found {
+		return writer.WriteRequest{}, fmt.Errorf("missing offset")
+	}
+	request := writer.WriteRequest{Line: line}
+	var err error
+	if request.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+		return writer.WriteRequest{}, fmt.Errorf("invalid offset: %v", err)
+	}
+	return request, nil
+}
+
+// backoff doubles the wait between retries up to the maximum
+func backoff(wait time.Duration) time.Duration {
+	wait *= 2
+	if wait > maxRetryInterval {
+		wait = maxRetryInterval
+	}
+	return wait
+}
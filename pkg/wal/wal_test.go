@@ -0,0 +1,174 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+// mockSink reads the output queue of a log and confirms each entry with the next error in errs
+func mockSink(output chan writer.WriteRequest, acks chan error, errs []error) chan []writer.WriteRequest {
+	result := make(chan []writer.WriteRequest, 1)
+	go func() {
+		var written []writer.WriteRequest
+		for request := range output {
+			var err error
+			if len(errs) > 0 {
+				err, errs = errs[0], errs[1:]
+			}
+			if err == nil {
+				written = append(written, request)
+			}
+			acks <- err
+		}
+		result <- written
+	}()
+	return result
+}
+
+func openLog(t *testing.T, dir string, output chan writer.WriteRequest, acks chan error) *Log {
+	l, err := Open(Config{
+		Dir:           dir,
+		SegmentSize:   30,
+		RetryInterval: time.Millisecond,
+		InputQueue:    make(chan writer.WriteRequest, 10),
+		OutputQueue:   output,
+		Acks:          acks,
+		Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	if err != nil {
+		t.Fatalf("Open() - Unexpected error: %v", err)
+	}
+	return l
+}
+
+func entries(n int) []writer.WriteRequest {
+	var requests []writer.WriteRequest
+	for i := 1; i <= n; i++ {
+		requests = append(requests, writer.WriteRequest{Line: fmt.Sprintf("entry %d", i), Offset: int64(i * 10)})
+	}
+	return requests
+}
+
+func TestLog_Deliver(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output, acks := make(chan writer.WriteRequest), make(chan error)
+	written := mockSink(output, acks, []error{nil, fmt.Errorf("disk full"), fmt.Errorf("disk full")})
+
+	l := openLog(t, dir, output, acks)
+	l.Start()
+
+	// Requests without a line are not sent to the sink and the failed entry is sent again
+	want := entries(5)
+	for _, request := range want[:3] {
+		l.InputQueue <- request
+	}
+	l.InputQueue <- writer.WriteRequest{Offset: 35}
+	for _, request := range want[3:] {
+		l.InputQueue <- request
+	}
+
+	if abandoned, err := l.Stop(context.Background()); abandoned != 0 || err != nil {
+		t.Errorf("Log.Stop() - Expected no abandoned requests, got: %d, %v", abandoned, err)
+	}
+	close(output)
+
+	if got := <-written; !reflect.DeepEqual(got, want) {
+		t.Errorf("Log.Start() - Expected written entries: %v, got: %v", want, got)
+	}
+	if l.Pending() != 0 {
+		t.Errorf("Log.Pending() - Expected no pending entries, got: %d", l.Pending())
+	}
+	if l.Checkpoint() != 50 || l.Written() != 50 {
+		t.Errorf("Log.Checkpoint() - Expected offset: 50, got: %d, %d", l.Checkpoint(), l.Written())
+	}
+
+	// The segments of the confirmed entries are removed
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 1 {
+		t.Errorf("Log.Start() - Expected 1 segment, got: %v", segments)
+	}
+}
+
+func TestLog_Replay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The sink confirms the first entry and then stops reading
+	output, acks := make(chan writer.WriteRequest), make(chan error)
+	go func() {
+		<-output
+		acks <- nil
+	}()
+
+	l := openLog(t, dir, output, acks)
+	l.Start()
+	want := entries(4)
+	for _, request := range want {
+		l.InputQueue <- request
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = l.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Log.Stop() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if l.Pending() != 3 {
+		t.Errorf("Log.Pending() - Expected 3 pending entries, got: %d", l.Pending())
+	}
+
+	// The entries appended before the stop are durable even though they were not written
+	if l.Checkpoint() != 40 {
+		t.Errorf("Log.Checkpoint() - Expected offset: 40, got: %d", l.Checkpoint())
+	}
+
+	// Simulate a crash while an entry was appended
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	file, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("50\tpartial")
+	file.Close()
+
+	// The entries which were not confirmed are sent again after a restart
+	output, acks = make(chan writer.WriteRequest), make(chan error)
+	written := mockSink(output, acks, nil)
+
+	l = openLog(t, dir, output, acks)
+	if l.Pending() != 3 {
+		t.Errorf("Open() - Expected 3 recovered entries, got: %d", l.Pending())
+	}
+	l.Start()
+	l.InputQueue <- writer.WriteRequest{Line: "entry 5", Offset: 50}
+	if _, err = l.Stop(context.Background()); err != nil {
+		t.Errorf("Log.Stop() - Unexpected error: %v", err)
+	}
+	close(output)
+
+	want = append(want[1:], writer.WriteRequest{Line: "entry 5", Offset: 50})
+	if got := <-written; !reflect.DeepEqual(got, want) {
+		t.Errorf("Log.Start() - Expected written entries: %v, got: %v", want, got)
+	}
+}
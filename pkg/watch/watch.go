@@ -0,0 +1,46 @@
+package watch
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// File checks the file at path for changes every interval and calls reload when it was modified
+// Errors are logged and the file is checked again on the next interval
+// It runs in the background until the context is done
+func File(ctx context.Context, path string, interval time.Duration, reload func() error, logger *slog.Logger) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					logger.Error("failed to check file for changes", "file", path, "error", err)
+					continue
+				}
+				if info.ModTime().Equal(modTime) {
+					continue
+				}
+				modTime = info.ModTime()
+
+				if err = reload(); err != nil {
+					logger.Error("failed to reload file, keeping the previous version", "file", path, "error", err)
+					continue
+				}
+				logger.Info("reloaded file", "file", path)
+			}
+		}
+	}()
+}
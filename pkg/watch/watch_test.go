@@ -0,0 +1,69 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type MockLogger struct{}
+
+func (l *MockLogger) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func TestFile(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "watched")
+	if err = os.WriteFile(path, []byte("1"), 0644); err != nil {
+		t.Fatal("Failed to write file:", err)
+	}
+
+	var reloads int32
+	reload := func() error {
+		atomic.AddInt32(&reloads, 1)
+		return errors.New("reload failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	File(ctx, path, 10*time.Millisecond, reload, slog.New(slog.NewTextHandler(&MockLogger{}, nil)))
+
+	// An unmodified file should not be reloaded
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&reloads); n != 0 {
+		t.Fatalf("File() - Expected no reloads for an unmodified file, got: %d", n)
+	}
+
+	// Modify the file, it should be reloaded once even if the reload fails
+	modTime := time.Now().Add(time.Second)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal("Failed to change file times:", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&reloads); n != 1 {
+		t.Fatalf("File() - Expected 1 reload for a modified file, got: %d", n)
+	}
+
+	// After the context is done the file should not be reloaded anymore
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	modTime = modTime.Add(time.Second)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal("Failed to change file times:", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&reloads); n != 1 {
+		t.Errorf("File() - Expected no reloads after the context is done, got: %d", n)
+	}
+}
@@ -1,15 +1,58 @@
 package worker
 
+import "context"
+
 // Collector receives log entries and builds a work request for the workers and sends it in the WorkQueue
-func Collector(line string, format LogFormat, workQueue chan WorkRequest) {
-	// build the work requests for the workers
-	work := WorkRequest{
+// Each work request gets the next sequence number from the reorder buffer, which blocks while the buffer is full
+// Without a reorder buffer the work requests have no sequence number and their results are not reordered
+// The offset is the position in the input file after the line, which is used for checkpointing the written lines
+// It returns the context error when the context is done before the work request was sent
+func Collector(ctx context.Context, line string, offset int64, format LogFormat, reorderer *Reorderer, workQueue chan WorkRequest) error {
+	var sequence uint64
+	if reorderer != nil {
+		var err error
+		if sequence, err = reorderer.Reserve(ctx); err != nil {
+			return err
+		}
+	}
+
+	// send the work request to the work queue to be picked up by the workers
+	select {
+	case workQueue <- newWorkRequest(sequence, line, offset, format):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryCollector is like Collector but returns false without waiting when the work queue or the reorder buffer is full
+// It requires a buffered work queue and must be the only sender to it
+func TryCollector(line string, offset int64, format LogFormat, reorderer *Reorderer, workQueue chan WorkRequest) bool {
+	if len(workQueue) >= cap(workQueue) {
+		return false
+	}
+
+	var sequence uint64
+	if reorderer != nil {
+		var ok bool
+		if sequence, ok = reorderer.TryReserve(); !ok {
+			return false
+		}
+	}
+
+	// there is room in the queue, so sending does not wait
+	workQueue <- newWorkRequest(sequence, line, offset, format)
+	return true
+}
+
+// newWorkRequest builds the work request for the workers
+func newWorkRequest(sequence uint64, line string, offset int64, format LogFormat) WorkRequest {
+	return WorkRequest{
+		Sequence:  sequence,
+		Offset:    offset,
 		Line:      line,
 		Delimiter: format.Delimiter,
 		NumFields: format.NumFields,
+		Fields:    format.Fields,
 	}
-
-	// send the work request to the work queue to be picked up by the workers
-	workQueue <- work
-	return
 }
@@ -1,9 +1,33 @@
 package worker
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
 )
 
+func TestCollector_Sequence(t *testing.T) {
+	workQueue := make(chan WorkRequest, 2)
+	reorderer := NewReorderer(Reorderer{MaxPending: 2})
+	format := LogFormat{
+		Delimiter: "|",
+		NumFields: 3,
+	}
+
+	// Each work request should get the next sequence number
+	Collector(context.Background(), "first", 6, format, reorderer, workQueue)
+	Collector(context.Background(), "second", 13, format, reorderer, workQueue)
+
+	for i, want := range []uint64{1, 2} {
+		work := <-workQueue
+		if work.Sequence != want {
+			t.Errorf("Collector() - Expected sequence of request %d: %d, got: %d", i, want, work.Sequence)
+		}
+	}
+}
+
 func TestCollector(t *testing.T) {
 	// Create a channel for work requests
 	workQueue := make(chan WorkRequest, 1)
@@ -13,10 +37,11 @@ func TestCollector(t *testing.T) {
 	format := LogFormat{
 		Delimiter: "|",
 		NumFields: 3,
+		Fields:    parser.FieldMap{parser.FieldTimestamp: 0},
 	}
 
 	// Call the Collector function
-	Collector(line, format, workQueue)
+	Collector(context.Background(), line, 17, format, nil, workQueue)
 
 	// Check if the work request was added to the work queue
 	select {
@@ -25,13 +50,56 @@ func TestCollector(t *testing.T) {
 		if work.Line != line {
 			t.Errorf("Collector() - Expected line: %s, got: %s", line, work.Line)
 		}
+		if work.Offset != 17 {
+			t.Errorf("Collector() - Expected offset: %d, got: %d", 17, work.Offset)
+		}
 		if work.Delimiter != format.Delimiter {
 			t.Errorf("Collector() - Expected delimiter: %s, got: %s", format.Delimiter, work.Delimiter)
 		}
 		if work.NumFields != format.NumFields {
 			t.Errorf("Collector() - Expected numFields: %d, got: %d", format.NumFields, work.NumFields)
 		}
+		if work.Fields[parser.FieldTimestamp] != 0 || len(work.Fields) != len(format.Fields) {
+			t.Errorf("Collector() - Expected fields: %v, got: %v", format.Fields, work.Fields)
+		}
 	default:
 		t.Error("Collector() - Work request was not added to the work queue")
 	}
 }
+
+func TestTryCollector(t *testing.T) {
+	workQueue := make(chan WorkRequest, 1)
+	reorderer := NewReorderer(Reorderer{MaxPending: 2})
+	format := LogFormat{Delimiter: "|", NumFields: 3}
+
+	if !TryCollector("first", 6, format, reorderer, workQueue) {
+		t.Fatal("TryCollector() - Expected work request to be sent")
+	}
+
+	// The work queue is full
+	if TryCollector("second", 13, format, reorderer, workQueue) {
+		t.Error("TryCollector() - Expected work request not to be sent while the work queue is full")
+	}
+
+	// The reorder buffer is full
+	<-workQueue
+	reorderer.Reserve(context.Background())
+	if TryCollector("second", 13, format, reorderer, workQueue) {
+		t.Error("TryCollector() - Expected work request not to be sent while the reorder buffer is full")
+	}
+	if len(workQueue) != 0 {
+		t.Errorf("TryCollector() - Expected empty work queue, got: %d", len(workQueue))
+	}
+}
+
+func TestCollector_Cancel(t *testing.T) {
+	workQueue := make(chan WorkRequest)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Nobody reads the work queue
+	err := Collector(ctx, "line", 5, LogFormat{Delimiter: "|", NumFields: 3}, nil, workQueue)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Collector() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+}
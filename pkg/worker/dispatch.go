@@ -1,7 +1,28 @@
 package worker
 
+import (
+	"fmt"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+)
+
 // LogFormat contains the type of the input log format
 type LogFormat struct {
 	Delimiter string
 	NumFields int
+	Fields    parser.FieldMap
+}
+
+// Validate checks that the log format can be used for parsing log lines
+func (f LogFormat) Validate() error {
+	if f.Delimiter == "" {
+		return fmt.Errorf("delimiter must not be empty")
+	}
+	if f.NumFields <= 0 {
+		return fmt.Errorf("number of fields must be greater than 0, got %d", f.NumFields)
+	}
+	if err := f.Fields.Validate(f.NumFields); err != nil {
+		return fmt.Errorf("invalid field mapping: %v", err)
+	}
+	return nil
 }
@@ -1,51 +1,307 @@
 package worker
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+const (
+	// highWaterMark is the fraction of the work queue capacity above which the queue is considered busy
+	highWaterMark = 0.8
+	// scaleUpChecks is the number of consecutive busy checks after which a worker is added
+	scaleUpChecks = 3
+	// scaleDownChecks is the number of consecutive idle checks after which a worker is removed
+	scaleDownChecks = 10
+	// defaultScaleInterval is how often the work queue depth is checked
+	defaultScaleInterval = time.Second
 )
 
 // Dispatcher describes a dispatcher
 type Dispatcher struct {
-	ServerName  string
-	Workers     int
-	WorkQueue   chan WorkRequest
-	OutputQueue chan string
-	WaitGroup   *sync.WaitGroup
-	Logger      *log.Logger
+	ServerName      string
+	Location        *time.Location
+	Stages          []Stage
+	Schema          *parser.Schema
+	Workers         int
+	MinWorkers      int
+	MaxWorkers      int
+	ScaleInterval   time.Duration
+	WorkQueue       chan WorkRequest
+	OutputQueue     chan Result
+	DeadLetterQueue chan writer.WriteRequest
+	WaitGroup       *sync.WaitGroup
+	Logger          *slog.Logger
+	// WorkerLogger is the logger of the workers, the dispatcher logger is used when it is not set
+	WorkerLogger *slog.Logger
+
+	pool *pool
+}
+
+// Settings contains the dispatcher settings which can be changed while it is running
+type Settings struct {
+	ServerName string
+	Location   *time.Location
+	Stages     []Stage
+	Workers    int
+	MinWorkers int
+	MaxWorkers int
+}
+
+// pool tracks the running workers of a dispatcher
+type pool struct {
+	mu      sync.Mutex
+	workers []*Worker
+	nextID  int
+	quit    chan struct{}
+	// stopped is set by Stop, after which no worker is started anymore
+	stopped bool
+
+	// ctx is passed to the workers and cancel stops them without finishing the queued work requests
+	ctx       context.Context
+	cancel    context.CancelFunc
+	abandoned int64
 }
 
 // NewDispatcher creates and returns a Dispatcher object
+// When MaxWorkers is set, the pool is scaled between MinWorkers and MaxWorkers based on the work queue depth
 func NewDispatcher(d Dispatcher) *Dispatcher {
+	if d.ScaleInterval <= 0 {
+		d.ScaleInterval = defaultScaleInterval
+	}
+	if d.WorkerLogger == nil {
+		d.WorkerLogger = d.Logger
+	}
+
 	dispatcher := &Dispatcher{
-		ServerName:  d.ServerName,
-		Workers:     d.Workers,
-		WorkQueue:   d.WorkQueue,
-		OutputQueue: d.OutputQueue,
-		WaitGroup:   d.WaitGroup,
-		Logger:      d.Logger,
+		ServerName:      d.ServerName,
+		Location:        d.Location,
+		Stages:          d.Stages,
+		Schema:          d.Schema,
+		Workers:         d.Workers,
+		MinWorkers:      d.MinWorkers,
+		MaxWorkers:      d.MaxWorkers,
+		ScaleInterval:   d.ScaleInterval,
+		WorkQueue:       d.WorkQueue,
+		OutputQueue:     d.OutputQueue,
+		DeadLetterQueue: d.DeadLetterQueue,
+		WaitGroup:       d.WaitGroup,
+		Logger:          d.Logger,
+		WorkerLogger:    d.WorkerLogger,
+		pool:            &pool{quit: make(chan struct{}), ctx: context.Background(), cancel: func() {}},
 	}
 	return dispatcher
 }
 
 // Start starts the workers, dispatches the work to them and initializes
 // the writer, who listens on a channel where the workers send their finished work
-func (d *Dispatcher) Start() {
-	go func() {
-		// start the workers
-		for i := 0; i < d.Workers; i++ {
-			d.WaitGroup.Add(1)
-			worker := NewWorker(i+1, d.ServerName, d.WorkQueue, d.OutputQueue, d.WaitGroup, d.Logger)
-			worker.Start()
+// When the context is done the workers stop without finishing the queued work requests
+// The workers are started before it returns, so Stop always waits for them
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.pool.mu.Lock()
+	d.pool.ctx, d.pool.cancel = context.WithCancel(ctx)
+	// start the workers
+	for i := 0; i < d.Workers; i++ {
+		d.startWorker()
+	}
+	metrics.Workers.Set(int64(len(d.pool.workers)))
+	d.pool.mu.Unlock()
+
+	go d.autoscale()
+}
+
+// SetWorkers resizes the worker pool to n workers
+// When autoscaling is enabled n is kept between MinWorkers and MaxWorkers, and at least one worker is always kept
+// Removed workers finish the work request they are processing, queued work requests
+// are left for the remaining workers
+func (d *Dispatcher) SetWorkers(n int) {
+	d.pool.mu.Lock()
+	if d.pool.stopped {
+		d.pool.mu.Unlock()
+		return
+	}
+	if d.MaxWorkers > 0 {
+		if n > d.MaxWorkers {
+			n = d.MaxWorkers
 		}
-	}()
+		if n < d.MinWorkers {
+			n = d.MinWorkers
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	d.Workers = n
+	for len(d.pool.workers) < n {
+		d.startWorker()
+	}
+	var removed []*Worker
+	if len(d.pool.workers) > n {
+		removed = d.pool.workers[n:]
+		d.pool.workers = d.pool.workers[:n]
+	}
+	metrics.Workers.Set(int64(len(d.pool.workers)))
+	d.pool.mu.Unlock()
+
+	stopWorkers(removed)
+}
+
+// Reconfigure replaces the workers with new ones using the new settings
+// The new workers are started before the old ones are stopped so no queued work request is dropped
+// It returns after the old workers finished the work requests they were processing
+func (d *Dispatcher) Reconfigure(s Settings) {
+	d.pool.mu.Lock()
+	d.ServerName = s.ServerName
+	d.Location = s.Location
+	d.Stages = s.Stages
+	d.Workers = s.Workers
+	d.MinWorkers = s.MinWorkers
+	d.MaxWorkers = s.MaxWorkers
+
+	old := d.pool.workers
+	d.pool.workers = nil
+	for i := 0; i < d.Workers; i++ {
+		d.startWorker()
+	}
+	metrics.Workers.Set(int64(len(d.pool.workers)))
+	d.pool.mu.Unlock()
+
+	stopWorkers(old)
+}
+
+// Size returns the number of running workers
+func (d *Dispatcher) Size() int {
+	d.pool.mu.Lock()
+	defer d.pool.mu.Unlock()
+	return len(d.pool.workers)
 }
 
 // Stop closes the work channels and triggers the workers to stop gracefully
-func (d *Dispatcher) Stop() {
+// The workers drain the work queue until the context is done, then they are stopped right away
+// It returns the number of work requests which were abandoned and the context error when the workers did not finish in time
+func (d *Dispatcher) Stop(ctx context.Context) (int, error) {
+	// stop scaling the pool, no worker is added to the wait group while it is waited for
+	d.pool.mu.Lock()
+	d.pool.stopped = true
+	d.pool.mu.Unlock()
+	close(d.pool.quit)
+
 	// close the work queue
 	close(d.WorkQueue)
 
 	// wait for all workers to finish
-	d.WaitGroup.Wait()
+	done := make(chan struct{})
+	go func() {
+		d.WaitGroup.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		d.pool.mu.Lock()
+		d.pool.cancel()
+		d.pool.mu.Unlock()
+		<-done
+	}
+	d.pool.cancel()
+
+	// the work requests left in the closed queue will never be processed
+	abandoned := int(atomic.LoadInt64(&d.pool.abandoned))
+	for range d.WorkQueue {
+		abandoned++
+	}
+	return abandoned, err
+}
+
+// autoscale checks the work queue depth every scale interval and adds a worker when the queue
+// stays above the high-water mark or removes one when the queue stays empty
+func (d *Dispatcher) autoscale() {
+	ticker := time.NewTicker(d.ScaleInterval)
+	defer ticker.Stop()
+
+	busy, idle := 0, 0
+	for {
+		select {
+		case <-d.pool.quit:
+			return
+		case <-ticker.C:
+			depth := len(d.WorkQueue)
+			metrics.WorkQueueDepth.Set(int64(depth))
+
+			d.pool.mu.Lock()
+			minWorkers, maxWorkers, size := d.MinWorkers, d.MaxWorkers, len(d.pool.workers)
+			d.pool.mu.Unlock()
+
+			// autoscaling is disabled without a maximum number of workers
+			if maxWorkers <= 0 {
+				busy, idle = 0, 0
+				continue
+			}
+
+			switch {
+			case depth > 0 && float64(depth) >= highWaterMark*float64(cap(d.WorkQueue)):
+				busy, idle = busy+1, 0
+			case depth == 0:
+				busy, idle = 0, idle+1
+			default:
+				busy, idle = 0, 0
+			}
+
+			if busy >= scaleUpChecks && size < maxWorkers {
+				d.Logger.Info("work queue is above the high-water mark, scaling workers up", "depth", depth, "workers", size+1)
+				d.SetWorkers(size + 1)
+				busy = 0
+			}
+			if idle >= scaleDownChecks && size > minWorkers {
+				d.Logger.Info("work queue is idle, scaling workers down", "workers", size-1)
+				d.SetWorkers(size - 1)
+				idle = 0
+			}
+		}
+	}
+}
+
+// startWorker starts a new worker with the current settings and adds it to the pool
+// No worker is started after the dispatcher was stopped
+// The pool must be locked by the caller
+func (d *Dispatcher) startWorker() {
+	if d.pool.stopped {
+		return
+	}
+	d.pool.nextID++
+	worker := NewWorker(Worker{
+		ID:              d.pool.nextID,
+		ServerName:      d.ServerName,
+		Location:        d.Location,
+		Stages:          d.Stages,
+		Schema:          d.Schema,
+		WorkQueue:       d.WorkQueue,
+		OutputQueue:     d.OutputQueue,
+		DeadLetterQueue: d.DeadLetterQueue,
+		WaitGroup:       d.WaitGroup,
+		Logger:          d.WorkerLogger.With("worker", d.pool.nextID),
+	})
+	worker.abandoned = &d.pool.abandoned
+	d.WaitGroup.Add(1)
+	worker.Start(d.pool.ctx)
+	d.pool.workers = append(d.pool.workers, &worker)
+}
+
+// stopWorkers signals the workers to stop and waits until they finished
+func stopWorkers(workers []*Worker) {
+	for _, w := range workers {
+		w.Stop()
+	}
+	for _, w := range workers {
+		<-w.done
+	}
 }
@@ -1,10 +1,14 @@
 package worker
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
 )
 
 func TestNewDispatcher(t *testing.T) {
@@ -13,15 +17,19 @@ func TestNewDispatcher(t *testing.T) {
 	workQueue := make(MockWorkQueue)
 	outputQueue := make(MockOutputQueue)
 	waitGroup := &sync.WaitGroup{}
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	deadLetterQueue := make(MockDeadLetterQueue)
+	location := time.UTC
 
 	dispatcherConfig := Dispatcher{
-		ServerName:  serverName,
-		Workers:     workers,
-		WorkQueue:   workQueue,
-		OutputQueue: outputQueue,
-		WaitGroup:   waitGroup,
-		Logger:      logger,
+		ServerName:      serverName,
+		Location:        location,
+		Workers:         workers,
+		WorkQueue:       workQueue,
+		OutputQueue:     outputQueue,
+		DeadLetterQueue: deadLetterQueue,
+		WaitGroup:       waitGroup,
+		Logger:          logger,
 	}
 
 	dispatcher := NewDispatcher(dispatcherConfig)
@@ -29,6 +37,9 @@ func TestNewDispatcher(t *testing.T) {
 	if dispatcher.ServerName != serverName {
 		t.Errorf("NewDispatcher() - Expected ServerName: %s, got: %s", serverName, dispatcher.ServerName)
 	}
+	if dispatcher.Location != location {
+		t.Errorf("NewDispatcher() - Expected Location: %v, got: %v", location, dispatcher.Location)
+	}
 	if dispatcher.Workers != workers {
 		t.Errorf("NewDispatcher() - Expected Workers: %d, got: %d", workers, dispatcher.Workers)
 	}
@@ -38,6 +49,9 @@ func TestNewDispatcher(t *testing.T) {
 	if dispatcher.OutputQueue != outputQueue {
 		t.Errorf("NewDispatcher() - Expected OutputQueue: %v, got: %v", outputQueue, dispatcher.OutputQueue)
 	}
+	if dispatcher.DeadLetterQueue != deadLetterQueue {
+		t.Errorf("NewDispatcher() - Expected DeadLetterQueue: %v, got: %v", deadLetterQueue, dispatcher.DeadLetterQueue)
+	}
 	if dispatcher.WaitGroup != waitGroup {
 		t.Errorf("NewDispatcher() - Expected WaitGroup: %v, got: %v", waitGroup, dispatcher.WaitGroup)
 	}
@@ -52,7 +66,7 @@ func TestDispatcher_Start(t *testing.T) {
 	workQueue := make(MockWorkQueue)
 	outputQueue := make(MockOutputQueue)
 	waitGroup := &sync.WaitGroup{}
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
 
 	dispatcherConfig := Dispatcher{
 		ServerName:  serverName,
@@ -66,7 +80,7 @@ func TestDispatcher_Start(t *testing.T) {
 	dispatcher := NewDispatcher(dispatcherConfig)
 
 	// Start the dispatcher
-	dispatcher.Start()
+	dispatcher.Start(context.Background())
 
 	// Wait for some time to allow the goroutines to start
 	time.Sleep(100 * time.Millisecond)
@@ -92,7 +106,7 @@ func TestDispatcher_Stop(t *testing.T) {
 	workQueue := make(MockWorkQueue)
 	outputQueue := make(MockOutputQueue)
 	waitGroup := &sync.WaitGroup{}
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
 
 	dispatcherConfig := Dispatcher{
 		ServerName:  serverName,
@@ -106,13 +120,13 @@ func TestDispatcher_Stop(t *testing.T) {
 	dispatcher := NewDispatcher(dispatcherConfig)
 
 	// Start the dispatcher
-	dispatcher.Start()
+	dispatcher.Start(context.Background())
 
 	// Wait for some time to allow the goroutines to start
 	time.Sleep(100 * time.Millisecond)
 
 	// Call the Stop method
-	dispatcher.Stop()
+	dispatcher.Stop(context.Background())
 
 	// Check if the work queue was closed
 	_, ok := <-workQueue
@@ -123,3 +137,259 @@ func TestDispatcher_Stop(t *testing.T) {
 	// Check if all workers finished
 	waitGroup.Wait()
 }
+
+func TestDispatcher_StartStop(t *testing.T) {
+	workQueue := make(MockWorkQueue, 5)
+	outputQueue := make(MockOutputQueue, 5)
+	line := WorkRequest{
+		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+	for i := 0; i < 5; i++ {
+		workQueue <- line
+	}
+
+	dispatcher := NewDispatcher(Dispatcher{
+		ServerName:    "artifactory.domain",
+		Workers:       2,
+		MaxWorkers:    4,
+		ScaleInterval: time.Millisecond,
+		WorkQueue:     workQueue,
+		OutputQueue:   outputQueue,
+		WaitGroup:     &sync.WaitGroup{},
+		Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+
+	// Stop right after Start waits for the workers, which process the queued work requests
+	dispatcher.Start(context.Background())
+	abandoned, err := dispatcher.Stop(context.Background())
+	if err != nil {
+		t.Error("Dispatcher.Stop() returned an error:", err)
+	}
+	if abandoned != 0 {
+		t.Errorf("Dispatcher.Stop() - Expected no abandoned work requests, got: %d", abandoned)
+	}
+	if len(outputQueue) != 5 {
+		t.Errorf("Dispatcher.Stop() - Expected 5 results, got: %d", len(outputQueue))
+	}
+
+	// No worker is started after the dispatcher was stopped
+	dispatcher.SetWorkers(3)
+	if dispatcher.Size() != 2 {
+		t.Errorf("Dispatcher.SetWorkers() - Expected no worker to be started after Stop, got: %d workers", dispatcher.Size())
+	}
+}
+
+func TestDispatcher_SetWorkers(t *testing.T) {
+	workQueue := make(MockWorkQueue)
+	outputQueue := make(MockOutputQueue)
+	waitGroup := &sync.WaitGroup{}
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	dispatcher := NewDispatcher(Dispatcher{
+		ServerName:  "artifactory.domain",
+		Workers:     2,
+		WorkQueue:   workQueue,
+		OutputQueue: outputQueue,
+		WaitGroup:   waitGroup,
+		Logger:      logger,
+	})
+	dispatcher.Start(context.Background())
+
+	// Wait for some time to allow the goroutines to start
+	time.Sleep(100 * time.Millisecond)
+
+	if dispatcher.Size() != 2 {
+		t.Errorf("Dispatcher.Start() - Expected 2 workers, got: %d", dispatcher.Size())
+	}
+
+	// Grow the pool
+	dispatcher.SetWorkers(4)
+	if dispatcher.Size() != 4 || dispatcher.Workers != 4 {
+		t.Errorf("Dispatcher.SetWorkers() - Expected 4 workers, got: %d", dispatcher.Size())
+	}
+
+	// Shrink the pool, the removed workers should be stopped when it returns
+	dispatcher.SetWorkers(1)
+	if dispatcher.Size() != 1 || dispatcher.Workers != 1 {
+		t.Errorf("Dispatcher.SetWorkers() - Expected 1 worker, got: %d", dispatcher.Size())
+	}
+
+	// Stop the dispatcher, this only returns if all workers are stopped
+	dispatcher.Stop(context.Background())
+}
+
+func TestDispatcher_Reconfigure(t *testing.T) {
+	workQueue := make(MockWorkQueue, 10)
+	outputQueue := make(MockOutputQueue, 20)
+	waitGroup := &sync.WaitGroup{}
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	line := WorkRequest{
+		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+
+	dispatcher := NewDispatcher(Dispatcher{
+		ServerName:  "artifactory.domain",
+		Workers:     2,
+		WorkQueue:   workQueue,
+		OutputQueue: outputQueue,
+		WaitGroup:   waitGroup,
+		Logger:      logger,
+	})
+	dispatcher.Start(context.Background())
+
+	// Queue work requests while the workers are replaced
+	for i := 0; i < 10; i++ {
+		workQueue <- line
+	}
+	dispatcher.Reconfigure(Settings{
+		ServerName: "edge.domain",
+		Workers:    3,
+	})
+	if dispatcher.Size() != 3 {
+		t.Errorf("Dispatcher.Reconfigure() - Expected 3 workers, got: %d", dispatcher.Size())
+	}
+
+	// Requests queued after reconfiguring use the new settings
+	workQueue <- line
+	dispatcher.Stop(context.Background())
+	close(outputQueue)
+
+	var outputs []string
+	for output := range outputQueue {
+		outputs = append(outputs, output.Line)
+	}
+
+	// No queued work request should be dropped while replacing the workers
+	if len(outputs) != 11 {
+		t.Fatalf("Dispatcher.Reconfigure() - Expected 11 outputs, got: %d", len(outputs))
+	}
+	if !strings.Contains(outputs[len(outputs)-1], `"server_name":"edge.domain"`) {
+		t.Errorf("Dispatcher.Reconfigure() - Expected output with the new server name, got: %s", outputs[len(outputs)-1])
+	}
+}
+
+func TestDispatcher_Autoscale(t *testing.T) {
+	workQueue := make(MockWorkQueue, 10)
+	outputQueue := make(MockOutputQueue)
+	waitGroup := &sync.WaitGroup{}
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	line := WorkRequest{
+		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+
+	dispatcher := NewDispatcher(Dispatcher{
+		ServerName:    "artifactory.domain",
+		Workers:       1,
+		MinWorkers:    1,
+		MaxWorkers:    3,
+		ScaleInterval: 10 * time.Millisecond,
+		WorkQueue:     workQueue,
+		OutputQueue:   outputQueue,
+		WaitGroup:     waitGroup,
+		Logger:        logger,
+	})
+	dispatcher.Start(context.Background())
+
+	// Keep the work queue full while nobody reads the output, the pool should grow to the maximum
+	deadline := time.Now().Add(2 * time.Second)
+	for dispatcher.Size() < 3 && time.Now().Before(deadline) {
+		select {
+		case workQueue <- line:
+		default:
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	if dispatcher.Size() != 3 {
+		t.Fatalf("Dispatcher.autoscale() - Expected pool to grow to 3 workers, got: %d", dispatcher.Size())
+	}
+
+	// Drain the output so the work queue becomes idle, the pool should shrink to the minimum
+	go func() {
+		for range outputQueue {
+		}
+	}()
+	deadline = time.Now().Add(2 * time.Second)
+	for dispatcher.Size() > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if dispatcher.Size() != 1 {
+		t.Errorf("Dispatcher.autoscale() - Expected pool to shrink to 1 worker, got: %d", dispatcher.Size())
+	}
+
+	dispatcher.Stop(context.Background())
+	close(outputQueue)
+}
+
+func TestDispatcher_SetWorkersBounds(t *testing.T) {
+	dispatcher := NewDispatcher(Dispatcher{
+		ServerName:  "artifactory.domain",
+		Workers:     2,
+		MinWorkers:  2,
+		MaxWorkers:  4,
+		WorkQueue:   make(MockWorkQueue),
+		OutputQueue: make(MockOutputQueue),
+		WaitGroup:   &sync.WaitGroup{},
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+
+	dispatcher.SetWorkers(10)
+	if dispatcher.Size() != 4 {
+		t.Errorf("Dispatcher.SetWorkers() - Expected pool to be limited to 4 workers, got: %d", dispatcher.Size())
+	}
+	dispatcher.SetWorkers(0)
+	if dispatcher.Size() != 2 {
+		t.Errorf("Dispatcher.SetWorkers() - Expected pool to be kept at 2 workers, got: %d", dispatcher.Size())
+	}
+
+	dispatcher.Stop(context.Background())
+}
+
+func TestDispatcher_StopTimeout(t *testing.T) {
+	workQueue := make(MockWorkQueue, 3)
+	outputQueue := make(MockOutputQueue)
+	line := WorkRequest{
+		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+
+	dispatcher := NewDispatcher(Dispatcher{
+		ServerName:  "artifactory.domain",
+		Workers:     1,
+		WorkQueue:   workQueue,
+		OutputQueue: outputQueue,
+		WaitGroup:   &sync.WaitGroup{},
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	dispatcher.Start(context.Background())
+	for dispatcher.Size() < 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Nobody reads the output, so the worker is stuck with the first work request and the others stay queued
+	for i := 0; i < 3; i++ {
+		workQueue <- line
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	abandoned, err := dispatcher.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Dispatcher.Stop() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if abandoned != 3 {
+		t.Errorf("Dispatcher.Stop() - Expected 3 abandoned work requests, got: %d", abandoned)
+	}
+}
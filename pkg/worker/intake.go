@@ -0,0 +1,243 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/spill"
+)
+
+// OverloadPolicy describes what happens to the input lines when the pipeline can not keep up
+type OverloadPolicy string
+
+const (
+	// Block waits until the pipeline accepts the line, which stops reading the input
+	Block OverloadPolicy = "block"
+	// Spill buffers the lines in a file on disk until the pipeline accepts them
+	Spill OverloadPolicy = "spill"
+	// Drop drops the lines and counts them
+	Drop OverloadPolicy = "drop"
+)
+
+// Intake passes the input lines to the collector and applies the overload policy when the work queue
+// or the reorder buffer is full
+type Intake struct {
+	Policy    OverloadPolicy
+	Spill     *spill.Queue
+	WorkQueue chan WorkRequest
+	Reorderer *Reorderer
+	Logger    *slog.Logger
+
+	mu sync.Mutex
+	// format is the log format of the spilled lines, which are parsed with the latest one
+	format LogFormat
+	// forwarding is set while a line taken out of the spill file is sent to the work queue
+	forwarding bool
+	// spilling is set while there are lines in the spill file
+	spilling bool
+	// dropped counts the lines dropped since the pipeline became overloaded
+	dropped int64
+	// abandoned counts the spilled lines which were taken out of the spill file but not sent
+	abandoned int
+
+	wake   chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// IntakeConfig contains the settings of an Intake
+type IntakeConfig struct {
+	Policy    OverloadPolicy
+	Spill     *spill.Queue
+	WorkQueue chan WorkRequest
+	Reorderer *Reorderer
+	Logger    *slog.Logger
+}
+
+// NewIntake validates the overload policy and creates and returns an Intake object
+// The spill policy requires a spill queue and the spill and drop policies require a buffered work queue
+func NewIntake(c IntakeConfig) (*Intake, error) {
+	if c.Policy == "" {
+		c.Policy = Block
+	}
+	switch c.Policy {
+	case Block:
+	case Spill:
+		if c.Spill == nil {
+			return nil, fmt.Errorf("the spill overload policy requires a spill file")
+		}
+		fallthrough
+	case Drop:
+		if cap(c.WorkQueue) == 0 {
+			return nil, fmt.Errorf("the %s overload policy requires a buffered work queue", c.Policy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown overload policy %q", c.Policy)
+	}
+
+	intake := &Intake{
+		Policy:    c.Policy,
+		Spill:     c.Spill,
+		WorkQueue: c.WorkQueue,
+		Reorderer: c.Reorderer,
+		Logger:    c.Logger,
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		cancel:    func() {},
+	}
+	return intake, nil
+}
+
+// Start starts sending the spilled lines to the work queue
+func (i *Intake) Start(ctx context.Context) {
+	if i.Policy != Spill {
+		close(i.done)
+		return
+	}
+
+	ctx, i.cancel = context.WithCancel(ctx)
+	go i.forward(ctx)
+}
+
+// Add passes an input line to the collector
+// With the block policy it waits until the line is accepted or the context is done,
+// otherwise the line is spilled or dropped when the pipeline is full
+func (i *Intake) Add(ctx context.Context, line string, offset int64, format LogFormat) error {
+	switch i.Policy {
+	case Drop:
+		if TryCollector(line, offset, format, i.Reorderer, i.WorkQueue) {
+			i.recovered()
+			return nil
+		}
+		i.drop()
+		return nil
+	case Spill:
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		i.format = format
+
+		// the line can only skip the spill file when no earlier line is waiting in it
+		if i.Spill.Len() == 0 && !i.forwarding && TryCollector(line, offset, format, i.Reorderer, i.WorkQueue) {
+			i.recovered()
+			return nil
+		}
+		if err := i.Spill.Push(spill.Entry{Offset: offset, Line: line}); err != nil {
+			i.Logger.Error("failed to spill line", "error", err)
+			i.drop()
+			return nil
+		}
+		i.recovered()
+		if !i.spilling {
+			i.spilling = true
+			i.Logger.Warn("pipeline is overloaded, spilling lines", "file", i.Spill.Path)
+		}
+		metrics.SpilledLines.Set(int64(i.Spill.Len()))
+		select {
+		case i.wake <- struct{}{}:
+		default:
+		}
+		return nil
+	default:
+		return Collector(ctx, line, offset, format, i.Reorderer, i.WorkQueue)
+	}
+}
+
+// Stop waits until the spilled lines are sent to the work queue or the context is done
+// It returns the number of spilled lines which were abandoned and the context error when they were not sent in time
+// It must be called before the work queue is closed
+func (i *Intake) Stop(ctx context.Context) (int, error) {
+	select {
+	case <-i.quit:
+	default:
+		close(i.quit)
+	}
+
+	var err error
+	select {
+	case <-i.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		i.cancel()
+		<-i.done
+	}
+	i.cancel()
+
+	if i.Spill == nil {
+		return 0, err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	abandoned := i.abandoned + i.Spill.Len()
+	metrics.SpilledLines.Set(0)
+	if cerr := i.Spill.Close(); cerr != nil {
+		i.Logger.Error("failed to remove spill file", "error", cerr)
+	}
+	return abandoned, err
+}
+
+// forward sends the spilled lines to the work queue in order, waiting until the pipeline accepts them
+func (i *Intake) forward(ctx context.Context) {
+	defer close(i.done)
+
+	for {
+		i.mu.Lock()
+		entry, ok, err := i.Spill.Pop()
+		if err != nil {
+			i.Logger.Error("failed to read spilled line", "error", err)
+		}
+		if !ok {
+			if i.spilling {
+				i.spilling = false
+				i.Logger.Info("pipeline caught up, the spill file is empty")
+			}
+			i.mu.Unlock()
+
+			select {
+			case <-i.wake:
+				continue
+			case <-i.quit:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		i.forwarding = true
+		format := i.format
+		metrics.SpilledLines.Set(int64(i.Spill.Len()))
+		i.mu.Unlock()
+
+		err = Collector(ctx, entry.Line, entry.Offset, format, i.Reorderer, i.WorkQueue)
+
+		i.mu.Lock()
+		i.forwarding = false
+		if err != nil {
+			i.abandoned++
+		}
+		i.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// drop counts a dropped line and logs when the pipeline becomes overloaded
+func (i *Intake) drop() {
+	metrics.DroppedLines.Add(1)
+	if i.dropped == 0 {
+		i.Logger.Warn("pipeline is overloaded, dropping lines")
+	}
+	i.dropped++
+}
+
+// recovered logs how many lines were dropped once the pipeline accepts lines again
+func (i *Intake) recovered() {
+	if i.dropped > 0 {
+		i.Logger.Info("pipeline recovered from overload", "dropped", i.dropped)
+		i.dropped = 0
+	}
+}
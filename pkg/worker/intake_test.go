@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/spill"
+)
+
+func TestNewIntake(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    IntakeConfig
+		expectErr bool
+	}{
+		{"default policy", IntakeConfig{WorkQueue: make(MockWorkQueue)}, false},
+		{"block", IntakeConfig{Policy: Block, WorkQueue: make(MockWorkQueue)}, false},
+		{"drop", IntakeConfig{Policy: Drop, WorkQueue: make(MockWorkQueue, 1)}, false},
+		{"drop with unbuffered queue", IntakeConfig{Policy: Drop, WorkQueue: make(MockWorkQueue)}, true},
+		{"spill without spill file", IntakeConfig{Policy: Spill, WorkQueue: make(MockWorkQueue, 1)}, true},
+		{"unknown policy", IntakeConfig{Policy: "retry", WorkQueue: make(MockWorkQueue, 1)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intake, err := NewIntake(tt.config)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("NewIntake() - Expected error: %v, got: %v", tt.expectErr, err)
+			}
+			if err == nil && tt.config.Policy == "" && intake.Policy != Block {
+				t.Errorf("NewIntake() - Expected policy: %s, got: %s", Block, intake.Policy)
+			}
+		})
+	}
+}
+
+func TestIntake_Drop(t *testing.T) {
+	workQueue := make(MockWorkQueue, 2)
+	intake, err := NewIntake(IntakeConfig{
+		Policy:    Drop,
+		WorkQueue: workQueue,
+		Logger:    slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewIntake() - Unexpected error: %v", err)
+	}
+	intake.Start(context.Background())
+	dropped := metrics.DroppedLines.Value()
+
+	// The third line does not fit in the work queue
+	for i := 1; i <= 3; i++ {
+		if err = intake.Add(context.Background(), fmt.Sprintf("line %d", i), int64(i), LogFormat{}); err != nil {
+			t.Errorf("Intake.Add() - Unexpected error: %v", err)
+		}
+	}
+	if got := metrics.DroppedLines.Value() - dropped; got != 1 {
+		t.Errorf("Intake.Add() - Expected 1 dropped line, got: %d", got)
+	}
+	if len(workQueue) != 2 {
+		t.Errorf("Intake.Add() - Expected 2 queued lines, got: %d", len(workQueue))
+	}
+
+	if abandoned, err := intake.Stop(context.Background()); abandoned != 0 || err != nil {
+		t.Errorf("Intake.Stop() - Expected no abandoned lines, got: %d, %v", abandoned, err)
+	}
+}
+
+func TestIntake_Spill(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := spill.Open(filepath.Join(dir, "spill.log"), 0)
+	if err != nil {
+		t.Fatalf("spill.Open() - Unexpected error: %v", err)
+	}
+	workQueue := make(MockWorkQueue, 2)
+	intake, err := NewIntake(IntakeConfig{
+		Policy:    Spill,
+		Spill:     queue,
+		WorkQueue: workQueue,
+		Logger:    slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewIntake() - Unexpected error: %v", err)
+	}
+	intake.Start(context.Background())
+
+	// The lines which do not fit in the work queue are spilled and none of them waits for the pipeline
+	for i := 1; i <= 10; i++ {
+		if err = intake.Add(context.Background(), fmt.Sprintf("line %d", i), int64(i), LogFormat{Delimiter: "|"}); err != nil {
+			t.Errorf("Intake.Add() - Unexpected error: %v", err)
+		}
+	}
+
+	// Reading the work queue receives all the lines in order
+	for i := 1; i <= 10; i++ {
+		select {
+		case work := <-workQueue:
+			if want := fmt.Sprintf("line %d", i); work.Line != want || work.Offset != int64(i) || work.Delimiter != "|" {
+				t.Errorf("Intake.Add() - Expected line: %s, got: %v", want, work)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Intake.Add() - Line %d was not sent to the work queue", i)
+		}
+	}
+
+	if abandoned, err := intake.Stop(context.Background()); abandoned != 0 || err != nil {
+		t.Errorf("Intake.Stop() - Expected no abandoned lines, got: %d, %v", abandoned, err)
+	}
+	if _, err = os.Stat(queue.Path); !os.IsNotExist(err) {
+		t.Error("Intake.Stop() - Expected spill file to be removed")
+	}
+}
+
+func TestIntake_StopTimeout(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := spill.Open(filepath.Join(dir, "spill.log"), 0)
+	if err != nil {
+		t.Fatalf("spill.Open() - Unexpected error: %v", err)
+	}
+	intake, err := NewIntake(IntakeConfig{
+		Policy:    Spill,
+		Spill:     queue,
+		WorkQueue: make(MockWorkQueue, 1),
+		Logger:    slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewIntake() - Unexpected error: %v", err)
+	}
+	intake.Start(context.Background())
+
+	// Nobody reads the work queue, so only the first line is sent
+	for i := 1; i <= 4; i++ {
+		intake.Add(context.Background(), fmt.Sprintf("line %d", i), int64(i), LogFormat{})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	abandoned, err := intake.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Intake.Stop() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if abandoned != 3 {
+		t.Errorf("Intake.Stop() - Expected 3 abandoned lines, got: %d", abandoned)
+	}
+}
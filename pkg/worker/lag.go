@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+// defaultLagInterval is how often the pipeline lag is checked
+const defaultLagInterval = time.Second
+
+// LagMonitor alerts when the written output falls behind the input which was read
+// The lag is measured in bytes of the input and in the age of the oldest input which was read but not written
+type LagMonitor struct {
+	MaxBytes int64
+	MaxDelay time.Duration
+	Interval time.Duration
+	Logger   *slog.Logger
+
+	// Read and Written return the input offsets up to which the lines were read and written
+	Read    func() int64
+	Written func() int64
+
+	// samples are the read offsets of the previous checks which were not written yet
+	samples  []lagSample
+	alerting bool
+}
+
+// lagSample is the input offset which was read at a check
+type lagSample struct {
+	time   time.Time
+	offset int64
+}
+
+// NewLagMonitor creates and returns a LagMonitor object
+// A threshold which is not set is not checked
+func NewLagMonitor(m LagMonitor) *LagMonitor {
+	if m.Interval <= 0 {
+		m.Interval = defaultLagInterval
+	}
+
+	monitor := &LagMonitor{
+		MaxBytes: m.MaxBytes,
+		MaxDelay: m.MaxDelay,
+		Interval: m.Interval,
+		Logger:   m.Logger,
+		Read:     m.Read,
+		Written:  m.Written,
+	}
+	return monitor
+}
+
+// Start checks the lag every interval until the context is done
+func (m *LagMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.check(now)
+			}
+		}
+	}()
+}
+
+// check measures the lag, updates the metrics and logs when the lag crosses or falls back below the thresholds
+func (m *LagMonitor) check(now time.Time) {
+	read, written := m.Read(), m.Written()
+	lag := read - written
+	if lag < 0 {
+		lag = 0
+	}
+
+	// the input read at the oldest check which is not written yet is at least as old as that check
+	for len(m.samples) > 0 && m.samples[0].offset <= written {
+		m.samples = m.samples[1:]
+	}
+	var delay time.Duration
+	if len(m.samples) > 0 {
+		delay = now.Sub(m.samples[0].time)
+	}
+	if lag > 0 && (len(m.samples) == 0 || m.samples[len(m.samples)-1].offset < read) {
+		m.samples = append(m.samples, lagSample{time: now, offset: read})
+	}
+	metrics.LagBytes.Set(lag)
+	metrics.LagSeconds.Set(delay.Seconds())
+
+	exceeded := (m.MaxBytes > 0 && lag > m.MaxBytes) || (m.MaxDelay > 0 && delay > m.MaxDelay)
+	if exceeded && !m.alerting {
+		m.Logger.Warn("pipeline lag alert: the output is behind the input", "bytes", lag, "delay", delay.Round(time.Second))
+	}
+	if !exceeded && m.alerting {
+		m.Logger.Info("pipeline lag recovered", "bytes", lag)
+	}
+	m.alerting = exceeded
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLagMonitor_Check(t *testing.T) {
+	var read, written int64
+	var output bytes.Buffer
+
+	monitor := NewLagMonitor(LagMonitor{
+		MaxBytes: 100,
+		MaxDelay: 10 * time.Second,
+		Logger:   slog.New(slog.NewTextHandler(&output, nil)),
+		Read:     func() int64 { return read },
+		Written:  func() int64 { return written },
+	})
+	start := time.Now()
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		read    int64
+		written int64
+		alert   bool
+	}{
+		{"caught up", 0, 50, 50, false},
+		{"small lag", time.Second, 80, 60, false},
+		{"too many bytes behind", 2 * time.Second, 300, 70, true},
+		{"lines keep being written", 5 * time.Second, 300, 290, false},
+		{"output stalls", 8 * time.Second, 310, 290, false},
+		{"output stalls too long", 19 * time.Second, 320, 290, true},
+		{"output catches up", 20 * time.Second, 320, 320, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, written = tt.read, tt.written
+			output.Reset()
+			monitor.check(start.Add(tt.elapsed))
+
+			if monitor.alerting != tt.alert {
+				t.Errorf("LagMonitor.check() - Expected alert: %v, got: %v", tt.alert, monitor.alerting)
+			}
+			if tt.alert && !strings.Contains(output.String(), "pipeline lag alert") {
+				t.Errorf("LagMonitor.check() - Expected alert to be logged, got: %q", output.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+// DefaultMaxPending is the default number of work requests which can be in flight between
+// the collector and the output of the reorder buffer
+const DefaultMaxPending = 1000
+
+// Reorderer is a reorder buffer which receives the results of the workers in any order
+// and sends the billing log entries to the output queue in the order of the input lines
+type Reorderer struct {
+	InputQueue  chan Result
+	OutputQueue chan writer.WriteRequest
+	MaxPending  int
+	Logger      *slog.Logger
+
+	// slots limits the number of reserved sequence numbers which were not sent to the output yet
+	slots    chan struct{}
+	sequence uint64
+	done     chan struct{}
+	// abort stops sending results to the output queue and abandoned counts the results which were not sent
+	abort     chan struct{}
+	abandoned int
+}
+
+// NewReorderer creates and returns a Reorderer object
+func NewReorderer(r Reorderer) *Reorderer {
+	if r.MaxPending <= 0 {
+		r.MaxPending = DefaultMaxPending
+	}
+
+	reorderer := &Reorderer{
+		InputQueue:  r.InputQueue,
+		OutputQueue: r.OutputQueue,
+		MaxPending:  r.MaxPending,
+		Logger:      r.Logger,
+		slots:       make(chan struct{}, r.MaxPending),
+		done:        make(chan struct{}),
+		abort:       make(chan struct{}),
+	}
+	return reorderer
+}
+
+// Reserve returns the next sequence number
+// It blocks while MaxPending work requests are in flight, which bounds the reorder buffer,
+// and counts each time it had to wait as a stall
+// It returns the context error when the context is done while waiting
+func (r *Reorderer) Reserve(ctx context.Context) (uint64, error) {
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		metrics.ReorderStalls.Add(1)
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return atomic.AddUint64(&r.sequence, 1), nil
+}
+
+// TryReserve returns the next sequence number or false without waiting when MaxPending work requests are in flight
+func (r *Reorderer) TryReserve() (uint64, bool) {
+	select {
+	case r.slots <- struct{}{}:
+		return atomic.AddUint64(&r.sequence, 1), true
+	default:
+		return 0, false
+	}
+}
+
+// Start starts the reorder buffer
+func (r *Reorderer) Start() {
+	go func() {
+		defer close(r.done)
+
+		next := uint64(1)
+		pending := make(map[uint64]Result)
+
+		for result := range r.InputQueue {
+			// results without a sequence number are not reordered
+			if result.Sequence == 0 {
+				if !r.send(result) {
+					r.drop(len(pending) + 1)
+					return
+				}
+				continue
+			}
+
+			pending[result.Sequence] = result
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if !r.send(ready) {
+					r.drop(len(pending) + 1)
+					return
+				}
+				<-r.slots
+			}
+			metrics.ReorderPending.Set(int64(len(pending)))
+		}
+
+		// the input queue is closed, so the missing results will never arrive
+		if len(pending) > 0 {
+			r.Logger.Warn("reorder buffer stopped with results waiting for earlier ones, sending them in order", "results", len(pending))
+		}
+		for len(pending) > 0 {
+			if ready, ok := pending[next]; ok {
+				delete(pending, next)
+				if !r.send(ready) {
+					r.drop(len(pending) + 1)
+					return
+				}
+			}
+			next++
+		}
+		metrics.ReorderPending.Set(0)
+		r.Logger.Info("stopping the reorder buffer")
+	}()
+}
+
+// Stop closes the input queue of the reorder buffer and waits until all results are sent to the output queue
+// or the context is done, in which case the results which were not sent yet are abandoned
+// It returns the number of abandoned results and the context error when they were not sent in time
+// It must only be called after the workers were stopped
+func (r *Reorderer) Stop(ctx context.Context) (int, error) {
+	close(r.InputQueue)
+
+	select {
+	case <-r.done:
+		return 0, nil
+	case <-ctx.Done():
+		close(r.abort)
+		<-r.done
+		return r.abandoned, ctx.Err()
+	}
+}
+
+// drop counts the given results and the ones left in the input queue as abandoned after the reorder buffer was aborted
+func (r *Reorderer) drop(n int) {
+	for range r.InputQueue {
+		n++
+	}
+	r.abandoned = n
+	metrics.ReorderPending.Set(0)
+	r.Logger.Warn("stopping the reorder buffer without sending all results", "results", n)
+}
+
+// send sends the billing log entry of a result to the output queue
+// Results without an entry are only sent when they have an input offset, so the writer can advance its checkpoint
+// It returns false when the reorder buffer was aborted before the result was sent
+func (r *Reorderer) send(result Result) bool {
+	if result.Line == "" && result.Offset == 0 {
+		return true
+	}
+	select {
+	case r.OutputQueue <- writer.WriteRequest{Line: result.Line, Offset: result.Offset}:
+		return true
+	case <-r.abort:
+		return false
+	}
+}
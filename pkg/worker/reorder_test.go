@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+	"github.com/svetlyopet/logcat/pkg/writer"
+)
+
+func TestReorderer_Start(t *testing.T) {
+	inputQueue := make(MockOutputQueue)
+	outputQueue := make(chan writer.WriteRequest, 10)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	reorderer := NewReorderer(Reorderer{
+		InputQueue:  inputQueue,
+		OutputQueue: outputQueue,
+		MaxPending:  10,
+		Logger:      logger,
+	})
+	reorderer.Start()
+
+	// Reserve sequence numbers for five work requests
+	for i := 0; i < 5; i++ {
+		reorderer.Reserve(context.Background())
+	}
+
+	// Send the results out of order, the third one produced no billing log entry
+	inputQueue <- Result{Sequence: 4, Line: "four"}
+	inputQueue <- Result{Sequence: 2, Line: "two"}
+	inputQueue <- Result{Sequence: 3}
+	inputQueue <- Result{Sequence: 1, Line: "one"}
+	inputQueue <- Result{Sequence: 5, Line: "five"}
+
+	// Results without a sequence number are sent as they arrive
+	inputQueue <- Result{Line: "unordered"}
+
+	reorderer.Stop(context.Background())
+	close(outputQueue)
+
+	var got []string
+	for request := range outputQueue {
+		got = append(got, request.Line)
+	}
+	want := []string{"one", "two", "four", "five", "unordered"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reorderer.Start() - Expected output: %v, got: %v", want, got)
+	}
+}
+
+func TestReorderer_Stop(t *testing.T) {
+	inputQueue := make(MockOutputQueue)
+	outputQueue := make(chan writer.WriteRequest, 10)
+
+	reorderer := NewReorderer(Reorderer{
+		InputQueue:  inputQueue,
+		OutputQueue: outputQueue,
+		MaxPending:  10,
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	reorderer.Start()
+
+	// The result of the first sequence number never arrives
+	inputQueue <- Result{Sequence: 3, Line: "three"}
+	inputQueue <- Result{Sequence: 2, Line: "two"}
+
+	// Stopping the reorderer sends the waiting results in order
+	reorderer.Stop(context.Background())
+	close(outputQueue)
+
+	var got []string
+	for request := range outputQueue {
+		got = append(got, request.Line)
+	}
+	want := []string{"two", "three"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reorderer.Stop() - Expected output: %v, got: %v", want, got)
+	}
+}
+
+func TestReorderer_Reserve(t *testing.T) {
+	inputQueue := make(MockOutputQueue)
+	outputQueue := make(chan writer.WriteRequest, 10)
+
+	reorderer := NewReorderer(Reorderer{
+		InputQueue:  inputQueue,
+		OutputQueue: outputQueue,
+		MaxPending:  2,
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	reorderer.Start()
+	stalls := metrics.ReorderStalls.Value()
+
+	reorderer.Reserve(context.Background())
+	reorderer.Reserve(context.Background())
+
+	// The third reservation has to wait until the first result was sent to the output
+	reserved := make(chan uint64)
+	go func() {
+		sequence, _ := reorderer.Reserve(context.Background())
+		reserved <- sequence
+	}()
+
+	select {
+	case <-reserved:
+		t.Fatal("Reorderer.Reserve() - Expected reservation to wait while the buffer is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	inputQueue <- Result{Sequence: 1, Line: "one"}
+
+	select {
+	case sequence := <-reserved:
+		if sequence != 3 {
+			t.Errorf("Reorderer.Reserve() - Expected sequence: %d, got: %d", 3, sequence)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Reorderer.Reserve() - Reservation did not continue after a result was sent to the output")
+	}
+
+	if got := metrics.ReorderStalls.Value() - stalls; got != 1 {
+		t.Errorf("Reorderer.Reserve() - Expected 1 stall, got: %d", got)
+	}
+
+	// The buffer is full again, so a reservation fails without waiting or is cancelled
+	if _, ok := reorderer.TryReserve(); ok {
+		t.Error("Reorderer.TryReserve() - Expected reservation to fail while the buffer is full")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := reorderer.Reserve(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Reorderer.Reserve() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+
+	reorderer.Stop(context.Background())
+}
+
+func TestReorderer_Offset(t *testing.T) {
+	inputQueue := make(MockOutputQueue)
+	outputQueue := make(chan writer.WriteRequest, 10)
+
+	reorderer := NewReorderer(Reorderer{
+		InputQueue:  inputQueue,
+		OutputQueue: outputQueue,
+		MaxPending:  10,
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	reorderer.Start()
+	reorderer.Reserve(context.Background())
+	reorderer.Reserve(context.Background())
+
+	// Results without an entry are sent when they have an offset so the checkpoint can advance
+	inputQueue <- Result{Sequence: 2, Offset: 20}
+	inputQueue <- Result{Sequence: 1, Offset: 10, Line: "one"}
+	inputQueue <- Result{Line: "unordered"}
+
+	reorderer.Stop(context.Background())
+	close(outputQueue)
+
+	var got []writer.WriteRequest
+	for request := range outputQueue {
+		got = append(got, request)
+	}
+	want := []writer.WriteRequest{{Line: "one", Offset: 10}, {Offset: 20}, {Line: "unordered"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reorderer.Start() - Expected output: %v, got: %v", want, got)
+	}
+}
+
+func TestReorderer_StopTimeout(t *testing.T) {
+	inputQueue := make(MockOutputQueue, 10)
+	outputQueue := make(chan writer.WriteRequest)
+
+	reorderer := NewReorderer(Reorderer{
+		InputQueue:  inputQueue,
+		OutputQueue: outputQueue,
+		MaxPending:  10,
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	reorderer.Start()
+
+	// Nobody reads the output queue, so none of the results can be sent
+	inputQueue <- Result{Sequence: 1, Line: "one"}
+	inputQueue <- Result{Sequence: 2, Line: "two"}
+	inputQueue <- Result{Sequence: 4, Line: "four"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	abandoned, err := reorderer.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Reorderer.Stop() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if abandoned != 3 {
+		t.Errorf("Reorderer.Stop() - Expected 3 abandoned results, got: %d", abandoned)
+	}
+}
@@ -0,0 +1,9 @@
+package worker
+
+import "github.com/svetlyopet/logcat/pkg/parser"
+
+// Stage processes a parsed billing log entry before it is sent to the output queue
+// It returns false when the entry should be dropped
+type Stage interface {
+	Process(entry *parser.BillingLogs) bool
+}
@@ -1,8 +1,22 @@
 package worker
 
+import "github.com/svetlyopet/logcat/pkg/parser"
+
 // WorkRequest contains the type that the workers use
+// Offset is the position in the input file after the line, 0 when it is unknown
 type WorkRequest struct {
+	Sequence  uint64
+	Offset    int64
 	Line      string
 	Delimiter string
 	NumFields int
+	Fields    parser.FieldMap
+}
+
+// Result contains the outcome of a work request which the workers send to the reorder buffer
+// Line is empty when the work request did not produce a billing log entry
+type Result struct {
+	Sequence uint64
+	Offset   int64
+	Line     string
 }
@@ -1,62 +1,156 @@
 package worker
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/writer"
 )
 
 // Worker describes a worker
 type Worker struct {
-	ID          int
-	ServerName  string
-	WorkQueue   chan WorkRequest
-	OutputQueue chan string
-	WaitGroup   *sync.WaitGroup
-	Logger      *log.Logger
+	ID              int
+	ServerName      string
+	Location        *time.Location
+	Stages          []Stage
+	Schema          *parser.Schema
+	WorkQueue       chan WorkRequest
+	OutputQueue     chan Result
+	DeadLetterQueue chan writer.WriteRequest
+	WaitGroup       *sync.WaitGroup
+	Logger          *slog.Logger
+
+	quit chan struct{}
+	done chan struct{}
+	// abandoned counts the work requests which were taken but not finished because the context was done
+	abandoned *int64
 }
 
 // NewWorker creates and returns a new Worker object.
-func NewWorker(id int, serverName string, workQueue chan WorkRequest, outputQueue chan string, waitGroup *sync.WaitGroup, logger *log.Logger) Worker {
+func NewWorker(w Worker) Worker {
 	// Create and return the worker
 	worker := Worker{
-		ID:          id,
-		ServerName:  serverName,
-		WorkQueue:   workQueue,
-		OutputQueue: outputQueue,
-		WaitGroup:   waitGroup,
-		Logger:      logger,
+		ID:              w.ID,
+		ServerName:      w.ServerName,
+		Location:        w.Location,
+		Stages:          w.Stages,
+		Schema:          w.Schema,
+		WorkQueue:       w.WorkQueue,
+		OutputQueue:     w.OutputQueue,
+		DeadLetterQueue: w.DeadLetterQueue,
+		WaitGroup:       w.WaitGroup,
+		Logger:          w.Logger,
+		quit:            make(chan struct{}),
+		done:            make(chan struct{}),
 	}
 
 	return worker
 }
 
 // Start starts a worker
-func (w *Worker) Start() {
+// When the context is done the worker stops right away and abandons the work request it is processing
+func (w *Worker) Start(ctx context.Context) {
 	go func() {
 		defer w.WaitGroup.Done()
+		defer close(w.done)
 
 		for {
 			// get work from the Work channel until we receive signal that channel is closed
-			work, ok := <-w.WorkQueue
-			if !ok {
-				w.Logger.Printf("stoping worker %d", w.ID)
+			// or the worker is stopped on its own
+			var work WorkRequest
+			var ok bool
+			select {
+			case <-ctx.Done():
+				w.Logger.Debug("stopping worker")
+				return
+			case <-w.quit:
+				w.Logger.Debug("stopping worker")
 				return
+			case work, ok = <-w.WorkQueue:
+				if !ok {
+					w.Logger.Debug("stopping worker")
+					return
+				}
 			}
 
-			// do the work
-			logEntry, err := parser.Parse(work.Line, work.Delimiter, work.NumFields, w.ServerName)
-			if err != nil {
-				w.Logger.Printf("error while parsing line: \"%v\" : %v\n", work.Line, err)
-				continue
+			// do the work and send the result to the output channel
+			// an empty line tells the reorder buffer that the work request produced no billing log entry
+			result := Result{Sequence: work.Sequence, Offset: work.Offset, Line: w.work(ctx, work)}
+			select {
+			case w.OutputQueue <- result:
+			case <-ctx.Done():
+				w.abandon()
+				w.Logger.Debug("stopping worker")
+				return
 			}
-			if logEntry == "" {
-				continue
+		}
+	}()
+}
+
+// Stop signals the worker to stop after it finished the work request it is processing
+// The queued work requests are left for the other workers
+func (w *Worker) Stop() {
+	close(w.quit)
+}
+
+// work parses a log line and passes the billing log entry through the stages
+// It returns the encoded billing log entry or an empty string if there is none
+func (w *Worker) work(ctx context.Context, work WorkRequest) string {
+	billingLog, err := parser.ParseEntry(work.Line, work.Delimiter, work.NumFields, work.Fields, w.ServerName, w.Location)
+	if err != nil {
+		// the line is not logged, it contains personal data and is kept in the dead-letter queue
+		w.Logger.Warn("failed to parse line", "offset", work.Offset, "error", err)
+		// send the rejected line to the dead-letter queue if one is configured
+		if w.DeadLetterQueue != nil {
+			select {
+			case w.DeadLetterQueue <- writer.WriteRequest{Line: work.Line}:
+			case <-ctx.Done():
 			}
+		}
+		return ""
+	}
+	if billingLog == nil {
+		return ""
+	}
+	billingLog.Offset = work.Offset
 
-			// send the finished work to the output channel
-			w.OutputQueue <- logEntry
+	// pass the entry through the processing stages
+	if !w.process(billingLog) {
+		return ""
+	}
+
+	// the entries are encoded with the fields of the output schema if one is set
+	var logEntry string
+	if w.Schema != nil {
+		logEntry, err = w.Schema.Marshal(billingLog)
+	} else {
+		logEntry, err = billingLog.Marshal()
+	}
+	if err != nil {
+		w.Logger.Error("failed to encode billing log entry", "error", err)
+		return ""
+	}
+	return logEntry
+}
+
+// process passes a billing log entry through all the stages of the worker in order
+// and returns false as soon as one of the stages drops the entry
+func (w *Worker) process(entry *parser.BillingLogs) bool {
+	for _, stage := range w.Stages {
+		if !stage.Process(entry) {
+			return false
 		}
-	}()
+	}
+	return true
+}
+
+// abandon counts a work request which was not finished
+func (w *Worker) abandon() {
+	if w.abandoned != nil {
+		atomic.AddInt64(w.abandoned, 1)
+	}
 }
@@ -1,14 +1,30 @@
 package worker
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/parser"
+	"github.com/svetlyopet/logcat/pkg/writer"
 )
 
 type MockWorkQueue chan WorkRequest
-type MockOutputQueue chan string
+type MockOutputQueue chan Result
+type MockDeadLetterQueue chan writer.WriteRequest
 type MockLogger struct{}
+type MockStage struct {
+	keep      bool
+	processed int
+}
+
+func (s *MockStage) Process(entry *parser.BillingLogs) bool {
+	s.processed++
+	entry.Project = "mock"
+	return s.keep
+}
 
 func (l *MockLogger) Write(p []byte) (n int, err error) {
 	return len(p), nil
@@ -20,10 +36,22 @@ func TestNewWorker(t *testing.T) {
 	workQueue := make(MockWorkQueue)
 	outputQueue := make(MockOutputQueue)
 	waitGroup := &sync.WaitGroup{}
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	deadLetterQueue := make(MockDeadLetterQueue)
+	location := time.UTC
 
 	// Create a new worker
-	worker := NewWorker(id, serverName, workQueue, outputQueue, waitGroup, logger)
+	worker := NewWorker(Worker{
+		ID:              id,
+		ServerName:      serverName,
+		Location:        location,
+		WorkQueue:       workQueue,
+		OutputQueue:     outputQueue,
+		DeadLetterQueue: deadLetterQueue,
+		WaitGroup:       waitGroup,
+		Logger:          logger,
+	})
 
 	// Check if the worker is created correctly
 	if worker.ID != id {
@@ -35,9 +63,15 @@ func TestNewWorker(t *testing.T) {
 	if worker.WorkQueue != workQueue {
 		t.Error("NewWorker() - WorkQueue is not set correctly")
 	}
+	if worker.Location != location {
+		t.Error("NewWorker() - Location is not set correctly")
+	}
 	if worker.OutputQueue != outputQueue {
 		t.Error("NewWorker() - OutputQueue is not set correctly")
 	}
+	if worker.DeadLetterQueue != deadLetterQueue {
+		t.Error("NewWorker() - DeadLetterQueue is not set correctly")
+	}
 	if worker.WaitGroup != waitGroup {
 		t.Error("NewWorker() - WaitGroup is not set correctly")
 	}
@@ -52,20 +86,29 @@ func TestWorker_Start(t *testing.T) {
 	workQueue := make(MockWorkQueue)
 	outputQueue := make(MockOutputQueue)
 	waitGroup := &sync.WaitGroup{}
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
 
 	// Create a new worker
-	worker := NewWorker(id, serverName, workQueue, outputQueue, waitGroup, logger)
+	worker := NewWorker(Worker{
+		ID:          id,
+		ServerName:  serverName,
+		WorkQueue:   workQueue,
+		OutputQueue: outputQueue,
+		WaitGroup:   waitGroup,
+		Logger:      logger,
+	})
 	waitGroup.Add(1)
 
 	// Start the worker
-	worker.Start()
+	worker.Start(context.Background())
 
 	// Send a work request to the work queue
 	workQueue <- WorkRequest{
+		Sequence:  7,
 		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
 		Delimiter: "|",
 		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
 	}
 
 	// Check if the work request is processed and sent to the output queue
@@ -73,8 +116,11 @@ func TestWorker_Start(t *testing.T) {
 	case output := <-outputQueue:
 		// Check if the output matches the expected value
 		expectedOutput := `{"billing_timestamp":"2023-06-15 12:00:00.000","server_name":"artifactory.domain","service":"artifactory","action":"download","ip":"1.2.3.4","repository":"registry-docker-remote","project":"default","artifactory_path":"alpine/curl/manifests/latest","user_name":"user","consumption_unit":"bytes","quantity":1234}`
-		if output != expectedOutput {
-			t.Errorf("Worker.Start() - Expected output: %s, got: %s", expectedOutput, output)
+		if output.Line != expectedOutput {
+			t.Errorf("Worker.Start() - Expected output: %s, got: %s", expectedOutput, output.Line)
+		}
+		if output.Sequence != 7 {
+			t.Errorf("Worker.Start() - Expected sequence: %d, got: %d", 7, output.Sequence)
 		}
 	}
 
@@ -85,6 +131,153 @@ func TestWorker_Start(t *testing.T) {
 	waitGroup.Wait()
 }
 
+func TestWorker_StartDeadLetter(t *testing.T) {
+	workQueue := make(MockWorkQueue)
+	outputQueue := make(MockOutputQueue, 1)
+	deadLetterQueue := make(MockDeadLetterQueue, 1)
+	waitGroup := &sync.WaitGroup{}
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	// Create a new worker with a dead-letter queue
+	worker := NewWorker(Worker{
+		ID:              1,
+		ServerName:      "artifactory.domain",
+		WorkQueue:       workQueue,
+		OutputQueue:     outputQueue,
+		DeadLetterQueue: deadLetterQueue,
+		WaitGroup:       waitGroup,
+		Logger:          logger,
+	})
+	waitGroup.Add(1)
+
+	// Start the worker
+	worker.Start(context.Background())
+
+	// Send a work request with an invalid timestamp to the work queue
+	line := "2023-13-45T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123"
+	workQueue <- WorkRequest{
+		Line:      line,
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+
+	// Check if the rejected line is sent to the dead-letter queue
+	select {
+	case rejected := <-deadLetterQueue:
+		if rejected.Line != line {
+			t.Errorf("Worker.Start() - Expected dead letter: %s, got: %s", line, rejected.Line)
+		}
+	case <-time.After(time.Second):
+		t.Error("Worker.Start() - Rejected line was not sent to the dead-letter queue")
+	}
+
+	// Check if an empty result is sent for the rejected line
+	select {
+	case output := <-outputQueue:
+		if output.Line != "" {
+			t.Errorf("Worker.Start() - Expected line to be rejected, got output: %s", output.Line)
+		}
+	case <-time.After(time.Second):
+		t.Error("Worker.Start() - No result was sent for the rejected line")
+	}
+
+	// Close the work queue
+	close(workQueue)
+
+	// Wait for the worker to finish
+	waitGroup.Wait()
+}
+
+func TestWorker_StartStages(t *testing.T) {
+	workQueue := make(MockWorkQueue)
+	outputQueue := make(MockOutputQueue, 1)
+	waitGroup := &sync.WaitGroup{}
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	keepStage := &MockStage{keep: true}
+	dropStage := &MockStage{keep: false}
+
+	// Create a new worker which drops every entry
+	worker := NewWorker(Worker{
+		ID:          1,
+		ServerName:  "artifactory.domain",
+		Stages:      []Stage{keepStage, dropStage, keepStage},
+		WorkQueue:   workQueue,
+		OutputQueue: outputQueue,
+		WaitGroup:   waitGroup,
+		Logger:      logger,
+	})
+	waitGroup.Add(1)
+
+	// Start the worker
+	worker.Start(context.Background())
+
+	// Send a work request to the work queue
+	workQueue <- WorkRequest{
+		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+
+	// Close the work queue and wait for the worker to finish
+	close(workQueue)
+	waitGroup.Wait()
+
+	// an empty result should be sent for the dropped entry
+	select {
+	case output := <-outputQueue:
+		if output.Line != "" {
+			t.Errorf("Worker.Start() - Expected entry to be dropped, got output: %s", output.Line)
+		}
+	default:
+		t.Error("Worker.Start() - No result was sent for the dropped entry")
+	}
+
+	// the stages after the dropping stage should not be called
+	if keepStage.processed != 1 || dropStage.processed != 1 {
+		t.Errorf("Worker.Start() - Expected each stage to process 1 entry, got: %d, %d", keepStage.processed, dropStage.processed)
+	}
+}
+
+func TestWorker_StartCancel(t *testing.T) {
+	workQueue := make(MockWorkQueue, 1)
+	outputQueue := make(MockOutputQueue)
+	waitGroup := &sync.WaitGroup{}
+	var abandoned int64
+
+	// Create a new worker of which nobody reads the output queue
+	worker := NewWorker(Worker{
+		ID:          1,
+		ServerName:  "artifactory.domain",
+		WorkQueue:   workQueue,
+		OutputQueue: outputQueue,
+		WaitGroup:   waitGroup,
+		Logger:      slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+	})
+	worker.abandoned = &abandoned
+	waitGroup.Add(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	worker.Start(ctx)
+
+	workQueue <- WorkRequest{
+		Line:      "2023-06-15T12:34:56.789Z|abcdefgh12345678|1.2.3.4|user|GET|/api/docker/registry-docker-remote/v2/alpine/curl/manifests/latest|200|-1|1234|567|user-agent123",
+		Delimiter: "|",
+		NumFields: 11,
+		Fields:    parser.DefaultFieldMap(),
+	}
+
+	// Cancelling the context stops the worker while it waits to send the result
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	waitGroup.Wait()
+
+	if abandoned != 1 {
+		t.Errorf("Worker.Start() - Expected 1 abandoned work request, got: %d", abandoned)
+	}
+}
+
 func TestMockLogger_Write(t *testing.T) {
 	logger := MockLogger{}
 
@@ -0,0 +1,135 @@
+package writer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+const (
+	// DefaultRetryInterval is the first wait before opening an output file is retried
+	DefaultRetryInterval = time.Second
+	// DefaultMaxRetryInterval is the longest wait before opening an output file is retried
+	DefaultMaxRetryInterval = time.Minute
+	// DefaultMaxBuffered is the number of lines which are buffered while no output file can be opened
+	DefaultMaxBuffered = 10000
+	// DefaultFailureBudget is how long a writer can be degraded before it gives up
+	DefaultFailureBudget = 10 * time.Minute
+)
+
+// degradation is the state of a writer which failed to open an output file
+type degradation struct {
+	since time.Time
+	// err is the error of the last attempt to open an output file
+	err error
+	// wait is the wait before the next attempt after the current one failed
+	wait  time.Duration
+	retry *time.Timer
+	// buffered are the requests which are written when an output file can be opened again
+	buffered []WriteRequest
+	// failed is set when the failure budget was exhausted
+	failed bool
+}
+
+// degrade records that opening an output file failed and schedules the next attempt
+// The writer gives up when it stays degraded for longer than the failure budget
+func (w *Writer) degrade(err error) {
+	now := time.Now()
+	if w.degraded == nil {
+		w.degraded = &degradation{since: now, wait: w.RetryInterval}
+		// the state is already degraded after an invalid state change
+		if w.State() != StateDegraded {
+			w.setState(StateDegraded)
+		}
+		w.Logger.Error("failed to open output file, buffering lines until it can be opened", "dir", w.Directory, "error", err)
+		metrics.WriterDegraded.Add(w.Prefix, 1)
+	} else {
+		w.Logger.Warn("failed to open output file again", "dir", w.Directory, "error", err)
+	}
+	metrics.WriterOpenFailures.Add(w.Prefix, 1)
+	metrics.SetHealth(w.health(), fmt.Errorf("failed to open output file: %v", err))
+
+	d := w.degraded
+	d.err = err
+	d.retry = time.NewTimer(d.wait)
+	d.wait *= 2
+	if d.wait > w.MaxRetryInterval {
+		d.wait = w.MaxRetryInterval
+	}
+
+	if !d.failed && now.Sub(d.since) >= w.FailureBudget {
+		d.failed = true
+		w.Logger.Error("writer failure budget exhausted", "degraded_for", now.Sub(d.since).Round(time.Second), "buffered", len(d.buffered))
+		if w.Failed != nil {
+			w.Failed(fmt.Errorf("failed to open an output file for %v: %v", now.Sub(d.since).Round(time.Second), err))
+		}
+	}
+}
+
+// reopen tries to open an output file again and writes the buffered requests when it succeeds
+func (w *Writer) reopen() {
+	if err := w.open(); err != nil {
+		w.degrade(err)
+		return
+	}
+
+	d := w.degraded
+	w.degraded = nil
+	w.setState(StateOpen)
+	w.Logger.Info("opened output file again, writing the buffered lines", "file", w.file.Name(), "lines", len(d.buffered), "degraded_for", time.Since(d.since).Round(time.Second))
+	metrics.WriterDegraded.Add(w.Prefix, -1)
+	metrics.WriterBuffered.Add(w.Prefix, -int64(len(d.buffered)))
+	metrics.SetHealth(w.health(), nil)
+
+	for _, request := range d.buffered {
+		w.handle(request)
+	}
+}
+
+// buffer keeps a request until an output file can be opened again
+// It returns false when the request can not be buffered because the sender retries failed requests
+func (w *Writer) buffer(request WriteRequest) bool {
+	if w.Acks != nil {
+		return false
+	}
+	w.degraded.buffered = append(w.degraded.buffered, request)
+	metrics.WriterBuffered.Add(w.Prefix, 1)
+	return true
+}
+
+// full returns whether the writer buffers as many requests as it can and should stop taking requests from the queue
+func (w *Writer) full() bool {
+	return w.degraded != nil && len(w.degraded.buffered) >= w.MaxBuffered
+}
+
+// retry returns the channel which fires when opening an output file should be retried, nil when the writer is not degraded
+func (w *Writer) retry() <-chan time.Time {
+	if w.degraded == nil {
+		return nil
+	}
+	return w.degraded.retry.C
+}
+
+// discard drops the buffered requests when the writer stops while it is degraded and returns their number
+// They are not covered by the checkpoint, so they are read again from the input on the next start
+func (w *Writer) discard() int {
+	if w.degraded == nil {
+		return 0
+	}
+	w.degraded.retry.Stop()
+	n := len(w.degraded.buffered)
+	if n > 0 {
+		w.Logger.Error("stopping the writer without writing the buffered lines", "lines", n)
+		metrics.WriterBuffered.Add(w.Prefix, -int64(n))
+		metrics.WriterDropped.Add(w.Prefix, int64(n))
+	}
+	metrics.WriterDegraded.Add(w.Prefix, -1)
+	w.degraded = nil
+	return n
+}
+
+// health returns the name of the writer in the health of the components
+func (w *Writer) health() string {
+	return "writer " + w.Prefix
+}
@@ -0,0 +1,174 @@
+package writer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+// waitFor polls the condition until it is true or a second passed
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestWriter_Degraded(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	outdir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeQueue := make(chan WriteRequest)
+	doneChan := make(chan bool)
+	failed := make(chan error, 1)
+	writer := NewWriter(Writer{
+		Directory:        outdir,
+		Prefix:           "degraded",
+		WriteQueue:       writeQueue,
+		DoneChan:         doneChan,
+		Logger:           slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		RetryInterval:    5 * time.Millisecond,
+		MaxRetryInterval: 10 * time.Millisecond,
+		MaxBuffered:      2,
+		FailureBudget:    30 * time.Millisecond,
+		Failed:           func(err error) { failed <- err },
+		CheckInterval:    5 * time.Millisecond,
+		FlushInterval:    5 * time.Millisecond,
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+
+	// The output directory disappears, so no new output file can be opened and the lines are buffered
+	if err = os.RemoveAll(outdir); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return writer.State() == StateDegraded }) {
+		t.Fatalf("State() - Expected state: %v, got: %v", StateDegraded, writer.State())
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 2", Offset: 20}
+	writeQueue <- WriteRequest{Offset: 30}
+
+	// The buffer is full, so the next line waits in the queue
+	select {
+	case writeQueue <- WriteRequest{Line: "Log entry 3", Offset: 40}:
+		t.Error("Start() - Expected the writer to stop taking lines when its buffer is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if writer.Checkpoint() != 10 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 10, got: %d", writer.Checkpoint())
+	}
+	if _, ok := metrics.Health()["writer degraded"]; !ok {
+		t.Errorf("Health() - Expected the writer to be unhealthy, got: %v", metrics.Health())
+	}
+
+	// The failure budget is exhausted while the directory is missing
+	select {
+	case err = <-failed:
+		if err == nil {
+			t.Error("Failed - Expected an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Failed - Expected to be called when the failure budget is exhausted")
+	}
+
+	// The writer recovers when the directory is back and writes the buffered lines in order
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 3", Offset: 40}
+	if !waitFor(func() bool { return writer.Checkpoint() == 40 }) {
+		t.Errorf("Checkpoint() - Expected checkpoint: 40, got: %d", writer.Checkpoint())
+	}
+	if _, ok := metrics.Health()["writer degraded"]; ok {
+		t.Errorf("Health() - Expected the writer to be healthy, got: %v", metrics.Health())
+	}
+	close(writeQueue)
+	<-doneChan
+
+	files, err := filepath.Glob(filepath.Join(outdir, "degraded-*.log"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one output file after recovering, got: %v, %v", files, err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "Log entry 2\nLog entry 3\n" {
+		t.Errorf("Expected the buffered lines to be written in order, got: %q", content)
+	}
+}
+
+func TestWriter_DegradedAcks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	outdir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeQueue := make(chan WriteRequest)
+	acks := make(chan error)
+	doneChan := make(chan bool)
+	writer := NewWriter(Writer{
+		Directory:     outdir,
+		Prefix:        "degraded-acks",
+		WriteQueue:    writeQueue,
+		DoneChan:      doneChan,
+		Acks:          acks,
+		Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		RetryInterval: 5 * time.Millisecond,
+		CheckInterval: 5 * time.Millisecond,
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	// The lines are not buffered when the sender retries failed ones
+	if err = os.RemoveAll(outdir); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return writer.State() == StateDegraded }) {
+		t.Fatalf("State() - Expected state: %v, got: %v", StateDegraded, writer.State())
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+	if err = <-acks; err == nil || !strings.Contains(err.Error(), "not open") {
+		t.Errorf("Start() - Expected the write to fail while the writer is degraded, got: %v", err)
+	}
+
+	// The retried line is written after the writer recovered
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool {
+		writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+		return <-acks == nil
+	}) {
+		t.Error("Start() - Expected the write to succeed after the writer recovered")
+	}
+	close(writeQueue)
+	<-doneChan
+
+	if writer.Checkpoint() != 10 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 10, got: %d", writer.Checkpoint())
+	}
+}
@@ -0,0 +1,173 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Format is the encoding of the output files
+type Format string
+
+const (
+	// JSON writes the lines as they are, one per line
+	JSON Format = "json"
+	// NDJSON writes the JSON lines with a schema_version field added to each of them
+	NDJSON Format = "ndjson"
+	// CSV writes RFC 4180 records with a header line in each file
+	CSV Format = "csv"
+	// Parquet writes columnar Parquet files
+	Parquet Format = "parquet"
+)
+
+// Extension returns the file name extension of the output files of a format
+func (f Format) Extension() string {
+	switch f {
+	case CSV:
+		return ".csv"
+	case Parquet:
+		return ".parquet"
+	default:
+		return ".log"
+	}
+}
+
+// Validate checks that the format is known
+func (f Format) Validate() error {
+	switch f {
+	case JSON, NDJSON, CSV, Parquet:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, expected json, ndjson, csv or parquet", f)
+	}
+}
+
+// Encoder writes the lines of an output file in a format
+// The lines are JSON objects, except for the JSON format which writes any line as it is
+type Encoder interface {
+	// Encode writes a line
+	Encode(line string) error
+	// Close writes what is left to complete the file, it does not close the underlying writer
+	Close() error
+}
+
+// NewEncoder creates an encoder which writes a new output file in a format
+// The fields are the columns of the CSV and Parquet formats
+func NewEncoder(format Format, w io.Writer, fields []Field, schemaVersion int) (Encoder, error) {
+	switch format {
+	case JSON, "":
+		return &jsonEncoder{w: w}, nil
+	case NDJSON:
+		return &ndjsonEncoder{w: w, version: schemaVersion}, nil
+	case CSV:
+		return newCSVEncoder(w, fields)
+	case Parquet:
+		return newParquetEncoder(w, fields, schemaVersion)
+	default:
+		return nil, Format(format).Validate()
+	}
+}
+
+// jsonEncoder writes the lines as they are
+type jsonEncoder struct {
+	w io.Writer
+}
+
+func (e *jsonEncoder) Encode(line string) error {
+	_, err := io.WriteString(e.w, line+"\n")
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	return nil
+}
+
+// ndjsonEncoder adds the schema version to each JSON object
+type ndjsonEncoder struct {
+	w       io.Writer
+	version int
+}
+
+func (e *ndjsonEncoder) Encode(line string) error {
+	if len(line) < 2 || line[0] != '{' {
+		return fmt.Errorf("line is not a JSON object: %.40q", line)
+	}
+	separator := ","
+	if line[1] == '}' {
+		separator = ""
+	}
+	_, err := io.WriteString(e.w, `{"schema_version":`+strconv.Itoa(e.version)+separator+line[1:]+"\n")
+	return err
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// csvEncoder writes a record with the fields of each JSON object
+type csvEncoder struct {
+	w      *csv.Writer
+	fields []Field
+	record []string
+}
+
+func newCSVEncoder(w io.Writer, fields []Field) (*csvEncoder, error) {
+	e := &csvEncoder{
+		w:      csv.NewWriter(w),
+		fields: fields,
+		record: make([]string, len(fields)),
+	}
+	for i, field := range fields {
+		e.record[i] = field.Name
+	}
+	if err := e.write(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvEncoder) Encode(line string) error {
+	values, err := decode(line)
+	if err != nil {
+		return err
+	}
+	for i, field := range e.fields {
+		e.record[i] = ""
+		switch value := values[field.Name].(type) {
+		case string:
+			e.record[i] = value
+		case json.Number:
+			e.record[i] = value.String()
+		case bool:
+			e.record[i] = strconv.FormatBool(value)
+		}
+	}
+	return e.write()
+}
+
+func (e *csvEncoder) Close() error {
+	return nil
+}
+
+// write writes the record and flushes it, so a written line is in the file like with the other formats
+func (e *csvEncoder) write() error {
+	if err := e.w.Write(e.record); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// decode decodes a JSON object keeping the numbers as they are written
+func decode(line string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(line)))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("line is not a JSON object: %v", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,192 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// testRecord describes the fields of the encoded test lines
+type testRecord struct {
+	Timestamp string  `json:"billing_timestamp"`
+	Quantity  int64   `json:"quantity"`
+	Cost      float64 `json:"cost,omitempty"`
+	Service   bool    `json:"service_account,omitempty"`
+	Internal  string  `json:"-"`
+}
+
+var testLines = []string{
+	`{"billing_timestamp":"2024-03-04 10:00:00.000","quantity":1234,"cost":0.5,"service_account":true}`,
+	`{"billing_timestamp":"2024-03-04 11:00:00.000, \"quoted\"","quantity":7}`,
+}
+
+func TestFieldsOf(t *testing.T) {
+	want := []Field{{"billing_timestamp", String}, {"quantity", Int}, {"cost", Float}, {"service_account", Bool}}
+	got := FieldsOf(testRecord{})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("FieldsOf() - Expected fields: %v, got: %v", want, got)
+	}
+}
+
+func encode(t *testing.T, format Format) []byte {
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(format, &buf, FieldsOf(testRecord{}), 2)
+	if err != nil {
+		t.Fatal("NewEncoder() returned an error:", err)
+	}
+	for _, line := range testLines {
+		if err = encoder.Encode(line); err != nil {
+			t.Fatal("Encode() returned an error:", err)
+		}
+	}
+	if err = encoder.Close(); err != nil {
+		t.Fatal("Close() returned an error:", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncoder_Text(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{JSON, testLines[0] + "\n" + testLines[1] + "\n"},
+		{NDJSON, `{"schema_version":2,` + testLines[0][1:] + "\n" + `{"schema_version":2,` + testLines[1][1:] + "\n"},
+		{CSV, "billing_timestamp,quantity,cost,service_account\n" +
+			"2024-03-04 10:00:00.000,1234,0.5,true\n" +
+			"\"2024-03-04 11:00:00.000, \"\"quoted\"\"\",7,,\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(encode(t, tt.format)); got != tt.want {
+			t.Errorf("Encode() - Expected %v output:\n%s\ngot:\n%s", tt.format, tt.want, got)
+		}
+	}
+}
+
+func TestEncoder_Invalid(t *testing.T) {
+	if _, err := NewEncoder("xml", &bytes.Buffer{}, nil, 1); err == nil {
+		t.Error("NewEncoder() - Expected error for unknown format")
+	}
+	for _, format := range []Format{NDJSON, CSV, Parquet} {
+		encoder, _ := NewEncoder(format, &bytes.Buffer{}, FieldsOf(testRecord{}), 1)
+		if err := encoder.Encode("raw input line"); err == nil {
+			t.Errorf("Encode() - Expected error for a line which is not JSON in %v format", format)
+		}
+	}
+}
+
+func TestEncoder_Parquet(t *testing.T) {
+	data := encode(t, Parquet)
+
+	if string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("Encode() - Expected Parquet magic at the start and the end of the file")
+	}
+	length := binary.LittleEndian.Uint32(data[len(data)-8:])
+	footer := data[len(data)-8-int(length) : len(data)-8]
+	metadata := readThriftStruct(t, bytes.NewReader(footer))
+
+	// FileMetaData: 2 schema, 3 num_rows, 4 row_groups, 5 key_value_metadata
+	schema := metadata[2].([]interface{})
+	if len(schema) != 5 || schema[0].(map[int16]interface{})[5] != int64(4) {
+		t.Fatalf("Encode() - Expected schema root with 4 children, got: %v", schema)
+	}
+	for i, want := range []string{"billing_timestamp", "quantity", "cost", "service_account"} {
+		if name := schema[i+1].(map[int16]interface{})[4]; name != want {
+			t.Errorf("Encode() - Expected column %d: %v, got: %v", i, want, name)
+		}
+	}
+	if metadata[3] != int64(2) {
+		t.Errorf("Encode() - Expected 2 rows, got: %v", metadata[3])
+	}
+	keyValue := metadata[5].([]interface{})[0].(map[int16]interface{})
+	if keyValue[1] != "schema_version" || keyValue[2] != "2" {
+		t.Errorf("Encode() - Expected schema version in the key value metadata, got: %v", keyValue)
+	}
+
+	// read the values of each column out of its data page
+	columns := metadata[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{})
+	values := make([][]byte, len(columns))
+	for i, column := range columns {
+		meta := column.(map[int16]interface{})[3].(map[int16]interface{})
+		page := bytes.NewReader(data[meta[9].(int64):])
+		header := readThriftStruct(t, page)
+		if header[1] != int64(parquetDataPage) || header[5].(map[int16]interface{})[1] != int64(2) {
+			t.Fatalf("Encode() - Unexpected page header of column %d: %v", i, header)
+		}
+		values[i] = make([]byte, header[2].(int64))
+		page.Read(values[i])
+	}
+
+	if got := string(values[0][4:27]); got != "2024-03-04 10:00:00.000" {
+		t.Errorf("Encode() - Expected first timestamp, got: %q", got)
+	}
+	if got := binary.LittleEndian.Uint64(values[1][8:]); got != 7 {
+		t.Errorf("Encode() - Expected second quantity 7, got: %d", got)
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(values[2])); got != 0.5 {
+		t.Errorf("Encode() - Expected first cost 0.5, got: %v", got)
+	}
+	if len(values[3]) != 1 || values[3][0] != 0b01 {
+		t.Errorf("Encode() - Expected bit packed booleans true and false, got: %b", values[3])
+	}
+}
+
+// readThriftStruct decodes a struct of the Thrift compact protocol into its fields by ID
+func readThriftStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatal("Failed to read Thrift struct:", err)
+		}
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(readZigzag(t, r))
+		}
+		last = id
+		fields[id] = readThriftValue(t, r, b&0x0f)
+	}
+}
+
+func readThriftValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return readZigzag(t, r)
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(r)
+		s := make([]byte, n)
+		r.Read(s)
+		return string(s)
+	case thriftList:
+		b, _ := r.ReadByte()
+		size := uint64(b >> 4)
+		if size == 15 {
+			size, _ = binary.ReadUvarint(r)
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = readThriftValue(t, r, b&0x0f)
+		}
+		return list
+	case thriftStruct:
+		return readThriftStruct(t, r)
+	default:
+		t.Fatalf("Unexpected Thrift type %d", typ)
+		return nil
+	}
+}
+
+func readZigzag(t *testing.T, r *bytes.Reader) int64 {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatal("Failed to read Thrift varint:", err)
+	}
+	return int64(n>>1) ^ -int64(n&1)
+}
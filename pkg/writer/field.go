@@ -0,0 +1,47 @@
+package writer
+
+import (
+	"reflect"
+	"strings"
+)
+
+// FieldType is the type of an output field
+type FieldType string
+
+const (
+	String FieldType = "string"
+	Int    FieldType = "int"
+	Float  FieldType = "float"
+	Bool   FieldType = "bool"
+)
+
+// Field is a field of the output records which the CSV and Parquet encoders write as a column
+type Field struct {
+	Name string
+	Type FieldType
+}
+
+// FieldsOf returns the fields of a struct in the order they are declared, named by their JSON tags
+// Fields without a JSON tag or with the tag "-" are left out
+func FieldsOf(v interface{}) []Field {
+	var fields []Field
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		field := Field{Name: name, Type: String}
+		switch t.Field(i).Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			field.Type = Int
+		case reflect.Float32, reflect.Float64:
+			field.Type = Float
+		case reflect.Bool:
+			field.Type = Bool
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
@@ -0,0 +1,97 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"time"
+)
+
+// FileEvent describes an output file which was finalized and is not written anymore
+type FileEvent struct {
+	File    string `json:"file"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	// From and To are the times the file was opened and finalized
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// SHA256 is the checksum of the content of the file
+	SHA256 string `json:"sha256"`
+}
+
+// fileStats collects what was written to the current output file
+// The lines are buffered before they reach the file, so only the bytes which were written to the file are hashed
+// and a line is only counted once all of it was written
+type fileStats struct {
+	name    string
+	records int64
+	bytes   int64
+	opened  time.Time
+	hash    hash.Hash
+	// encoded is the size of the encoded content and lines are the lines which are not completely in the file yet
+	encoded int64
+	lines   []bufferedLine
+}
+
+// bufferedLine is a line which was encoded for the file, end is the size of the encoded content after it
+type bufferedLine struct {
+	end     int64
+	request WriteRequest
+}
+
+// newFileStats starts collecting the stats of a file which was just opened
+func newFileStats(name string) *fileStats {
+	return &fileStats{
+		name:   name,
+		opened: time.Now().UTC(),
+		hash:   sha256.New(),
+	}
+}
+
+// Write records the encoded data which is buffered for the file
+func (s *fileStats) Write(data []byte) (int, error) {
+	s.encoded += int64(len(data))
+	return len(data), nil
+}
+
+// line records that the line of a request was encoded
+func (s *fileStats) line(request WriteRequest) {
+	s.lines = append(s.lines, bufferedLine{end: s.encoded, request: request})
+	s.commit()
+}
+
+// written records the data which was written to the file
+func (s *fileStats) written(data []byte) {
+	s.bytes += int64(len(data))
+	s.hash.Write(data)
+	s.commit()
+}
+
+// commit counts the lines which are completely in the file
+func (s *fileStats) commit() {
+	for len(s.lines) > 0 && s.lines[0].end <= s.bytes {
+		s.records++
+		s.lines = s.lines[1:]
+	}
+}
+
+// unwritten returns the requests whose lines are not completely in the file
+func (s *fileStats) unwritten() []WriteRequest {
+	requests := make([]WriteRequest, 0, len(s.lines))
+	for _, line := range s.lines {
+		requests = append(requests, line.request)
+	}
+	return requests
+}
+
+// event returns the event of the file being finalized now
+func (s *fileStats) event() FileEvent {
+	return FileEvent{
+		File:    s.name,
+		Records: s.records,
+		Bytes:   s.bytes,
+		From:    s.opened,
+		To:      time.Now().UTC(),
+		SHA256:  hex.EncodeToString(s.hash.Sum(nil)),
+	}
+}
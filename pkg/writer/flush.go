@@ -0,0 +1,101 @@
+package writer
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+const (
+	// DefaultBufferSize is the size of the buffer the lines are collected in before they are written to the output file
+	DefaultBufferSize = 64 * 1024
+	// DefaultFlushInterval is how often the buffered lines are written to the output file
+	DefaultFlushInterval = time.Second
+)
+
+// Durability is when the output files are synced to disk
+type Durability string
+
+const (
+	// DurabilityNone leaves syncing the output files to the operating system
+	DurabilityNone Durability = "none"
+	// DurabilityFlush syncs the output file each time the buffered lines are written to it
+	DurabilityFlush Durability = "flush"
+	// DurabilityRotation syncs the output file once before it is closed
+	DurabilityRotation Durability = "rotation"
+)
+
+// Validate checks that the durability is known
+func (d Durability) Validate() error {
+	switch d {
+	case DurabilityNone, DurabilityFlush, DurabilityRotation:
+		return nil
+	default:
+		return fmt.Errorf("unknown durability %q, expected none, flush or rotation", d)
+	}
+}
+
+// fileSink is the destination of the buffer of the current output file
+// The buffer writes to it when it is full and when it is flushed, so the file is synced on each flush when sync is set
+// The stats record the data which was written to the file
+type fileSink struct {
+	file   *os.File
+	stats  *fileStats
+	prefix string
+	sync   bool
+}
+
+func (s *fileSink) Write(data []byte) (int, error) {
+	n, err := s.file.Write(data)
+	s.stats.written(data[:n])
+	if err != nil {
+		return n, err
+	}
+	metrics.WriterFlushes.Add(s.prefix, 1)
+	if s.sync {
+		if err = s.file.Sync(); err != nil {
+			return n, err
+		}
+		metrics.WriterSyncs.Add(s.prefix, 1)
+	}
+	return n, nil
+}
+
+// flush writes the buffered lines to the output file and advances the checkpoint past them
+// The buffer keeps failing after a failed write, so the writer opens a new output file in that case
+// and writes the lines which did not reach the failed file to the new one
+func (w *Writer) flush() error {
+	if w.out == nil {
+		return nil
+	}
+	if err := w.out.Flush(); err != nil {
+		w.Logger.Error("failed to flush output file, writing the buffered lines to a new one", "file", w.file.Name(), "error", err)
+		w.rotate()
+		return err
+	}
+	w.advance()
+	return nil
+}
+
+// advance moves the checkpoint to the input offset of the last request which was flushed
+func (w *Writer) advance() {
+	if w.pending > 0 {
+		atomic.StoreInt64(w.checkpoint, w.pending)
+		w.pending = 0
+	}
+}
+
+// rewrite writes the requests whose lines were not written to the previous output file again
+// The checkpoint did not advance past them, so it only covers them once they are in the new file
+func (w *Writer) rewrite(requests []WriteRequest) {
+	if len(requests) == 0 {
+		return
+	}
+	w.Logger.Warn("writing the lines which were not written to the previous output file again", "lines", len(requests))
+	for _, request := range requests {
+		w.handle(request)
+	}
+}
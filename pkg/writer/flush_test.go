@@ -0,0 +1,339 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+// syncs returns the number of syncs of the output files of a writer
+func syncs(prefix string) int64 {
+	return count(metrics.WriterSyncs, prefix)
+}
+
+// count returns the value of a writer in a metric, 0 when it was not counted yet
+func count(metric *expvar.Map, prefix string) int64 {
+	if v, ok := metric.Get(prefix).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// content returns the content of the only output file of a writer in the directory
+func content(t *testing.T, dir, prefix string) string {
+	files, err := filepath.Glob(filepath.Join(dir, prefix+"-*.log"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one output file, got: %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDurability_Validate(t *testing.T) {
+	tests := []struct {
+		durability Durability
+		valid      bool
+	}{
+		{DurabilityNone, true},
+		{DurabilityFlush, true},
+		{DurabilityRotation, true},
+		{"", false},
+		{"always", false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.durability.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) - Expected valid: %v, got error: %v", tt.durability, tt.valid, err)
+		}
+	}
+}
+
+func TestWriter_Flush(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name          string
+		bufferSize    int
+		flushInterval time.Duration
+		// flushed is whether the line is in the file while the writer is running
+		flushed bool
+	}{
+		{"buffered", 1024, time.Hour, false},
+		{"buffer full", 16, time.Hour, true},
+		{"interval", 1024, 5 * time.Millisecond, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := "flush-" + strings.ReplaceAll(tt.name, " ", "-")
+			writeQueue := make(chan WriteRequest)
+			doneChan := make(chan bool)
+			writer := NewWriter(Writer{
+				Directory:     dir,
+				Prefix:        prefix,
+				WriteQueue:    writeQueue,
+				DoneChan:      doneChan,
+				Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+				BufferSize:    tt.bufferSize,
+				FlushInterval: tt.flushInterval,
+			})
+			if err = writer.Start(); err != nil {
+				t.Fatal("Start() returned an error:", err)
+			}
+
+			offset := int64(10 * (i + 1))
+			writeQueue <- WriteRequest{Line: "Log entry which is longer than the buffer", Offset: offset}
+			writeQueue <- WriteRequest{}
+
+			flushed := waitFor(func() bool { return content(t, dir, prefix) != "" })
+			if flushed != tt.flushed {
+				t.Errorf("Expected the line to be flushed: %v, got: %v", tt.flushed, flushed)
+			}
+			// the checkpoint only covers the lines which were flushed by the writer
+			if tt.flushInterval == time.Hour && writer.Checkpoint() != 0 {
+				t.Errorf("Checkpoint() - Expected checkpoint: 0, got: %d", writer.Checkpoint())
+			}
+
+			// the buffered lines are flushed when the writer stops
+			close(writeQueue)
+			<-doneChan
+			if got := content(t, dir, prefix); got != "Log entry which is longer than the buffer\n" {
+				t.Errorf("Expected the line in the output file, got: %q", got)
+			}
+			if writer.Checkpoint() != offset {
+				t.Errorf("Checkpoint() - Expected checkpoint: %d, got: %d", offset, writer.Checkpoint())
+			}
+		})
+	}
+}
+
+func TestWriter_FlushAcks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeQueue := make(chan WriteRequest)
+	acks := make(chan error)
+	doneChan := make(chan bool)
+	writer := NewWriter(Writer{
+		Directory:     dir,
+		Prefix:        "flush-acks",
+		WriteQueue:    writeQueue,
+		DoneChan:      doneChan,
+		Acks:          acks,
+		Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		FlushInterval: time.Hour,
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	// the line is in the file when it is acknowledged
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+	if err = <-acks; err != nil {
+		t.Fatal("Expected the write to succeed, got:", err)
+	}
+	if got := content(t, dir, "flush-acks"); got != "Log entry 1\n" {
+		t.Errorf("Expected the acknowledged line in the output file, got: %q", got)
+	}
+	if writer.Checkpoint() != 10 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 10, got: %d", writer.Checkpoint())
+	}
+	close(writeQueue)
+	<-doneChan
+}
+
+func TestWriter_Durability(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		durability Durability
+		// synced is the number of syncs after each line was flushed and then the file was closed
+		synced int64
+	}{
+		{DurabilityNone, 0},
+		{DurabilityFlush, 2},
+		{DurabilityRotation, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.durability), func(t *testing.T) {
+			prefix := "durability-" + string(tt.durability)
+			writer := NewWriter(Writer{
+				Directory:  dir,
+				Prefix:     prefix,
+				Logger:     slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+				Durability: tt.durability,
+			})
+			synced := syncs(prefix)
+			if err = writer.open(); err != nil {
+				t.Fatal("open() returned an error:", err)
+			}
+			for _, line := range []string{"Log entry 1", "Log entry 2"} {
+				if err = writer.write(WriteRequest{Line: line}); err != nil {
+					t.Fatal("write() returned an error:", err)
+				}
+				if err = writer.flush(); err != nil {
+					t.Fatal("flush() returned an error:", err)
+				}
+			}
+			writer.closeFile()
+
+			if got := syncs(prefix) - synced; got != tt.synced {
+				t.Errorf("Expected %d syncs, got: %d", tt.synced, got)
+			}
+			if got := content(t, dir, prefix); got != "Log entry 1\nLog entry 2\n" {
+				t.Errorf("Expected both lines in the output file, got: %q", got)
+			}
+		})
+	}
+}
+
+func TestWriter_FlushFailure(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var events []FileEvent
+	writer := NewWriter(Writer{
+		Directory: dir,
+		Prefix:    "flush-failure",
+		Logger:    slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		Finalized: func(event FileEvent) { events = append(events, event) },
+	})
+	if err = writer.open(); err != nil {
+		t.Fatal("open() returned an error:", err)
+	}
+	writer.setState(StateOpen)
+	writer.handle(WriteRequest{Line: "Log entry 1", Offset: 10})
+	if err = writer.flush(); err != nil {
+		t.Fatal("flush() returned an error:", err)
+	}
+
+	// the buffered line is not written when the output file can not be written
+	writer.handle(WriteRequest{Line: "Log entry 2", Offset: 20})
+	writer.file.Close()
+	if err = writer.flush(); err == nil {
+		t.Fatal("flush() - Expected an error for a closed output file")
+	}
+
+	// the finalized file only describes the line which was written to it
+	sum := sha256.Sum256([]byte("Log entry 1\n"))
+	if len(events) != 1 || events[0].Records != 1 || events[0].Bytes != 12 || events[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Finalized - Expected 1 record of 12 bytes with checksum %x, got: %+v", sum, events)
+	}
+
+	// the checkpoint stays before the line until it was written again to the new file with the following lines
+	if writer.Checkpoint() != 10 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 10, got: %d", writer.Checkpoint())
+	}
+	writer.handle(WriteRequest{Line: "Log entry 3", Offset: 30})
+	if err = writer.flush(); err != nil {
+		t.Fatal("flush() returned an error:", err)
+	}
+	file := writer.file.Name()
+	writer.closeFile()
+	if writer.Checkpoint() != 30 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 30, got: %d", writer.Checkpoint())
+	}
+	if data, _ := os.ReadFile(file); string(data) != "Log entry 2\nLog entry 3\n" {
+		t.Errorf("Expected the line which was not written in the new output file, got: %q", data)
+	}
+	if len(events) != 2 || events[1].Records != 2 {
+		t.Errorf("Finalized - Expected 2 records in the new output file, got: %+v", events)
+	}
+}
+
+// BenchmarkWriter_Durability writes lines through the queue of a running writer with each durability policy
+// The buffer is small, so the lines are written to the file and synced often like they are under load
+// The line by line case writes each line to the file on its own, like an unbuffered writer
+// The acks cases wait for each line to be acknowledged like the write-ahead log does, which flushes each line on its own,
+// so the buffer does not batch the writes and the flush policy syncs every line
+func BenchmarkWriter_Durability(b *testing.B) {
+	line := `{"timestamp":"2024-01-02T03:04:05Z","trace_id":"0123456789abcdef","ip":"10.0.0.1","user_name":"user","repository":"libs-release","size":123456}`
+
+	benchmarks := []struct {
+		name       string
+		bufferSize int
+		durability Durability
+		acks       bool
+	}{
+		{"line by line", 1, DurabilityNone, false},
+		{"none", 4096, DurabilityNone, false},
+		{"flush", 4096, DurabilityFlush, false},
+		{"rotation", 4096, DurabilityRotation, false},
+		{"none with acks", 4096, DurabilityNone, true},
+		{"flush with acks", 4096, DurabilityFlush, true},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			dir, err := os.MkdirTemp("", "bench")
+			if err != nil {
+				b.Fatal("Failed to create temporary directory:", err)
+			}
+			defer os.RemoveAll(dir)
+
+			writeQueue := make(chan WriteRequest, 100)
+			doneChan := make(chan bool)
+			var acks chan error
+			if bm.acks {
+				acks = make(chan error)
+			}
+			writer := NewWriter(Writer{
+				Directory:     dir,
+				Prefix:        "bench",
+				WriteQueue:    writeQueue,
+				DoneChan:      doneChan,
+				Acks:          acks,
+				Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+				BufferSize:    bm.bufferSize,
+				FlushInterval: 10 * time.Millisecond,
+				Durability:    bm.durability,
+			})
+			if err = writer.Start(); err != nil {
+				b.Fatal("Start() returned an error:", err)
+			}
+			b.SetBytes(int64(len(line) + 1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				writeQueue <- WriteRequest{Line: line, Offset: int64(i + 1)}
+				if acks != nil {
+					if err = <-acks; err != nil {
+						b.Fatal("Expected the write to succeed, got:", err)
+					}
+				}
+			}
+			// the last lines are written and the file is synced when the writer stops
+			close(writeQueue)
+			<-doneChan
+			b.StopTimer()
+			if writer.Checkpoint() != int64(b.N) {
+				b.Errorf("Checkpoint() - Expected checkpoint: %d, got: %d", b.N, writer.Checkpoint())
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestSuffix is the file name suffix of the manifest written next to an output file
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes an output file and the settings it was written with
+type Manifest struct {
+	File     string            `json:"file"`
+	Created  string            `json:"created"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ManifestPath returns the path of the manifest of an output file
+func ManifestPath(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ManifestSuffix
+}
+
+// WriteManifest writes the manifest of an output file next to it
+func WriteManifest(file string, metadata map[string]string) error {
+	manifest := Manifest{
+		File:     filepath.Base(file),
+		Created:  time.Now().UTC().Format(time.RFC3339),
+		Metadata: metadata,
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err = os.WriteFile(ManifestPath(file), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
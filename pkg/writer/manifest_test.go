@@ -0,0 +1,124 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestPath(t *testing.T) {
+	got := ManifestPath("/tmp/artifactory-traffic-2023-06-15-abcde123.log")
+	want := "/tmp/artifactory-traffic-2023-06-15-abcde123.manifest.json"
+	if got != want {
+		t.Errorf("ManifestPath() = %v, want %v", got, want)
+	}
+}
+
+func TestWriter_OpenManifest(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer := NewWriter(Writer{
+		Directory: dir,
+		Logger:    slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		Metadata: func(offset int64) map[string]string {
+			return map[string]string{"privacy_ip": "truncate"}
+		},
+	})
+
+	// Open a new output file which should write its manifest
+	if err = writer.open(); err != nil {
+		t.Fatal("open() returned an error:", err)
+	}
+	defer writer.closeFile()
+
+	data, err := os.ReadFile(ManifestPath(writer.file.Name()))
+	if err != nil {
+		t.Fatal("Failed to read manifest:", err)
+	}
+
+	var manifest Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal("Failed to parse manifest:", err)
+	}
+	if !strings.HasSuffix(writer.file.Name(), manifest.File) {
+		t.Errorf("Manifest file = %v, want %v", manifest.File, writer.file.Name())
+	}
+	if manifest.Metadata["privacy_ip"] != "truncate" {
+		t.Errorf("Manifest metadata = %v, want privacy_ip: truncate", manifest.Metadata)
+	}
+}
+
+func TestWriter_Changed(t *testing.T) {
+	// Create a temporary directory for testing
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the lines after offset 20 are written with another secret
+	secret := func(offset int64) string {
+		if offset > 20 {
+			return "rotated"
+		}
+		return "secret"
+	}
+	writeQueue := make(chan WriteRequest)
+	doneChan := make(chan bool)
+	writer := NewWriter(Writer{
+		Directory:  dir,
+		WriteQueue: writeQueue,
+		DoneChan:   doneChan,
+		Logger:     slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		Metadata: func(offset int64) map[string]string {
+			return map[string]string{"privacy_secret_id": secret(offset)}
+		},
+		Changed: func(from, to int64) bool {
+			return secret(from) != secret(to)
+		},
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+	for _, offset := range []int64{10, 20, 30, 40} {
+		writeQueue <- WriteRequest{Line: fmt.Sprintf("Log entry %d", offset), Offset: offset}
+	}
+	close(writeQueue)
+	<-doneChan
+
+	// each file only contains the lines of the secret in its manifest
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 output files, got: %v, %v", files, err)
+	}
+	want := map[string]string{
+		"secret":  "Log entry 10\nLog entry 20\n",
+		"rotated": "Log entry 30\nLog entry 40\n",
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(ManifestPath(file))
+		if err != nil {
+			t.Fatal("Failed to read manifest:", err)
+		}
+		var manifest Manifest
+		if err = json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal("Failed to parse manifest:", err)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(content); got != want[manifest.Metadata["privacy_secret_id"]] {
+			t.Errorf("Expected the lines of secret %v in %v, got: %q", manifest.Metadata["privacy_secret_id"], file, got)
+		}
+	}
+}
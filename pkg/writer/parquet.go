@@ -0,0 +1,368 @@
+package writer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// parquetRowGroupSize is the number of rows which are buffered before they are written as a row group
+const parquetRowGroupSize = 10000
+
+// parquetMagic starts and ends each Parquet file
+const parquetMagic = "PAR1"
+
+// Parquet format enum values, see https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired     = 0
+	parquetUTF8         = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// parquetEncoder buffers the fields of the JSON objects per column and writes them as uncompressed, PLAIN encoded
+// row groups of required columns, the file metadata is written when it is closed
+type parquetEncoder struct {
+	w       io.Writer
+	fields  []Field
+	version int
+
+	// offset is the number of bytes written to the file
+	offset    int64
+	columns   [][]byte
+	bits      []uint8
+	rows      int64
+	numRows   int64
+	rowGroups []parquetRowGroup
+}
+
+// parquetRowGroup describes a written row group
+type parquetRowGroup struct {
+	rows    int64
+	size    int64
+	columns []parquetColumnChunk
+}
+
+// parquetColumnChunk describes a written column of a row group
+type parquetColumnChunk struct {
+	offset int64
+	size   int64
+}
+
+func newParquetEncoder(w io.Writer, fields []Field, schemaVersion int) (*parquetEncoder, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("parquet output requires at least one field")
+	}
+	e := &parquetEncoder{
+		w:       w,
+		fields:  fields,
+		version: schemaVersion,
+		columns: make([][]byte, len(fields)),
+		bits:    make([]uint8, len(fields)),
+	}
+	if err := e.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *parquetEncoder) Encode(line string) error {
+	values, err := decode(line)
+	if err != nil {
+		return err
+	}
+	for i, field := range e.fields {
+		if err = e.add(i, field, values[field.Name]); err != nil {
+			return fmt.Errorf("invalid value of field %v: %v", field.Name, err)
+		}
+	}
+	e.rows++
+	if e.rows >= parquetRowGroupSize {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *parquetEncoder) Close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	footer := e.metadata()
+	if err := e.write(footer); err != nil {
+		return err
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	return e.write(append(length, parquetMagic...))
+}
+
+// add appends the PLAIN encoded value of a field to its column, missing values are written as zero values
+func (e *parquetEncoder) add(i int, field Field, value interface{}) error {
+	column := e.columns[i]
+	switch field.Type {
+	case Int:
+		var n int64
+		if number, ok := value.(json.Number); ok {
+			var err error
+			if n, err = strconv.ParseInt(number.String(), 10, 64); err != nil {
+				return err
+			}
+		}
+		column = binary.LittleEndian.AppendUint64(column, uint64(n))
+	case Float:
+		var f float64
+		if number, ok := value.(json.Number); ok {
+			var err error
+			if f, err = number.Float64(); err != nil {
+				return err
+			}
+		}
+		column = binary.LittleEndian.AppendUint64(column, math.Float64bits(f))
+	case Bool:
+		// booleans are bit packed, the least significant bit first
+		if e.rows%8 == 0 {
+			column = append(column, 0)
+		}
+		if b, _ := value.(bool); b {
+			column[len(column)-1] |= 1 << (e.rows % 8)
+		}
+	default:
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = strconv.FormatBool(v)
+		}
+		column = binary.LittleEndian.AppendUint32(column, uint32(len(s)))
+		column = append(column, s...)
+	}
+	e.columns[i] = column
+	return nil
+}
+
+// flush writes the buffered rows as a row group with a single data page per column
+func (e *parquetEncoder) flush() error {
+	if e.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: e.rows}
+	for i := range e.columns {
+		header := parquetPageHeader(len(e.columns[i]), e.rows)
+		chunk := parquetColumnChunk{offset: e.offset, size: int64(len(header) + len(e.columns[i]))}
+		if err := e.write(header); err != nil {
+			return err
+		}
+		if err := e.write(e.columns[i]); err != nil {
+			return err
+		}
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.size
+		e.columns[i] = e.columns[i][:0]
+	}
+	e.rowGroups = append(e.rowGroups, group)
+	e.numRows += e.rows
+	e.rows = 0
+	return nil
+}
+
+func (e *parquetEncoder) write(data []byte) error {
+	n, err := e.w.Write(data)
+	e.offset += int64(n)
+	return err
+}
+
+// parquetType returns the physical type of a field
+func parquetType(field Field) int32 {
+	switch field.Type {
+	case Int:
+		return parquetInt64
+	case Float:
+		return parquetDouble
+	case Bool:
+		return parquetBoolean
+	default:
+		return parquetByteArray
+	}
+}
+
+// parquetPageHeader encodes the header of a data page
+func parquetPageHeader(size int, rows int64) []byte {
+	var t thriftWriter
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.beginStruct(5)
+	t.i32(1, int32(rows))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.endStruct()
+	t.stop()
+	return t.buf
+}
+
+// metadata encodes the file metadata with the schema and the row groups
+func (e *parquetEncoder) metadata() []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+
+	t.beginList(2, thriftStruct, len(e.fields)+1)
+	t.beginListStruct()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(e.fields)))
+	t.endListStruct()
+	for _, field := range e.fields {
+		t.beginListStruct()
+		t.i32(1, parquetType(field))
+		t.i32(3, parquetRequired)
+		t.binary(4, field.Name)
+		if field.Type == String {
+			t.i32(6, parquetUTF8)
+		}
+		t.endListStruct()
+	}
+
+	t.i64(3, e.numRows)
+
+	t.beginList(4, thriftStruct, len(e.rowGroups))
+	for _, group := range e.rowGroups {
+		t.beginListStruct()
+		t.beginList(1, thriftStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			t.beginListStruct()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, parquetType(e.fields[i]))
+			t.beginList(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.beginList(3, thriftBinary, 1)
+			t.listBinary(e.fields[i].Name)
+			t.i32(4, parquetUncompressed)
+			t.i64(5, group.rows)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endListStruct()
+		}
+		t.i64(2, group.size)
+		t.i64(3, group.rows)
+		t.endListStruct()
+	}
+
+	t.beginList(5, thriftStruct, 1)
+	t.beginListStruct()
+	t.binary(1, "schema_version")
+	t.binary(2, strconv.Itoa(e.version))
+	t.endListStruct()
+
+	t.binary(6, "logcat")
+	t.stop()
+	return t.buf
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol which Parquet uses for its metadata
+type thriftWriter struct {
+	buf []byte
+	// last is the ID of the previous field of the current struct, the ones of the enclosing structs are on the stack
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) varint(n int64) {
+	t.buf = binary.AppendUvarint(t.buf, uint64((n<<1)^(n>>63)))
+}
+
+func (t *thriftWriter) i32(id int16, n int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(n))
+}
+
+func (t *thriftWriter) i64(id int16, n int64) {
+	t.field(id, thriftI64)
+	t.varint(n)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thriftWriter) beginList(id int16, typ byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.buf = binary.AppendUvarint(t.buf, uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(n int32) {
+	t.varint(int64(n))
+}
+
+func (t *thriftWriter) listBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// beginStruct starts a struct field
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginListStruct()
+}
+
+// beginListStruct starts a struct which is an element of a list
+func (t *thriftWriter) beginListStruct() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.endListStruct()
+}
+
+func (t *thriftWriter) endListStruct() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// stop ends the fields of a struct
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+}
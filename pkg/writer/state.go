@@ -0,0 +1,84 @@
+package writer
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// State is a state of the lifecycle of a writer
+// The state is only changed by the writer loop, which is the only goroutine using the output file
+type State int32
+
+const (
+	// StateClosed is the state of a writer which was not started
+	StateClosed State = iota
+	// StateOpen is the state of a writer which writes to an output file
+	StateOpen
+	// StateRotating is the state of a writer which finalizes its output file and opens a new one
+	StateRotating
+	// StateDegraded is the state of a writer which can not open an output file and buffers the lines
+	StateDegraded
+	// StateStopped is the state of a writer which closed its output file and does not write anymore
+	StateStopped
+)
+
+// transitions are the states each state can change to
+var transitions = map[State][]State{
+	StateClosed:   {StateOpen},
+	StateOpen:     {StateRotating, StateStopped},
+	StateRotating: {StateOpen, StateDegraded},
+	StateDegraded: {StateOpen, StateStopped},
+	StateStopped:  {},
+}
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateRotating:
+		return "rotating"
+	case StateDegraded:
+		return "degraded"
+	case StateStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("State(%d)", int32(s))
+}
+
+// can returns whether the state can change to the other state
+func (s State) can(to State) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the current state of the writer
+func (w *Writer) State() State {
+	return State(atomic.LoadInt32(w.state))
+}
+
+// setState changes the state of the writer
+// A change which is not allowed is a bug in the writer loop, it is logged and the writer is degraded instead,
+// so it closes its output file and retries opening a new one
+func (w *Writer) setState(to State) {
+	from := w.State()
+	if !from.can(to) {
+		err := fmt.Errorf("writer can not change from state %v to %v", from, to)
+		w.Logger.Error("invalid writer state change, degrading the writer", "error", err)
+		atomic.StoreInt32(w.state, int32(StateDegraded))
+		if w.degraded == nil {
+			unwritten := w.closeFile()
+			w.degrade(err)
+			w.rewrite(unwritten)
+		}
+		return
+	}
+	atomic.StoreInt32(w.state, int32(to))
+	w.Logger.Debug("writer state changed", "from", from.String(), "to", to.String())
+}
@@ -0,0 +1,339 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/svetlyopet/logcat/pkg/metrics"
+)
+
+// syncBuffer is a buffer which can be written by the writer loop while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// transitions returns the state changes which were logged in order
+func (b *syncBuffer) transitions() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var changes []string
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		if !strings.Contains(line, "writer state changed") {
+			continue
+		}
+		fields := strings.Fields(line)
+		changes = append(changes, strings.TrimPrefix(fields[len(fields)-2], "from=")+"->"+strings.TrimPrefix(fields[len(fields)-1], "to="))
+	}
+	return changes
+}
+
+func TestState_Transitions(t *testing.T) {
+	allowed := map[string]bool{
+		"closed->open":       true,
+		"open->rotating":     true,
+		"open->stopped":      true,
+		"rotating->open":     true,
+		"rotating->degraded": true,
+		"degraded->open":     true,
+		"degraded->stopped":  true,
+	}
+	states := []State{StateClosed, StateOpen, StateRotating, StateDegraded, StateStopped}
+
+	for _, from := range states {
+		for _, to := range states {
+			name := from.String() + "->" + to.String()
+			if got := from.can(to); got != allowed[name] {
+				t.Errorf("%v.can(%v) = %v, want %v", from, to, got, allowed[name])
+			}
+		}
+	}
+	if State(42).String() != "State(42)" {
+		t.Errorf("String() = %v, want State(42)", State(42).String())
+	}
+}
+
+func TestWriter_SetStateInvalid(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var logs syncBuffer
+	writer := NewWriter(Writer{Directory: dir, Prefix: "invalid", Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	if err = writer.open(); err != nil {
+		t.Fatal("open() returned an error:", err)
+	}
+
+	// closed -> stopped is not allowed, so the writer closes its output file and is degraded
+	writer.setState(StateStopped)
+	if writer.State() != StateDegraded || writer.degraded == nil {
+		t.Errorf("State() - Expected state: %v, got: %v", StateDegraded, writer.State())
+	}
+	if writer.file != nil {
+		t.Error("setState() - Expected the output file to be closed")
+	}
+	if !strings.Contains(logs.buf.String(), "invalid writer state change") {
+		t.Errorf("Expected the invalid state change to be logged, got: %q", logs.buf.String())
+	}
+
+	// the degraded writer opens a new output file on the next attempt
+	writer.reopen()
+	if writer.State() != StateOpen || writer.file == nil {
+		t.Errorf("State() - Expected state: %v, got: %v", StateOpen, writer.State())
+	}
+	writer.closeFile()
+}
+
+func TestWriter_Lifecycle(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	outdir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs syncBuffer
+	writeQueue := make(chan WriteRequest)
+	doneChan := make(chan bool)
+	events := make(chan FileEvent, 10)
+	writer := NewWriter(Writer{
+		Directory:     outdir,
+		Prefix:        "lifecycle",
+		WriteQueue:    writeQueue,
+		DoneChan:      doneChan,
+		Logger:        slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		Finalized:     func(event FileEvent) { events <- event },
+		RetryInterval: 5 * time.Millisecond,
+		CheckInterval: 5 * time.Millisecond,
+	})
+	files := func() []string {
+		files, _ := filepath.Glob(filepath.Join(outdir, "lifecycle-*.log"))
+		return files
+	}
+
+	// closed -> open
+	if writer.State() != StateClosed {
+		t.Errorf("State() - Expected state: %v, got: %v", StateClosed, writer.State())
+	}
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 1"}
+	if writer.State() != StateOpen {
+		t.Errorf("State() - Expected state: %v, got: %v", StateOpen, writer.State())
+	}
+
+	// open -> rotating -> open when the file is rotated every hour
+	writer.tick <- true
+	event := <-events
+	if event.Records != 1 {
+		t.Errorf("Finalized - Expected 1 record in the rotated file, got: %d", event.Records)
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 2"}
+	if len(files()) != 2 {
+		t.Errorf("Expected 2 files after the rotation, got: %v", files())
+	}
+
+	// open -> rotating -> open when the file was removed
+	current := files()
+	for _, file := range current {
+		if file != event.File {
+			os.Remove(file)
+		}
+	}
+	event = <-events
+	if event.Records != 1 {
+		t.Errorf("Finalized - Expected 1 record in the removed file, got: %d", event.Records)
+	}
+	if !waitFor(func() bool { return len(files()) == 2 }) {
+		t.Errorf("Expected a new file after the file was removed, got: %v", files())
+	}
+
+	// open -> rotating -> degraded -> open when the directory is missing while rotating
+	if err = os.RemoveAll(outdir); err != nil {
+		t.Fatal(err)
+	}
+	writer.tick <- true
+	<-events
+	if !waitFor(func() bool { return writer.State() == StateDegraded }) {
+		t.Errorf("State() - Expected state: %v, got: %v", StateDegraded, writer.State())
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 3"}
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return writer.State() == StateOpen }) {
+		t.Errorf("State() - Expected state: %v, got: %v", StateOpen, writer.State())
+	}
+
+	// open -> stopped
+	close(writeQueue)
+	<-doneChan
+	if writer.State() != StateStopped {
+		t.Errorf("State() - Expected state: %v, got: %v", StateStopped, writer.State())
+	}
+	event = <-events
+	if event.Records != 1 {
+		t.Errorf("Finalized - Expected the buffered record in the last file, got: %d", event.Records)
+	}
+
+	want := []string{
+		"closed->open",
+		"open->rotating", "rotating->open",
+		"open->rotating", "rotating->open",
+		"open->rotating", "rotating->degraded", "degraded->open",
+		"open->stopped",
+	}
+	if got := logs.transitions(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected transitions: %v, got: %v", want, got)
+	}
+}
+
+func TestWriter_StopDegraded(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	outdir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeQueue := make(chan WriteRequest)
+	doneChan := make(chan bool)
+	writer := NewWriter(Writer{
+		Directory:     outdir,
+		Prefix:        "stop-degraded",
+		WriteQueue:    writeQueue,
+		DoneChan:      doneChan,
+		Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		CheckInterval: 5 * time.Millisecond,
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	// degraded -> stopped drops the buffered lines, which are not covered by the checkpoint
+	if err = os.RemoveAll(outdir); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return writer.State() == StateDegraded }) {
+		t.Fatalf("State() - Expected state: %v, got: %v", StateDegraded, writer.State())
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+	dropped := count(metrics.WriterDropped, "stop-degraded")
+	abandoned, err := writer.Stop(context.Background())
+	if err != nil {
+		t.Error("Stop() returned an error:", err)
+	}
+
+	if abandoned != 1 {
+		t.Errorf("Stop() - Expected the buffered line to be abandoned, got: %d", abandoned)
+	}
+	if got := count(metrics.WriterDropped, "stop-degraded") - dropped; got != 1 {
+		t.Errorf("Expected 1 dropped line in the metrics, got: %d", got)
+	}
+	if writer.State() != StateStopped {
+		t.Errorf("State() - Expected state: %v, got: %v", StateStopped, writer.State())
+	}
+	if writer.Checkpoint() != 0 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 0, got: %d", writer.Checkpoint())
+	}
+}
+
+func TestWriter_StopAbort(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	outdir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeQueue := make(chan WriteRequest, 2)
+	writer := NewWriter(Writer{
+		Directory:     outdir,
+		Prefix:        "stop-abort",
+		WriteQueue:    writeQueue,
+		DoneChan:      make(chan bool),
+		Logger:        slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		MaxBuffered:   1,
+		CheckInterval: 5 * time.Millisecond,
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	// the buffer of the degraded writer is full, so the next lines wait in the queue
+	if err = os.RemoveAll(outdir); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return writer.State() == StateDegraded }) {
+		t.Fatalf("State() - Expected state: %v, got: %v", StateDegraded, writer.State())
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+	if !waitFor(func() bool { return len(writeQueue) == 0 }) {
+		t.Fatal("Expected the line to be buffered")
+	}
+	writeQueue <- WriteRequest{Line: "Log entry 2", Offset: 20}
+	writeQueue <- WriteRequest{Line: "Log entry 3", Offset: 30}
+
+	// the queued and the buffered lines are abandoned and the writer loop stopped when Stop returns
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	abandoned, err := writer.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Stop() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if abandoned != 3 {
+		t.Errorf("Stop() - Expected 3 abandoned lines, got: %d", abandoned)
+	}
+	if writer.State() != StateStopped {
+		t.Errorf("State() - Expected state: %v, got: %v", StateStopped, writer.State())
+	}
+}
+
+func TestWriter_OpenUnique(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer := NewWriter(Writer{Directory: dir, Logger: slog.New(slog.NewTextHandler(&MockLogger{}, nil))})
+	names := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		if err = writer.open(); err != nil {
+			t.Fatal("open() returned an error:", err)
+		}
+		if names[writer.file.Name()] {
+			t.Errorf("open() - Expected a new file, got: %v again", writer.file.Name())
+		}
+		names[writer.file.Name()] = true
+		writer.closeFile()
+	}
+
+	// closing without an open file does nothing
+	writer.closeFile()
+}
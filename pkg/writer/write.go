@@ -1,6 +1,9 @@
 package writer
 
 // WriteRequest contains type that the writer uses
+// Offset is the position in the input file after the line the request was built from, 0 when it is unknown
+// A request with an empty line is not written and only advances the checkpoint of the writer
 type WriteRequest struct {
-	Line string
+	Line   string
+	Offset int64
 }
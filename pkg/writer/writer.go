@@ -1,24 +1,110 @@
 package writer
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/svetlyopet/logcat/pkg/metrics"
 )
 
+// DefaultPrefix is the file name prefix of the billing log files
+const DefaultPrefix = "artifactory-traffic"
+
+// DefaultCheckInterval is how often the writer checks whether its output file was removed or replaced
+const DefaultCheckInterval = time.Second
+
 // Writer describes a writer
+// After it was started the output file is only used by the writer loop
 type Writer struct {
-	File        *os.File
 	Directory   string
+	Prefix      string
 	Flag        int
 	Permissions os.FileMode
-	WriteQueue  chan string
+	WriteQueue  chan WriteRequest
 	DoneChan    chan bool
-	Logger      *log.Logger
+	Logger      *slog.Logger
+
+	// Metadata returns the settings of the line at an input offset, which are recorded in the manifest of each output file
+	// No manifest is written when it is nil
+	Metadata func(offset int64) map[string]string
+	// Changed returns whether the lines at two input offsets are written with different settings,
+	// in which case the later line starts a new output file so each file is written with the settings in its manifest
+	Changed func(from, to int64) bool
+
+	// Acks receives the result of each write request when it is not nil, so the sender can retry failed ones
+	Acks chan error
+
+	// Finalized is called by the writer loop when an output file is rotated or closed, it must not block
+	Finalized func(event FileEvent)
+
+	// Format is the encoding of the output files, the lines are written as they are when it is empty
+	// Fields are the columns of the CSV and Parquet formats and SchemaVersion is added by the NDJSON and Parquet formats
+	Format        Format
+	Fields        []Field
+	SchemaVersion int
+
+	// RetryInterval is the first wait before opening an output file is retried after it failed,
+	// the wait doubles after each failed attempt up to MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// MaxBuffered is the number of lines which are buffered while no output file can be opened,
+	// the writer stops taking lines from the queue when it is reached
+	// The lines are not buffered when Acks is set, they are acknowledged with an error so the sender retries them
+	MaxBuffered int
+	// FailureBudget is how long the writer can fail to open an output file before Failed is called
+	FailureBudget time.Duration
+	// Failed is called by the writer loop once when the failure budget is exhausted, it must not block
+	Failed func(err error)
+
+	// CheckInterval is how often the writer checks whether its output file was removed or replaced,
+	// in which case it opens a new one
+	CheckInterval time.Duration
+
+	// BufferSize is the size of the buffer the lines are collected in, which is written to the output file when it is full,
+	// every FlushInterval and when the file is rotated or closed
+	// The checkpoint only advances past the lines which were written to the file
+	// When Acks is set each line is written to the file before it is acknowledged, because the sender drops acknowledged lines
+	// The writes are not batched then and the Flush durability syncs the file for every line
+	BufferSize    int
+	FlushInterval time.Duration
+	// Durability is when the output file is synced to disk
+	Durability Durability
+
+	// state is the current State of the writer
+	state *int32
+	// checkpoint is the input offset of the last written request
+	checkpoint *int64
+	// abort stops the writer without writing the queued requests
+	abort chan struct{}
+	// stopped receives the number of requests which were not written when the writer loop stopped
+	stopped chan int
+	// tick triggers the rotation of the output file
+	tick chan bool
+
+	// file is the current output file and info is its identity when it was opened
+	file *os.File
+	info os.FileInfo
+	// stats collects what was written to the current output file
+	stats *fileStats
+	// out buffers the lines which are written to the current output file
+	out *bufio.Writer
+	// encoder writes the lines to the buffer in the format
+	encoder Encoder
+	// pending is the input offset of the last request which was buffered but not flushed
+	pending int64
+	// last is the input offset of the last line which was written and opened the one the manifest of the file was written for
+	last   int64
+	opened int64
+	// degraded is set while no output file can be opened
+	degraded *degradation
 }
 
 // NewWriter creates and returns a new Writer object
@@ -28,129 +114,369 @@ func NewWriter(w Writer) Writer {
 		w.Directory += "/"
 	}
 
+	// use the billing log file name prefix if no other is set
+	if w.Prefix == "" {
+		w.Prefix = DefaultPrefix
+	}
+	if w.RetryInterval <= 0 {
+		w.RetryInterval = DefaultRetryInterval
+	}
+	if w.MaxRetryInterval < w.RetryInterval {
+		w.MaxRetryInterval = DefaultMaxRetryInterval
+		if w.MaxRetryInterval < w.RetryInterval {
+			w.MaxRetryInterval = w.RetryInterval
+		}
+	}
+	if w.MaxBuffered <= 0 {
+		w.MaxBuffered = DefaultMaxBuffered
+	}
+	if w.FailureBudget <= 0 {
+		w.FailureBudget = DefaultFailureBudget
+	}
+	if w.CheckInterval <= 0 {
+		w.CheckInterval = DefaultCheckInterval
+	}
+	if w.BufferSize <= 0 {
+		w.BufferSize = DefaultBufferSize
+	}
+	if w.FlushInterval <= 0 {
+		w.FlushInterval = DefaultFlushInterval
+	}
+	if w.Durability == "" {
+		w.Durability = DurabilityNone
+	}
+	if w.Permissions == 0 {
+		w.Permissions = 0644
+	}
+
 	writer := Writer{
-		Directory:   w.Directory,
-		Flag:        os.O_APPEND | os.O_CREATE | os.O_WRONLY,
-		Permissions: 0644,
-		WriteQueue:  w.WriteQueue,
-		DoneChan:    w.DoneChan,
-		Logger:      w.Logger,
+		Directory:        w.Directory,
+		Prefix:           w.Prefix,
+		Flag:             os.O_APPEND | os.O_CREATE | os.O_WRONLY,
+		Permissions:      w.Permissions,
+		WriteQueue:       w.WriteQueue,
+		DoneChan:         w.DoneChan,
+		Logger:           w.Logger,
+		Metadata:         w.Metadata,
+		Changed:          w.Changed,
+		Acks:             w.Acks,
+		Finalized:        w.Finalized,
+		Format:           w.Format,
+		Fields:           w.Fields,
+		SchemaVersion:    w.SchemaVersion,
+		RetryInterval:    w.RetryInterval,
+		MaxRetryInterval: w.MaxRetryInterval,
+		MaxBuffered:      w.MaxBuffered,
+		FailureBudget:    w.FailureBudget,
+		Failed:           w.Failed,
+		CheckInterval:    w.CheckInterval,
+		BufferSize:       w.BufferSize,
+		FlushInterval:    w.FlushInterval,
+		Durability:       w.Durability,
+		state:            new(int32),
+		checkpoint:       new(int64),
+		abort:            make(chan struct{}),
+		stopped:          make(chan int, 1),
+		tick:             make(chan bool, 1),
 	}
 
 	return writer
 }
 
-// Start starts a writer
+// Start opens the first output file and starts the writer loop
 func (w *Writer) Start() error {
 	// create initial output file
-	if err := w.Open(w.Directory); err != nil {
+	if err := w.open(); err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 
-	// create channel for sending ticks to rotate output file
-	tick := make(chan bool, 1)
-
-	// create cron and set to send ticks every hour
+	// create cron and set to send ticks to rotate the output file every hour
 	c := cron.New()
-	_, err := c.AddFunc("0 * * * *", func() { tick <- true })
+	_, err := c.AddFunc("0 * * * *", func() {
+		select {
+		case w.tick <- true:
+		default:
+		}
+	})
 	if err != nil {
+		w.closeFile()
 		return fmt.Errorf("failed to start cron timer: %v", err)
 	}
 	c.Start()
+	w.setState(StateOpen)
 
-	go func() {
-		for {
-			select {
-			// listen for incoming log entries from the workers
-			case logEntry, ok := <-w.WriteQueue:
-				if !ok {
-					if err = w.File.Close(); err != nil {
-						w.Logger.Fatalf("failed to close output file: %v : %v", w.File.Name(), err)
-					}
-					w.Logger.Printf("stopping the writer")
-					w.DoneChan <- true
-					return
-				}
-				if err = w.Write(logEntry); err != nil {
-					w.Logger.Printf("failed writing to file: %v", err)
-				}
+	go w.run(c)
+	return nil
+}
+
+// run is the writer loop, which is the only goroutine changing the state and using the output file
+func (w *Writer) run(c *cron.Cron) {
+	defer c.Stop()
+	check := time.NewTicker(w.CheckInterval)
+	defer check.Stop()
+	flush := time.NewTicker(w.FlushInterval)
+	defer flush.Stop()
+
+	for {
+		// while the writer is degraded and its buffer is full the lines wait in the queue
+		queue := w.WriteQueue
+		if w.full() {
+			queue = nil
+		}
 
-			// listen for ticks to rotate output file
-			case <-tick:
-				if err = w.Close(); err != nil {
-					w.Logger.Fatalf("failed to close output file: %v : %v", w.File.Name(), err)
+		select {
+		// listen for incoming log entries from the workers
+		case request, ok := <-queue:
+			if !ok {
+				// try once more to write the buffered lines before stopping
+				if w.degraded != nil {
+					w.degraded.retry.Stop()
+					w.reopen()
 				}
-				if err = w.Open(w.Directory); err != nil {
-					w.Logger.Fatalf("failed to open output file: %v : %v", w.File.Name(), err)
+				w.stopped <- w.stop()
+				w.Logger.Info("stopping the writer")
+				select {
+				case w.DoneChan <- true:
+				case <-w.abort:
 				}
+				return
+			}
+			w.handle(request)
+
+		// stop without writing the queued requests when draining took too long
+		case <-w.abort:
+			queued := len(w.WriteQueue)
+			w.Logger.Warn("stopping the writer without writing the queued lines", "lines", queued)
+			w.stopped <- queued + w.stop()
+			return
+
+		// listen for ticks to rotate output file
+		case <-w.tick:
+			if w.degraded == nil {
+				w.rotate()
 			}
+
+		// write the buffered lines to the output file
+		case <-flush.C:
+			w.flush()
+
+		// open a new output file when the current one was removed
+		case <-check.C:
+			w.check()
+
+		// retry opening an output file while the writer is degraded
+		case <-w.retry():
+			w.reopen()
 		}
-	}()
-	return nil
+	}
 }
 
-// Close closes the file descriptor of the current file
-func (w *Writer) Close() error {
-	if _, err := w.File.Stat(); err != nil {
-		return err
+// handle writes a request to the current output file and acknowledges it
+// While the writer is degraded the request is buffered, or acknowledged with an error when the sender retries failed requests
+func (w *Writer) handle(request WriteRequest) {
+	// a line which is written with other settings than the ones in the manifest of the output file starts a new file
+	if w.degraded == nil && request.Line != "" && request.Offset > 0 && w.Changed != nil && w.Changed(w.opened, request.Offset) {
+		w.last = request.Offset
+		w.rotate()
 	}
-	if w.File != nil {
-		if err := w.File.Close(); err != nil {
-			return err
+	if w.degraded != nil {
+		if !w.buffer(request) {
+			w.ack(fmt.Errorf("output file is not open: %v", w.degraded.err))
 		}
+		return
 	}
-	return nil
+
+	var writeErr error
+	if request.Line != "" {
+		if writeErr = w.write(request); writeErr != nil {
+			w.Logger.Error("failed writing to file", "file", w.file.Name(), "error", writeErr)
+		}
+	}
+	// the checkpoint only advances past lines which were written
+	if writeErr == nil && request.Offset > 0 {
+		w.pending = request.Offset
+		w.last = request.Offset
+	}
+	// the sender drops the line when it is acknowledged, so it has to be in the file first
+	if writeErr == nil && w.Acks != nil {
+		writeErr = w.flush()
+	}
+	w.ack(writeErr)
+}
+
+// ack sends the result of a request to the sender when it waits for it
+func (w *Writer) ack(err error) {
+	if w.Acks == nil {
+		return
+	}
+	select {
+	case w.Acks <- err:
+	case <-w.abort:
+	}
+}
+
+// rotate finalizes the current output file and opens a new one
+// The lines which could not be written to the current file are written to the new one
+// The writer is degraded when the new file can not be opened
+func (w *Writer) rotate() {
+	w.setState(StateRotating)
+	unwritten := w.closeFile()
+	if err := w.open(); err != nil {
+		w.degrade(err)
+	} else {
+		w.setState(StateOpen)
+	}
+	w.rewrite(unwritten)
+}
+
+// check rotates the output file when it was removed or replaced by another file
+// The identity of the file is compared with the one it had when it was opened, so the lines are written without checking the file
+func (w *Writer) check() {
+	if w.file == nil {
+		return
+	}
+	info, err := os.Stat(w.file.Name())
+	if err == nil && os.SameFile(info, w.info) {
+		return
+	}
+	w.Logger.Warn("output file was removed or replaced, opening a new one", "file", w.file.Name())
+	w.rotate()
+}
+
+// stop closes the output file and drops the buffered lines
+// It returns the number of lines which were dropped
+func (w *Writer) stop() int {
+	dropped := w.discard()
+	if unwritten := w.closeFile(); len(unwritten) > 0 {
+		w.Logger.Error("stopping the writer without writing the lines which failed to be written", "lines", len(unwritten))
+		metrics.WriterDropped.Add(w.Prefix, int64(len(unwritten)))
+		dropped += len(unwritten)
+	}
+	w.setState(StateStopped)
+	return dropped
 }
 
-// Open creates a new file with unique name in the dir path for writing the output
-func (w *Writer) Open(dir string) error {
-	t := time.Now()
-	timestamp := t.Format("2006-01-02")
+// closeFile completes the encoding, flushes and closes the current output file if there is one and finalizes it
+// It returns the requests whose lines could not be written to the file, which are retried by the sender instead when Acks is set
+func (w *Writer) closeFile() []WriteRequest {
+	if w.file == nil {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		err = w.encoder.Close()
+	}
+	if ferr := w.out.Flush(); ferr == nil {
+		w.advance()
+	} else {
+		metrics.WriterFlushFailures.Add(w.Prefix, 1)
+		if err == nil {
+			err = ferr
+		}
+	}
+	if err == nil && w.Durability == DurabilityRotation {
+		if err = w.file.Sync(); err == nil {
+			metrics.WriterSyncs.Add(w.Prefix, 1)
+		}
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		w.Logger.Error("failed to close output file", "file", w.file.Name(), "error", err)
+	}
+	var unwritten []WriteRequest
+	if w.Acks == nil {
+		unwritten = w.stats.unwritten()
+	}
+	w.finalize()
+	w.file, w.info, w.out, w.encoder = nil, nil, nil, nil
+	w.pending = 0
+	return unwritten
+}
 
-	random := GenerateRandomString(8)
+// open creates a new file with a unique name in the directory for writing the output
+func (w *Writer) open() error {
+	timestamp := time.Now().Format("2006-01-02")
 
-	filename := "artifactory-traffic-" + timestamp + "-" + random + ".log"
+	// the file is created exclusively, so a name which is taken is never reused
 	// looping 10 times should be sufficient to get a unique string from random func to have as file name
+	var file *os.File
+	var err error
 	for i := 0; i < 10; i++ {
-		if _, err := os.Stat(dir + filename); err != nil {
-			random = GenerateRandomString(8)
-			filename = "artifactory-traffic-" + timestamp + "-" + random + ".log"
-		} else {
+		filename := w.Prefix + "-" + timestamp + "-" + GenerateRandomString(8) + w.Format.Extension()
+		file, err = os.OpenFile(w.Directory+filename, w.Flag|os.O_CREATE|os.O_EXCL, w.Permissions)
+		if !os.IsExist(err) {
 			break
 		}
 	}
+	if err != nil {
+		return err
+	}
 
-	var err error
-
-	w.File, err = os.OpenFile(dir+filename, w.Flag, w.Permissions)
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
+		return err
+	}
+	stats := newFileStats(file.Name())
+	out := bufio.NewWriterSize(&fileSink{file: file, stats: stats, prefix: w.Prefix, sync: w.Durability == DurabilityFlush}, w.BufferSize)
+	encoder, err := NewEncoder(w.Format, io.MultiWriter(out, stats), w.Fields, w.SchemaVersion)
+	if err != nil {
+		file.Close()
 		return err
 	}
-	return nil
-}
 
-// Write writes input strings to the last open file
-// When the last open file does not exist, a new one is created
-func (w *Writer) Write(line string) error {
-	// check if the output file created by the writer exists
-	// create a new one if its missing
-	if _, err := os.Stat(w.File.Name()); err != nil {
-		if err = w.Open(w.Directory); err != nil {
+	// record the settings the file is written with
+	if w.Metadata != nil {
+		if err = WriteManifest(file.Name(), w.Metadata(w.last)); err != nil {
+			file.Close()
 			return err
 		}
 	}
+	w.file, w.info, w.stats, w.out, w.encoder = file, info, stats, out, encoder
+	w.opened = w.last
+	return nil
+}
 
-	// if File is set write to it
-	if w.File != nil {
-		if _, err := w.File.WriteString(line + "\n"); err != nil {
-			return err
-		}
+// write writes the line of a request to the buffer of the current output file
+func (w *Writer) write(request WriteRequest) error {
+	if err := w.encoder.Encode(request.Line); err != nil {
+		return err
 	}
+	w.stats.line(request)
 	return nil
 }
 
+// finalize reports that the current output file is not written anymore
+func (w *Writer) finalize() {
+	if w.Finalized != nil && w.stats != nil {
+		w.Finalized(w.stats.event())
+	}
+	w.stats = nil
+}
+
 // Stop closes the write queue of the writer which triggers a graceful stop
-func (w *Writer) Stop() {
+// and waits until the queued requests are written or the context is done, in which case it waits for the writer loop to stop
+// It returns the number of requests which were abandoned because the context was done first
+// or because they were buffered while no output file could be opened
+func (w *Writer) Stop(ctx context.Context) (int, error) {
 	// close the work queue
 	close(w.WriteQueue)
+
+	select {
+	case <-w.DoneChan:
+		return <-w.stopped, nil
+	case <-ctx.Done():
+		close(w.abort)
+		if w.State() == StateClosed {
+			return len(w.WriteQueue), ctx.Err()
+		}
+		return <-w.stopped, ctx.Err()
+	}
+}
+
+// Checkpoint returns the input offset of the last written request, 0 when none was written
+func (w *Writer) Checkpoint() int64 {
+	return atomic.LoadInt64(w.checkpoint)
 }
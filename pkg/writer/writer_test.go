@@ -1,9 +1,13 @@
 package writer
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -14,6 +18,45 @@ func (l *MockLogger) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+func TestNewWriter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+
+	// Create a Writer without a prefix
+	writer := NewWriter(Writer{
+		Directory: "/tmp",
+		Logger:    logger,
+	})
+	if writer.Directory != "/tmp/" {
+		t.Errorf("NewWriter() - Expected Directory: %s, got: %s", "/tmp/", writer.Directory)
+	}
+	if writer.Prefix != DefaultPrefix {
+		t.Errorf("NewWriter() - Expected Prefix: %s, got: %s", DefaultPrefix, writer.Prefix)
+	}
+
+	// Create a Writer with a custom prefix
+	writer = NewWriter(Writer{
+		Directory: "/tmp/",
+		Prefix:    "logcat-dead-letter",
+		Logger:    logger,
+	})
+	if writer.Prefix != "logcat-dead-letter" {
+		t.Errorf("NewWriter() - Expected Prefix: %s, got: %s", "logcat-dead-letter", writer.Prefix)
+	}
+	if writer.Permissions != 0644 {
+		t.Errorf("NewWriter() - Expected Permissions: %v, got: %v", os.FileMode(0644), writer.Permissions)
+	}
+
+	// Create a Writer with restricted permissions
+	writer = NewWriter(Writer{
+		Directory:   "/tmp/",
+		Permissions: 0600,
+		Logger:      logger,
+	})
+	if writer.Permissions != 0600 {
+		t.Errorf("NewWriter() - Expected Permissions: %v, got: %v", os.FileMode(0600), writer.Permissions)
+	}
+}
+
 func TestWriter_Start(t *testing.T) {
 	// Create a temporary directory for testing
 	dir, err := os.MkdirTemp("", "test")
@@ -23,9 +66,9 @@ func TestWriter_Start(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Create a Writer instance with a mock logger
-	writeQueue := make(chan string)
+	writeQueue := make(chan WriteRequest)
 	doneChan := make(chan bool)
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
 	writer := NewWriter(Writer{
 		Directory:  dir,
 		WriteQueue: writeQueue,
@@ -42,7 +85,7 @@ func TestWriter_Start(t *testing.T) {
 	}()
 
 	// Write a log entry to the write queue
-	writeQueue <- "Log entry 1"
+	writeQueue <- WriteRequest{Line: "Log entry 1"}
 
 	// Close the write queue to trigger stopping the writer
 	close(writeQueue)
@@ -88,9 +131,9 @@ func TestWriter_Stop(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Create a Writer instance with a mock logger
-	writeQueue := make(chan string)
+	writeQueue := make(chan WriteRequest)
 	doneChan := make(chan bool)
-	logger := log.New(&MockLogger{}, "", 0)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
 	writer := NewWriter(Writer{
 		Directory:  dir,
 		WriteQueue: writeQueue,
@@ -105,14 +148,87 @@ func TestWriter_Stop(t *testing.T) {
 	time.Sleep(time.Millisecond * 100)
 
 	// Stop the writer
-	writer.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 
-	// Verify that the done channel receives a signal
-	select {
-	case <-doneChan:
-		// Writer stopped successfully
-	case <-time.After(time.Second):
-		t.Error("Writer did not stop within the timeout")
+	// Verify that the writer stopped before the deadline
+	abandoned, err := writer.Stop(ctx)
+	if err != nil {
+		t.Error("Writer did not stop within the timeout:", err)
+	}
+	if abandoned != 0 {
+		t.Errorf("Stop() - Expected no abandoned lines, got: %d", abandoned)
+	}
+}
+
+func TestWriter_StopTimeout(t *testing.T) {
+	// Create a Writer which is not started, so the queued requests are never written
+	writeQueue := make(chan WriteRequest, 3)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	writer := NewWriter(Writer{
+		Directory:  "/tmp",
+		WriteQueue: writeQueue,
+		DoneChan:   make(chan bool),
+		Logger:     logger,
+	})
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+	writeQueue <- WriteRequest{Line: "Log entry 2", Offset: 20}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	abandoned, err := writer.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Stop() - Expected error: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if abandoned != 2 {
+		t.Errorf("Stop() - Expected 2 abandoned lines, got: %d", abandoned)
+	}
+	if writer.Checkpoint() != 0 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 0, got: %d", writer.Checkpoint())
+	}
+}
+
+func TestWriter_Checkpoint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeQueue := make(chan WriteRequest)
+	doneChan := make(chan bool)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
+	writer := NewWriter(Writer{
+		Directory:  dir,
+		WriteQueue: writeQueue,
+		DoneChan:   doneChan,
+		Logger:     logger,
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	// Requests without a line only advance the checkpoint
+	writeQueue <- WriteRequest{Line: "Log entry 1", Offset: 10}
+	writeQueue <- WriteRequest{Offset: 25}
+	close(writeQueue)
+	<-doneChan
+
+	if writer.Checkpoint() != 25 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 25, got: %d", writer.Checkpoint())
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, DefaultPrefix+"-*.log"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d: %v", len(files), err)
+	}
+	fileContent, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal("Failed to read file:", err)
+	}
+	if string(fileContent) != "Log entry 1\n" {
+		t.Errorf("Unexpected file content. Expected: %q, Got: %q", "Log entry 1\n", string(fileContent))
 	}
 }
 
@@ -125,9 +241,9 @@ func TestWriter_Write(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Create a Writer instance with a mock logger
-	writeQueue := make(chan string)
+	writeQueue := make(chan WriteRequest)
 	doneChan := make(chan bool)
-	logger := log.New(&MockLogger{}, "", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(&MockLogger{}, nil))
 	writer := NewWriter(Writer{
 		Directory:  dir,
 		WriteQueue: writeQueue,
@@ -144,7 +260,7 @@ func TestWriter_Write(t *testing.T) {
 	}()
 
 	// Write a log entry to the write queue
-	writeQueue <- "Log entry 1"
+	writeQueue <- WriteRequest{Line: "Log entry 1"}
 
 	// Close the write queue to trigger stopping the writer
 	close(writeQueue)
@@ -187,3 +303,88 @@ func TestMockLogger_Write(t *testing.T) {
 	// Call the Write method
 	logger.Write([]byte("test message"))
 }
+
+func TestWriter_Acks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeQueue := make(chan WriteRequest)
+	acks := make(chan error)
+	doneChan := make(chan bool)
+	writer := NewWriter(Writer{
+		Directory:  dir,
+		WriteQueue: writeQueue,
+		DoneChan:   doneChan,
+		Acks:       acks,
+		Logger:     slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		Format:     CSV,
+		Fields:     []Field{{Name: "user_name", Type: String}},
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	writeQueue <- WriteRequest{Line: `{"user_name":"user"}`, Offset: 10}
+	if err = <-acks; err != nil {
+		t.Errorf("Start() - Expected write to be confirmed, got: %v", err)
+	}
+
+	// A failed write is reported and does not advance the checkpoint
+	writeQueue <- WriteRequest{Line: "Log entry 2", Offset: 20}
+	if err = <-acks; err == nil {
+		t.Error("Start() - Expected write to fail")
+	}
+	if writer.Checkpoint() != 10 {
+		t.Errorf("Checkpoint() - Expected checkpoint: 10, got: %d", writer.Checkpoint())
+	}
+
+	close(writeQueue)
+	<-doneChan
+}
+
+func TestWriter_Finalized(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeQueue := make(chan WriteRequest)
+	doneChan := make(chan bool)
+	events := make(chan FileEvent, 1)
+	writer := NewWriter(Writer{
+		Directory:  dir,
+		WriteQueue: writeQueue,
+		DoneChan:   doneChan,
+		Logger:     slog.New(slog.NewTextHandler(&MockLogger{}, nil)),
+		Finalized:  func(event FileEvent) { events <- event },
+	})
+	if err = writer.Start(); err != nil {
+		t.Fatal("Start() returned an error:", err)
+	}
+
+	writeQueue <- WriteRequest{Line: "Log entry 1"}
+	writeQueue <- WriteRequest{Line: "Log entry 2"}
+	close(writeQueue)
+	<-doneChan
+
+	// The closed file is reported with its record count and checksum
+	event := <-events
+	content, err := os.ReadFile(event.File)
+	if err != nil {
+		t.Fatal("Failed to read finalized file:", err)
+	}
+	sum := sha256.Sum256(content)
+	if event.Records != 2 || event.Bytes != int64(len(content)) {
+		t.Errorf("Finalized - Expected 2 records and %d bytes, got: %d, %d", len(content), event.Records, event.Bytes)
+	}
+	if event.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Finalized - Expected checksum: %x, got: %v", sum, event.SHA256)
+	}
+	if event.From.IsZero() || event.To.Before(event.From) {
+		t.Errorf("Finalized - Unexpected time range: %v - %v", event.From, event.To)
+	}
+}